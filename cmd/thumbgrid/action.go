@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+)
+
+type fileAction struct {
+	Op  string // "copy" or "move"
+	Dir string
+}
+
+func parseAction(s string) (fileAction, error) {
+	if s == "" {
+		return fileAction{}, nil
+	}
+	op, dir, ok := strings.Cut(s, ":")
+	op = strings.ToLower(strings.TrimSpace(op))
+	if !ok || dir == "" || (op != "copy" && op != "move") {
+		return fileAction{}, fmt.Errorf("invalid action %q (expected copy:DIR or move:DIR)", s)
+	}
+	return fileAction{Op: op, Dir: dir}, nil
+}
+
+// run copies or moves sel into the target directory, never overwriting:
+// a taken name gets a " (2)" style suffix. It stops at the first failure,
+// which says what was already done.
+func (a fileAction) run(sel []picker.Selection, status func(string)) error {
+	if err := os.MkdirAll(a.Dir, 0o755); err != nil {
+		return err
+	}
+	verb := map[string]string{"copy": "copying", "move": "moving"}[a.Op]
+	var done []string
+	for i, s := range sel {
+		status(fmt.Sprintf("%s %d/%d: %s", verb, i+1, len(sel), s.Name))
+		dest, err := a.put(s.Path)
+		if err != nil {
+			err = fmt.Errorf("%s %s: %w", a.Op, s.Path, err)
+			if len(done) > 0 {
+				past := map[string]string{"copy": "copied", "move": "moved"}[a.Op]
+				err = fmt.Errorf("%w; %d of %d %s before it:\n  %s", err, len(done), len(sel), past, strings.Join(done, "\n  "))
+			}
+			return err
+		}
+		done = append(done, s.Path+" -> "+dest)
+	}
+	return nil
+}
+
+// put copies or moves the file at src into a.Dir and returns where it went.
+func (a fileAction) put(src string) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	// The name is taken first, by creating it, so that a file turning up
+	// under it meanwhile isn't overwritten: the rename or copy goes over
+	// the empty file or directory made here.
+	create := func(p string) error {
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			err = f.Close()
+		}
+		return err
+	}
+	if info.IsDir() {
+		create = func(p string) error { return os.Mkdir(p, 0o700) }
+	}
+	dest, err := claimName(a.Dir, filepath.Base(src), create)
+	if err != nil {
+		return "", err
+	}
+	if a.Op == "move" {
+		err = moveFile(src, dest, info)
+	} else {
+		err = copyFile(src, dest, info)
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+// claimName creates base in dir, or the first free name with a " (2)"
+// style suffix, and returns its path.
+func claimName(dir, base string, create func(path string) error) (string, error) {
+	p := filepath.Join(dir, base)
+	ext := filepath.Ext(base)
+	for i := 2; ; i++ {
+		err := create(p)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		p = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+}
+
+// moveFile renames src over dest, the placeholder claimName made. Where
+// that can't work, e.g. to another file system or drive, a file is copied
+// and then removed; the copy is taken back if src can't be removed.
+func moveFile(src, dest string, info os.FileInfo) error {
+	err := os.Rename(src, dest)
+	if err == nil || info.IsDir() {
+		return err
+	}
+	if err := copyFile(src, dest, info); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src into dest, which exists, keeping its mode and mtime.
+func copyFile(src, dest string, info os.FileInfo) error {
+	if info.IsDir() {
+		return errors.New("is a directory")
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(dest, info.Mode().Perm())
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(dest, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+const cacheUsage = `thumbgrid cache <command>
+
+Commands:
+  stats                       Show entry count, size, and oldest entry
+  clean [-failed]             Remove every cached thumbnail, or with -failed
+                              only the record of files that failed to render,
+                              so they are tried again
+  prune [-older-than AGE] [-max-mb N]
+                              Remove thumbnails unused for AGE (e.g. 30d),
+                              then least recently used ones above N MiB`
+
+func runCacheCommand(args []string, dir string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, cacheUsage)
+		return 64
+	}
+	switch args[0] {
+	case "stats":
+		entries, err := thumb.CacheEntries(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: cache stats: %v\n", err)
+			return 74
+		}
+		var total int64
+		var oldest time.Time
+		for _, e := range entries {
+			total += e.Size
+			if oldest.IsZero() || e.Used.Before(oldest) {
+				oldest = e.Used
+			}
+		}
+		fmt.Printf("cache:   %s\n", dir)
+		fmt.Printf("entries: %d\n", len(entries))
+		fmt.Printf("size:    %s\n", meta.HumanSize(total))
+		if !oldest.IsZero() {
+			fmt.Printf("oldest:  %s\n", oldest.Format("2006-01-02 15:04"))
+		}
+		return 0
+	case "clean":
+		fs := flag.NewFlagSet("cache clean", flag.ContinueOnError)
+		failed := fs.Bool("failed", false, "Only forget which files failed to render")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 64
+		}
+		if *failed {
+			n, err := thumb.ForgetFailures(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "thumbgrid: cache clean: %v\n", err)
+				return 74
+			}
+			fmt.Printf("forgot %d failed files\n", n)
+			return 0
+		}
+		n, freed, err := thumb.Clean(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: cache clean: %v\n", err)
+			return 74
+		}
+		fmt.Printf("removed %d entries (%s)\n", n, meta.HumanSize(freed))
+		return 0
+	case "prune":
+		fs := flag.NewFlagSet("cache prune", flag.ContinueOnError)
+		olderThan := fs.String("older-than", "", "Remove thumbnails unused for this long, e.g. 30d")
+		maxMB := fs.Int("max-mb", 0, "Evict least recently used thumbnails above this size")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 64
+		}
+		age, err := thumb.ParseAge(*olderThan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: cache prune: %v\n", err)
+			return 64
+		}
+		if age == 0 && *maxMB <= 0 {
+			fmt.Fprintln(os.Stderr, "thumbgrid: cache prune: need -older-than or -max-mb")
+			return 64
+		}
+		n, freed, err := thumb.Prune(dir, thumb.CachePolicy{MaxBytes: int64(max(0, *maxMB)) << 20, MaxAge: age})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: cache prune: %v\n", err)
+			return 74
+		}
+		fmt.Printf("removed %d entries (%s)\n", n, meta.HumanSize(freed))
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "thumbgrid: unknown cache command %q\n\n%s\n", args[0], cacheUsage)
+		return 64
+	}
+}
+
+// pruneInBackground applies the cache limits while the grid is open and
+// reports what it removed, if anything.
+func pruneInBackground(cfg Config) <-chan string {
+	msgs := make(chan string, 1)
+	go func() {
+		defer close(msgs)
+		n, freed, err := thumb.Prune(cfg.CacheDir, thumb.CachePolicy{MaxBytes: cfg.CacheMaxBytes, MaxAge: cfg.CacheMaxAge})
+		switch {
+		case err != nil:
+			msgs <- "cache prune: " + err.Error()
+		case n > 0:
+			msgs <- fmt.Sprintf("cache pruned: %d thumbnails, %s freed", n, meta.HumanSize(freed))
+		}
+	}()
+	return msgs
+}
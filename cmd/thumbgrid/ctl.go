@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/config"
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+)
+
+const ctlUsage = `thumbgrid ctl [-socket PATH] COMMAND [ARG]
+
+Drive a thumbgrid started with -listen. COMMAND is an action from the [keys]
+config section, e.g. down, toggle_select or accept, which presses its usual
+key (select-next and select-prev are right and left), or one of:
+  filter images|videos|both   Show only images or videos
+  search TEXT                 Search as if TEXT was typed after /
+  select PATH                 Move the cursor to PATH
+  current                     Print the path under the cursor`
+
+// A control connection carries one ctlRequest line and gets one ctlReply
+// line back.
+type ctlRequest struct {
+	Command string `json:"command"`
+	Arg     string `json:"arg,omitempty"`
+}
+
+type ctlReply struct {
+	Out   string `json:"out,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+var ctlAliases = map[string]string{
+	"select_next": "right",
+	"select_prev": "left",
+}
+
+// controlSocket is where -listen listens and ctl connects unless told
+// otherwise.
+func controlSocket(cacheDir string) string {
+	if v := os.Getenv("THUMBGRID_CONTROL_SOCKET"); v != "" {
+		return v
+	}
+	if x := os.Getenv("XDG_RUNTIME_DIR"); x != "" {
+		return filepath.Join(x, "thumbgrid-ctl.sock")
+	}
+	return filepath.Join(cacheDir, "ctl.sock")
+}
+
+// listenFlag is -listen, which takes a socket path or, alone, means the
+// default one.
+type listenFlag struct {
+	path string
+	set  bool
+}
+
+func (l *listenFlag) String() string   { return l.path }
+func (l *listenFlag) IsBoolFlag() bool { return true }
+
+func (l *listenFlag) Set(v string) error {
+	switch v {
+	case "true":
+		l.set = true
+	case "false":
+		l.set, l.path = false, ""
+	default:
+		l.set, l.path = true, v
+	}
+	return nil
+}
+
+// listenControl accepts ctl connections on path and hands their commands to
+// the picker until stop is called.
+func listenControl(path string) (<-chan picker.Command, func(), error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s is in use by another thumbgrid", path)
+	}
+	_ = os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	_ = os.Chmod(path, 0o600)
+	cmds := make(chan picker.Command)
+	done := make(chan struct{})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(conn, cmds, done)
+		}
+	}()
+	return cmds, func() { close(done); l.Close() }, nil
+}
+
+func serveControl(conn net.Conn, cmds chan<- picker.Command, done <-chan struct{}) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	var req ctlRequest
+	rep := ctlReply{Error: "thumbgrid exited"}
+	if err := json.Unmarshal(line, &req); err != nil {
+		rep.Error = "bad request: " + err.Error()
+	} else {
+		replies := make(chan ctlReply, 1)
+		c := picker.Command{Name: req.Command, Arg: req.Arg, Reply: func(out string, err error) {
+			r := ctlReply{Out: out}
+			if err != nil {
+				r.Error = err.Error()
+			}
+			replies <- r
+		}}
+		select {
+		case cmds <- c:
+			select {
+			case rep = <-replies:
+			case <-done:
+			}
+		case <-done:
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(rep)
+}
+
+func runCtlCommand(args []string, fc config.File) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, ctlUsage) }
+	socket := fs.String("socket", controlSocket(defaultCacheDir(fc.CacheDir)), "Socket of the thumbgrid to drive")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 && fs.Arg(0) != "search" {
+		fs.Usage()
+		return 64
+	}
+	req := ctlRequest{Command: strings.ReplaceAll(fs.Arg(0), "-", "_"), Arg: strings.Join(fs.Args()[1:], " ")}
+	if name, ok := ctlAliases[req.Command]; ok {
+		req.Command = name
+	}
+	if req.Command == "select" && req.Arg != "" {
+		// The picker may run in another directory, so resolve it here.
+		if abs, err := filepath.Abs(req.Arg); err == nil {
+			req.Arg = abs
+		}
+	}
+	conn, err := net.DialTimeout("unix", *socket, 2*time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: ctl: no thumbgrid listening on %s\n", *socket)
+		return 65
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	var rep ctlReply
+	if err = json.NewEncoder(conn).Encode(req); err == nil {
+		err = json.NewDecoder(conn).Decode(&rep)
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "thumbgrid: ctl: %v\n", err)
+		return 65
+	case rep.Error != "":
+		fmt.Fprintf(os.Stderr, "thumbgrid: ctl: %s\n", rep.Error)
+		return 65
+	}
+	if rep.Out != "" {
+		fmt.Println(rep.Out)
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/ck-zhang/thumbgrid/internal/config"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+const daemonUsage = `thumbgrid daemon [-socket PATH] [-jobs N]
+
+Render thumbnails for every thumbgrid on this machine, and for previewers
+through "thumbgrid thumb", with one pool of N workers. Clients find the
+daemon at the default socket by themselves and render on their own when it
+is not running.`
+
+const thumbUsage = `thumbgrid thumb [-size WxH] FILE...
+
+Print the path of a WxH pixel thumbnail of each FILE, rendered by the daemon
+when it runs, e.g. for an lf or ranger previewer.`
+
+// daemonSocket is where the daemon listens and clients look for it.
+func daemonSocket(cacheDir string) string {
+	if v := os.Getenv("THUMBGRID_DAEMON_SOCKET"); v != "" {
+		return v
+	}
+	if x := os.Getenv("XDG_RUNTIME_DIR"); x != "" {
+		return filepath.Join(x, "thumbgrid.sock")
+	}
+	return filepath.Join(cacheDir, "daemon.sock")
+}
+
+func runDaemonCommand(args []string, fc config.File) int {
+	cfg, err := thumbConfig(fc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: daemon: config: %v\n", err)
+		return 64
+	}
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, daemonUsage) }
+	socket := fs.String("socket", daemonSocket(cfg.CacheDir), "Socket to listen on")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Thumbnails to generate at once")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	if *jobs < 1 {
+		fmt.Fprintln(os.Stderr, "thumbgrid: daemon: -jobs must be at least 1")
+		return 64
+	}
+	if conn, err := net.Dial("unix", *socket); err == nil {
+		conn.Close()
+		fmt.Fprintf(os.Stderr, "thumbgrid: daemon: already running on %s\n", *socket)
+		return 65
+	}
+	// Nothing answered, so a socket file left there is stale.
+	_ = os.Remove(*socket)
+	if err := os.MkdirAll(filepath.Dir(*socket), 0o700); err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: daemon: %v\n", err)
+		return 74
+	}
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: daemon: %v\n", err)
+		return 74
+	}
+	_ = os.Chmod(*socket, 0o600)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	gen := newGenerator(cfg)
+	gen.Daemon = ""
+	fmt.Fprintf(os.Stderr, "thumbgrid: daemon listening on %s\n", *socket)
+	if err := thumb.Serve(ctx, l, gen, *jobs); err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: daemon: %v\n", err)
+		return 65
+	}
+	return 0
+}
+
+func runThumbCommand(args []string, fc config.File) int {
+	cfg, err := thumbConfig(fc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: thumb: config: %v\n", err)
+		return 64
+	}
+	fs := flag.NewFlagSet("thumb", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, thumbUsage) }
+	size := fs.String("size", defaultWarmSize(fc, defaultBackend(fc)), "Thumbnail size in pixels")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	w, h, err := parseSize(*size)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: thumb: %v\n", err)
+		return 64
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 64
+	}
+	gen := newGenerator(cfg)
+	status := 0
+	for _, p := range fs.Args() {
+		out, err := gen.GenerateRect(context.Background(), p, w, h)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: thumb: %s: %v\n", p, err)
+			status = 65
+			continue
+		}
+		fmt.Println(out)
+	}
+	return status
+}
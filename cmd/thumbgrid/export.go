@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/config"
+)
+
+const exportUsage = `thumbgrid export-html [-size WxH] [-copy] [-title TEXT] [-sort FIELD] [-order asc|desc]
+                      [-filter image|video|both] [-hidden] OUT_DIR [PATH]
+
+Write a static gallery of the images and videos under PATH (default .) to
+OUT_DIR: index.html, a grid that fits any screen, and its thumbnails in
+OUT_DIR/thumbs. Tiles link to the files where they are, or with -copy to
+copies in OUT_DIR/files, so OUT_DIR can be shared on its own.`
+
+var galleryPage = template.Must(template.New("index.html").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; padding: 1rem; font: 14px system-ui, sans-serif; background: #111; color: #ddd; }
+h1 { margin: 0 0 1rem; font-size: 1.2rem; font-weight: normal; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax({{.MinWidth}}px, 1fr)); gap: .75rem; }
+figure { margin: 0; min-width: 0; }
+figure a { display: block; aspect-ratio: {{.Width}} / {{.Height}}; background: #222; border-radius: 4px; }
+img { width: 100%; height: 100%; object-fit: contain; }
+figcaption { margin-top: .25rem; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.video figcaption::before { content: "▶ "; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} · {{len .Items}} files</h1>
+<div class="grid">
+{{- range .Items}}
+<figure class="{{.Kind}}"><a href="{{.Href}}">{{if .Thumb}}<img src="{{.Thumb}}" alt="" loading="lazy">{{end}}</a><figcaption title="{{.Name}}">{{.Name}}</figcaption></figure>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+type galleryItem struct {
+	Name, Kind, Href, Thumb string
+}
+
+func runExportCommand(args []string, fc config.File) int {
+	cfg, err := thumbConfig(fc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: export-html: config: %v\n", err)
+		return 64
+	}
+	fs := flag.NewFlagSet("export-html", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, exportUsage) }
+	sf := addScanFlags(fs, fc, "320x240")
+	copyFiles := fs.Bool("copy", false, "Copy the files into OUT_DIR/files instead of linking to them")
+	title := fs.String("title", "", "Page title (default: the directory name)")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	if fs.NArg() == 0 || fs.NArg() > 2 {
+		fs.Usage()
+		return 64
+	}
+	outDir := fs.Arg(0)
+	cfg.Path = orDefault(fs.Arg(1), ".")
+	// An earlier export inside PATH is not part of the gallery.
+	absOut := toAbs(outDir)
+	gal, status := sf.thumbnailed("export-html", cfg, absOut)
+	if status != 0 {
+		return status
+	}
+	cands, thumbs, w, h := gal.cands, gal.thumbs, gal.w, gal.h
+
+	dirs := []string{"thumbs"}
+	if *copyFiles {
+		dirs = append(dirs, "files")
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(outDir, d), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "thumbgrid: export-html: %v\n", err)
+			return 74
+		}
+	}
+	items := make([]galleryItem, len(cands))
+	used := make(map[string]bool)
+	for i, c := range cands {
+		it := galleryItem{Name: c.Name, Kind: c.Kind}
+		if thumbs[i] != "" {
+			it.Thumb = fmt.Sprintf("thumbs/%05d%s", i, filepath.Ext(thumbs[i]))
+			if err := replaceFile(thumbs[i], filepath.Join(outDir, filepath.FromSlash(it.Thumb))); err != nil {
+				fmt.Fprintf(os.Stderr, "thumbgrid: export-html: %v\n", err)
+				return 74
+			}
+		}
+		target := toAbs(c.Path)
+		if *copyFiles {
+			// Files from different directories can share a name.
+			name, ext := c.Name, filepath.Ext(c.Name)
+			for n := 2; used[name]; n++ {
+				name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(c.Name, ext), n, ext)
+			}
+			used[name] = true
+			target = filepath.Join(absOut, "files", name)
+			if err := replaceFile(c.Path, target); err != nil {
+				fmt.Fprintf(os.Stderr, "thumbgrid: export-html: %v\n", err)
+				return 74
+			}
+		}
+		if rel, err := filepath.Rel(absOut, target); err == nil {
+			target = rel
+		}
+		it.Href = (&url.URL{Path: filepath.ToSlash(target)}).String()
+		items[i] = it
+	}
+
+	f, err := os.Create(filepath.Join(outDir, "index.html"))
+	if err == nil {
+		err = galleryPage.Execute(f, map[string]any{
+			"Title":    orDefault(*title, filepath.Base(toAbs(cfg.Path))),
+			"Items":    items,
+			"Width":    w,
+			"Height":   h,
+			"MinWidth": min(w, 240),
+		})
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: export-html: %v\n", err)
+		return 74
+	}
+	fmt.Fprintf(os.Stderr, "%d files, %d without thumbnails: %s\n", len(items), countEmpty(thumbs), filepath.Join(outDir, "index.html"))
+	return 0
+}
+
+// scanFlags choose the files and thumbnail size for export-html and sheet.
+type scanFlags struct {
+	size, filter, sortBy, order *string
+	jobs                        *int
+	hidden                      *bool
+}
+
+func addScanFlags(fs *flag.FlagSet, fc config.File, size string) scanFlags {
+	return scanFlags{
+		size:   fs.String("size", size, "Thumbnail size in pixels"),
+		filter: fs.String("filter", orDefault(fc.Filter, filterBoth), "Filter candidate types"),
+		sortBy: fs.String("sort", orDefault(fc.Sort, "mtime"), "Sort field, as for the grid"),
+		order:  fs.String("order", orDefault(fc.Order, "desc"), "Order: asc|desc"),
+		jobs:   fs.Int("jobs", runtime.NumCPU(), "Thumbnails to generate at once"),
+		hidden: fs.Bool("hidden", false, "Include dotfiles and directories such as .cache"),
+	}
+}
+
+// A gallery is the files a scan found, in order, with their thumbnails
+// ("" for those that failed) at w×h.
+type gallery struct {
+	cands  []Candidate
+	thumbs []string
+	w, h   int
+}
+
+// thumbnailed scans cfg.Path as the flags say, leaving out skip and
+// anything under it, and renders the thumbnails. A status other than 0 is the exit
+// status, with the reason already printed.
+func (f scanFlags) thumbnailed(cmd string, cfg Config, skip string) (gallery, int) {
+	w, h, err := parseSize(*f.size)
+	if err == nil && *f.jobs < 1 {
+		err = fmt.Errorf("-jobs must be at least 1")
+	}
+	if err == nil {
+		cfg.Filter, err = normalizeFilter(*f.filter)
+	}
+	if err == nil {
+		_, err = candidateLess(*f.sortBy, *f.order, 0, "")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: %s: %v\n", cmd, err)
+		return gallery{}, 64
+	}
+	cfg.Hidden, cfg.SortBy, cfg.Order = *f.hidden, *f.sortBy, *f.order
+	cands, err := collectScan(startScan(cfg, false), cfg.Hidden, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: %s: %v\n", cmd, err)
+		return gallery{}, 65
+	}
+	if skip != "" {
+		cands = slices.DeleteFunc(cands, func(c Candidate) bool {
+			p := toAbs(c.Path)
+			return p == skip || strings.HasPrefix(p, skip+string(filepath.Separator))
+		})
+	}
+	if len(cands) == 0 {
+		fmt.Fprintf(os.Stderr, "thumbgrid: %s: no candidates for filter %q in %s\n", cmd, cfg.Filter, toAbs(cfg.Path))
+		return gallery{}, 66
+	}
+	_ = sortCandidates(cands, cfg.SortBy, cfg.Order, 0, "")
+	paths := make([]string, len(cands))
+	for i, c := range cands {
+		paths[i] = c.Path
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	thumbs := renderThumbs(ctx, newGenerator(cfg), paths, w, h, *f.jobs)
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: %s: interrupted\n", cmd)
+		return gallery{}, 130
+	}
+	return gallery{cands, thumbs, w, h}, 0
+}
+
+// replaceFile copies src to dest, over whatever an earlier export left there.
+func replaceFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The history file has a line per accepted path, oldest first: an RFC 3339
+// time, a tab and the absolute path. Past maxHistory lines the oldest go.
+const maxHistory = 5000
+
+type historyEntry struct {
+	when time.Time
+	path string
+}
+
+func readHistory() []historyEntry {
+	f, err := os.Open(statePath("history"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var out []historyEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		ts, path, ok := strings.Cut(sc.Text(), "\t")
+		when, err := time.Parse(time.RFC3339, ts)
+		if ok && err == nil && path != "" {
+			out = append(out, historyEntry{when, path})
+		}
+	}
+	return out
+}
+
+// appendHistory records paths as accepted now. It is best effort, like
+// saveZoom.
+func appendHistory(paths []string) {
+	p := statePath("history")
+	if p == "" || len(paths) == 0 {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	var b strings.Builder
+	for _, path := range paths {
+		if !strings.ContainsAny(path, "\n\r") {
+			b.WriteString(now + "\t" + path + "\n")
+		}
+	}
+	if h := readHistory(); len(h)+len(paths) > maxHistory {
+		var keep strings.Builder
+		for _, e := range h[max(0, len(h)+len(paths)-maxHistory):] {
+			keep.WriteString(e.when.Format(time.RFC3339) + "\t" + e.path + "\n")
+		}
+		tmp := p + ".tmp"
+		if os.WriteFile(tmp, []byte(keep.String()+b.String()), 0o600) == nil && os.Rename(tmp, p) == nil {
+			return
+		}
+		_ = os.Remove(tmp)
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	_, _ = f.WriteString(b.String())
+	_ = f.Close()
+}
+
+// historyPaths lists past picks for -history, most recent first, each path
+// once, with the rank lastPicked sorts them by.
+func historyPaths() ([]string, map[string]int) {
+	h := readHistory()
+	slices.Reverse(h)
+	var paths []string
+	rank := make(map[string]int)
+	for _, e := range h {
+		if _, ok := rank[e.path]; !ok {
+			rank[e.path] = len(paths)
+			paths = append(paths, e.path)
+		}
+	}
+	return paths, rank
+}
+
+// lastPicked orders -history candidates, most recently picked first.
+func lastPicked(rank map[string]int) func(a, b Candidate) bool {
+	return func(a, b Candidate) bool { return rank[a.Path] < rank[b.Path] }
+}
@@ -1,25 +1,29 @@
-//go:build !windows
-
 package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sort"
+	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/ck-zhang/thumbgrid/internal/config"
+	"github.com/ck-zhang/thumbgrid/internal/index"
+	"github.com/ck-zhang/thumbgrid/internal/label"
+	"github.com/ck-zhang/thumbgrid/internal/meta"
 	"github.com/ck-zhang/thumbgrid/internal/term"
-	"github.com/ck-zhang/thumbgrid/internal/thumb"
-	runewidth "github.com/mattn/go-runewidth"
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
 	xt "golang.org/x/term"
 )
 
@@ -29,96 +33,470 @@ var (
 )
 
 type Config struct {
-	Path     string
-	CacheDir string
-	Filter   string
-	SortBy   string
-	Order    string
+	Path            string
+	CacheDir        string
+	CacheMaxBytes   int64
+	CacheMaxAge     time.Duration
+	CacheFormat     string
+	XDGThumbnails   bool
+	Jobs            int
+	Nice            bool
+	StripCols       int
+	StripRows       int
+	VideoSeek       thumb.Seek
+	Filter          string
+	MaxDepth        int
+	Hidden          bool
+	NoIgnore        bool
+	FollowSymlinks  bool
+	Sniff           bool
+	Browse          bool
+	GroupBy         string
+	Layout          string
+	Globs           []string
+	Regex           *regexp.Regexp
+	MinRating       int
+	Tags            []string
+	UseIndex        bool
+	Index           *index.Index
+	SortBy          string
+	Order           string
+	Seed            uint64
+	Print0          bool
+	Output          string
+	Format          string
+	Backend         string
+	OpenCmd         string
+	AllowDelete     bool
+	Rate            bool
+	Action          fileAction
+	TileWidth       int
+	TileHeight      int
+	Columns         int
+	Height          string
+	Query           string
+	Expect          []string
+	Select          string
+	Select1         bool
+	Exit0           bool
+	Listen          string
+	Watch           bool
+	NoTUI           bool
+	TakeFirst       int
+	Limit           int
+	RememberZoom    bool
+	Resume          bool
+	SortGiven       bool
+	History         bool
+	RecordHistory   bool
+	WheelCursor     bool
+	HighlightSearch bool
+	Keys            map[string]string
+	Colors          map[string]string
+	// Entered, when set, is told each directory a scan walks, which
+	// -watch then watches.
+	Entered func(dir string)
 }
 
-type Candidate struct {
-	Path  string
-	Name  string
-	Size  int64
-	MTime time.Time
-	Kind  string
-}
+type Candidate = picker.Candidate
 
 const (
 	filterBoth       = "both"
 	filterImages     = "images"
 	filterVideos     = "videos"
 	selectionFileEnv = "THUMBGRID_SELECTION_FILE"
+	outputLines      = "lines"
+	outputJSON       = "json"
+	groupDir         = "dir"
+	groupDate        = "date"
+	layoutGrid       = "grid"
+	layoutJustified  = "justified"
 )
 
 func main() {
-	cfg, err := parseFlags()
+	fc, err := config.Load(config.DefaultPath())
 	if err != nil {
-		fatalUsage(64, err.Error())
+		fatalUsage(64, "config: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		os.Exit(runCacheCommand(os.Args[2:], defaultCacheDir(fc.CacheDir)))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(runWarmCommand(os.Args[2:], fc))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		os.Exit(runDaemonCommand(os.Args[2:], fc))
 	}
-	if cfg.Path == "" {
-		cfg.Path = "."
+	if len(os.Args) > 1 && os.Args[1] == "thumb" {
+		os.Exit(runThumbCommand(os.Args[2:], fc))
 	}
-	cands, err := scanPath(cfg.Path, cfg)
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtlCommand(os.Args[2:], fc))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export-html" {
+		os.Exit(runExportCommand(os.Args[2:], fc))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sheet" {
+		os.Exit(runSheetCommand(os.Args[2:], fc))
+	}
+	cfg, err := parseFlags(fc)
 	if err != nil {
-		fatalUsage(65, "scan error: %v", err)
+		fatalUsage(64, "%v", err)
 	}
-
-	cands = filterCandidates(cands, cfg.Filter)
-	if len(cands) == 0 {
-		fatalUsage(66, "no candidates for filter %q in %s", cfg.Filter, toAbs(cfg.Path))
+	if cfg.UseIndex {
+		// Another thumbgrid may have the index open; this one then does
+		// without.
+		if err := os.MkdirAll(cfg.CacheDir, 0o755); err == nil {
+			cfg.Index, _ = index.Open(filepath.Join(cfg.CacheDir, "index.db"))
+		}
+		meta.UseIndex(cfg.Index)
 	}
-
-	if err := sortCandidates(cands, cfg.SortBy, cfg.Order); err != nil {
+	stdinTTY := isTerminal(os.Stdin.Fd())
+	fromStdin := !cfg.History && !stdinTTY && stdinPiped() && (cfg.Path == "" || cfg.Path == "-")
+	source := "stdin"
+	if cfg.History {
+		source = "history"
+	} else if !fromStdin {
+		if cfg.Path == "" {
+			cfg.Path = "."
+		}
+		source = toAbs(cfg.Path)
+	}
+	less, err := candidateLess(cfg.SortBy, cfg.Order, cfg.Seed, cfg.GroupBy)
+	if err != nil {
 		fatalUsage(65, "sort: %v", err)
 	}
-
-	sel := []string{}
-	if isTerminal(os.Stdin.Fd()) && isTerminal(os.Stdout.Fd()) {
-		out, code, err := runGridTUI(cands, cfg)
-		if err != nil {
-			fatalUsage(code, err.Error())
+	opts := picker.Options{
+		Less:             less,
+		Sorts:            sortCycle(cfg.SortBy, cfg.Order, cfg.Seed, cfg.GroupBy, cfg.lazyStat()),
+		Backend:          cfg.Backend,
+		OpenCmd:          cfg.OpenCmd,
+		AllowDelete:      cfg.AllowDelete,
+		Rate:             cfg.Rate,
+		LazyLabels:       !cfg.filterLabels(),
+		ShowHidden:       cfg.Hidden,
+		Justified:        cfg.Layout == layoutJustified,
+		TileWidth:        cfg.TileWidth,
+		TileHeight:       cfg.TileHeight,
+		Columns:          cfg.Columns,
+		Limit:            cfg.Limit,
+		Jobs:             cfg.Jobs,
+		Height:           cfg.Height,
+		Query:            cfg.Query,
+		Select:           cfg.Select,
+		SelectOne:        cfg.Select1,
+		Expect:           cfg.Expect,
+		WheelMovesCursor: cfg.WheelCursor,
+		HighlightSearch:  cfg.HighlightSearch,
+		Keys:             cfg.Keys,
+		Colors:           cfg.Colors,
+	}
+	if cfg.GroupBy == groupDate {
+		opts.GroupTitle = dateTitle
+	}
+	if cfg.History && !cfg.SortGiven {
+		_, rank := historyPaths()
+		opts.Less = lastPicked(rank)
+		opts.Sorts = append([]picker.Sort{{Name: "last picked", Less: opts.Less}}, opts.Sorts...)
+	}
+	if cfg.RememberZoom {
+		opts.Zoom, opts.OnZoom = loadZoom(), saveZoom
+	}
+	if err := opts.Validate(); err != nil {
+		fatalUsage(64, "config: %v", err)
+	}
+	if cfg.Browse && fromStdin {
+		fatalUsage(64, "-browse needs a directory, not paths on stdin")
+	}
+	if cfg.Browse && cfg.History {
+		fatalUsage(64, "-browse and -history can't be combined")
+	}
+	if cfg.Watch && (cfg.Browse || cfg.History || fromStdin) {
+		fatalUsage(64, "-watch needs a directory, and can't be combined with -browse or -history")
+	}
+
+	var sel []picker.Selection
+	pressed := ""
+	// The grid is drawn on the terminal even when stdout is piped on, so
+	// that only the accepted paths go down the pipe.
+	ttyIn, ttyOut := os.Stdin, os.Stdout
+	if cfg.NoTUI {
+		ttyIn, ttyOut = nil, nil
+	} else {
+		if !stdinTTY {
+			ttyIn = nil
+			if tty, err := term.OpenTTY(); err == nil {
+				ttyIn = tty
+			}
+		}
+		if !isTerminal(os.Stdout.Fd()) {
+			ttyOut = nil
+			if tty, err := term.OpenTTYOut(); err == nil {
+				ttyOut = tty
+			}
+		}
+	}
+	interactive := ttyIn != nil && ttyOut != nil
+	if !interactive && !cfg.NoTUI {
+		fatalUsage(64, "no terminal to show the grid on; -take-all or -take-first N print the files without it")
+	}
+	if cfg.Action.Op != "" {
+		opts.Accept = cfg.Action.run
+	}
+	opts.AcceptedBy = func(key string) { pressed = key }
+	if interactive {
+		opts.Thumbnails = newGenerator(cfg)
+		opts.Messages = pruneInBackground(cfg)
+		opts.In, opts.Out = ttyIn, ttyOut
+		// The watch starts first, so that the scan tells it which
+		// directories to watch as it walks them.
+		stopWatching := func() {}
+		if cfg.Watch {
+			if opts.Updates, cfg.Entered, stopWatching, err = watchTree(cfg); err != nil {
+				fatalUsage(65, "watch: %v", err)
+			}
+		}
+		if cfg.Browse {
+			opts.Browse, opts.Dir = browser(cfg), source
+		} else {
+			opts.Source = startScan(cfg, fromStdin)
+			if !fromStdin && !cfg.History {
+				opts.Rescan = rescanner(cfg)
+			}
+		}
+		if cfg.Resume && !fromStdin {
+			// Flags given this time win over the remembered state.
+			if st := loadSession(cfg.CacheDir, source); st != nil {
+				if cfg.SortGiven {
+					st.Sort = ""
+				}
+				if !cfg.RememberZoom {
+					st.Zoom = 0
+				}
+				if cfg.Select != "" {
+					st.Cursor = cfg.Select
+				}
+				opts.Resume = st
+			}
+			opts.OnExit = func(st picker.State) { saveSession(cfg.CacheDir, source, st) }
+		}
+		stopListening := func() {}
+		if cfg.Listen != "" {
+			if opts.Control, stopListening, err = listenControl(cfg.Listen); err != nil {
+				fatalUsage(65, "listen: %v", err)
+			}
+		}
+		ctx, caught := trapSignals()
+		res, err := picker.Run(ctx, nil, opts)
+		stopListening()
+		stopWatching()
+		_ = cfg.Index.Close()
+		if sig := caught(); sig != nil {
+			fatalUsage(128+int(sig.(syscall.Signal)), "%v", sig)
+		}
+		switch {
+		case errors.Is(err, picker.ErrNoCandidates) && cfg.Exit0:
+			os.Exit(0)
+		case errors.Is(err, picker.ErrNoCandidates):
+			fatalUsage(66, "no candidates for filter %q in %s", cfg.Filter, source)
+		case errors.Is(err, picker.ErrCanceled):
+			fatalUsage(130, "%v", err)
+		case err != nil:
+			fatalUsage(65, "%v", err)
+		}
+		sel = res
+		if cfg.RecordHistory {
+			appendHistory(selectionPaths(sel))
+		}
+		if cfg.Action.Op != "" {
+			os.Exit(0)
 		}
-		sel = out
 	} else {
-
-		sel = make([]string, 0, len(cands))
-		for _, c := range cands {
-			sel = append(sel, toAbs(c.Path))
+		cands, err := collectScan(startScan(cfg, fromStdin), cfg.Hidden, cfg.Limit)
+		_ = cfg.Index.Close()
+		if err != nil {
+			fatalUsage(65, "scan error: %v", err)
+		}
+		if len(cands) == 0 && cfg.Exit0 {
+			os.Exit(0)
+		}
+		if len(cands) == 0 {
+			fatalUsage(66, "no candidates for filter %q in %s", cfg.Filter, source)
+		}
+		if !cfg.History || cfg.SortGiven {
+			_ = sortCandidates(cands, cfg.SortBy, cfg.Order, cfg.Seed, cfg.GroupBy)
+		}
+		if cfg.TakeFirst > 0 && len(cands) > cfg.TakeFirst {
+			cands = cands[:cfg.TakeFirst]
+		}
+		for i, c := range cands {
+			sel = append(sel, picker.Selection{Candidate: c, Index: i})
+		}
+		if cfg.Action.Op != "" {
+			if err := cfg.Action.run(sel, func(string) {}); err != nil {
+				fatalUsage(65, "%v", err)
+			}
+			os.Exit(0)
 		}
 	}
 
 	selectionFile := strings.TrimSpace(os.Getenv(selectionFileEnv))
 	if selectionFile != "" {
-		if err := writeSelectionFile(selectionFile, sel); err != nil {
+		if err := writeSelectionFile(selectionFile, selectionPaths(sel)); err != nil {
 			fatalUsage(74, "write selection file: %v", err)
 		}
 	}
 
-	for _, p := range sel {
-		fmt.Fprintln(os.Stdout, p)
+	if err := writeOutput(os.Stdout, pressed, sel, cfg); err != nil {
+		fatalUsage(74, "write output: %v", err)
 	}
 
 	os.Exit(0)
 }
 
-func parseFlags() (Config, error) {
+func parseFlags(fc config.File) (Config, error) {
 	help := flag.Bool("help", false, "Show help")
 	showVersion := flag.Bool("version", false, "Print version and exit")
-	filter := flag.String("filter", "both", "Filter: image|video|both")
-	sortBy := flag.String("sort", "mtime", "Sort: name|mtime|size")
-	order := flag.String("order", "desc", "Order: asc|desc")
+	filter := flag.String("filter", orDefault(fc.Filter, "both"), "Filter: image|video|both")
+	maxDepth := flag.Int("max-depth", 0, "Descend at most N directory levels (0 = no limit)")
+	noRecurse := flag.Bool("no-recurse", false, "Only list the top directory, same as -max-depth 1")
+	hidden := flag.Bool("hidden", false, "Show dotfiles and files under dot or junk directories")
+	noIgnore := flag.Bool("no-ignore", false, "Don't skip files listed in .gitignore or .thumbgridignore")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Descend into symlinked directories")
+	sniff := flag.Bool("sniff", false, "Recognise files with a missing or unknown extension by their content")
+	browse := flag.Bool("browse", false, "Show one directory at a time, with folder tiles to open")
+	groupBy := flag.String("group", "none", "Group tiles under headers: none|dir|date")
+	layout := flag.String("layout", orDefault(fc.Layout, layoutGrid), "Tile layout: grid|justified")
+	var globs stringList
+	flag.Var(&globs, "glob", "Only file names matching this pattern, e.g. '*.png' (repeatable)")
+	regex := flag.String("regex", "", "Only paths matching this regular expression")
+	minRating := flag.Int("min-rating", 0, "Only files rated at least N stars (1-5)")
+	var tags stringList
+	flag.Var(&tags, "tag", "Only files tagged with this tag (repeatable; all must match)")
+	query := flag.String("query", "", "Start with this search typed in")
+	selectPath := flag.String("select", "", "Start with the cursor on this file")
+	history := flag.Bool("history", false, "Pick again from earlier selections, most recent first")
+	expect := flag.String("expect", "", "Comma-separated keys that also accept, printed first, e.g. ctrl-o,f1")
+	select1 := flag.Bool("select-1", false, "Accept without showing the grid when there is only one file")
+	exit0 := flag.Bool("exit-0", false, "Exit quietly with status 0 when there are no files")
+	takeFirst := flag.Int("take-first", 0, "Print the first N files in sort order without showing the grid")
+	takeAll := flag.Bool("take-all", false, "Print every file in sort order without showing the grid")
+	limit := flag.Int("limit", 0, "Read at most N files, loading more on request in the grid (0 = all)")
+	noTUI := flag.Bool("no-tui", false, "Never show the grid, even on a terminal (same as -take-all)")
+	watch := flag.Bool("watch", false, "Add, update and remove files in the grid as they change on disk")
+	var listen listenFlag
+	flag.Var(&listen, "listen", "Take commands from thumbgrid ctl on this socket (alone: the default one)")
+	useIndex := flag.Bool("index", fc.Index, "Keep file metadata in a database in the cache dir so later runs skip unchanged files")
+	resume := flag.Bool("resume", false, "Start where the last -resume run in this directory left off")
+	sortBy := flag.String("sort", orDefault(fc.Sort, "mtime"), "Sort: name|natural|mtime|size|resolution|duration|random")
+	seed := flag.Uint64("seed", 0, "Seed for -sort random (0 = new shuffle each run)")
+	order := flag.String("order", orDefault(fc.Order, "desc"), "Order: asc|desc")
+	print0 := flag.Bool("print0", false, "Terminate output paths with NUL")
+	output := flag.String("output", outputLines, "Output: lines|json")
+	formatFlag := flag.String("format", "", "Print each item with a template, e.g. '{path}\\t{size}'")
+	cacheMaxMB := flag.Int("cache-max-mb", defaultCacheMaxMB(), "Prune least recently used thumbnails above this size (0 = unlimited)")
+	jobs := flag.Int("jobs", fc.Jobs, "Thumbnails to make at once (0 = half the CPUs, 2 to 8)")
+	nice := flag.Bool("nice", fc.Nice, "Run thumbnail tools at low CPU and I/O priority")
+	xdgThumbs := flag.Bool("xdg-thumbnails", os.Getenv("THUMBGRID_XDG_THUMBNAILS") != "", "Share thumbnails with file managers via ~/.cache/thumbnails")
+	cacheMaxAge := flag.String("cache-max-age", os.Getenv("THUMBGRID_CACHE_MAX_AGE"), "Prune thumbnails unused for this long, e.g. 30d")
+	cacheFormat := flag.String("cache-format", fc.CacheFormat, "Store new thumbnails as png, webp or avif")
+	filmstrip := flag.String("filmstrip", fc.Filmstrip, "Show videos as a contact sheet: 2x2, 3x1, ...")
+	backend := flag.String("backend", defaultBackend(fc), "Graphics: kitty|sixel|iterm2|chafa|blocks|braille|none|auto")
+	videoSeek := flag.String("video-seek", fc.VideoSeek, "Video frame to thumbnail: 25% or 00:00:05")
+	openCmd := flag.String("open-cmd", fc.OpenCmd, "Viewer run by x; %s is the file")
+	action := flag.String("action", "", "On accept, copy:DIR or move:DIR instead of printing paths")
+	allowDelete := flag.Bool("allow-delete", false, "Let d move files to the trash")
+	rate := flag.Bool("rate", false, "Let 1 to 5 rate files, and 0 clear the rating, instead of counting")
+	columns := flag.Int("columns", fc.Columns, "Tiles per row, sizing tiles to fit (0 = as many as fit)")
+	tileWidth := flag.Int("tile-width", fc.TileWidth, "Tile width in cells (0 = default)")
+	tileHeight := flag.Int("tile-height", fc.TileHeight, "Tile height in cells (0 = default)")
+	height := flag.String("height", fc.Height, "Draw inline in this many lines or percent of the terminal, e.g. 15 or 40%")
 	flag.Parse()
 
 	if *help {
 		fmt.Fprintln(os.Stdout, `thumbgrid [PATH]
+command | thumbgrid [-]
+thumbgrid cache stats|clean|prune
+thumbgrid warm [-size WxH] [-jobs N] [PATH]
+thumbgrid daemon [-socket PATH] [-jobs N]
+thumbgrid thumb [-size WxH] FILE...
+thumbgrid ctl [-socket PATH] COMMAND [ARG]
+thumbgrid export-html [-size WxH] [-copy] OUT_DIR [PATH]
+thumbgrid sheet -o FILE [-cols N] [-size WxH] [PATH]
 
 Minimal grid selector for images and videos.
+Paths piped on stdin (newline or NUL separated) replace the directory walk.
 
 Options:
   -filter image|video|both    Filter candidate types
-  -sort name|mtime|size       Sort order field
+  -max-depth N                Descend at most N levels; 1 is the top directory only
+  -no-recurse                 Same as -max-depth 1
+  -hidden                     Show dotfiles and anything under .cache, node_modules and the like
+  -no-ignore                  Include files matched by .gitignore and .thumbgridignore
+  -follow-symlinks            Descend into symlinked directories (each directory is walked once)
+  -sniff                      Look inside files with a missing or unknown extension
+  -watch                      Show files as they are created, changed or deleted under PATH
+                              while the grid is open (hidden directories with -hidden)
+  -browse                     Show one directory at a time; Enter opens folders, Backspace goes up
+  -glob PATTERN               Only file names matching PATTERN, e.g. '*.png' (repeatable)
+  -regex RE                   Only paths matching the regular expression RE
+  -min-rating N               Only files given at least N stars with r
+  -tag TAG                    Only files tagged TAG with t (repeatable; each has to be there)
+  -query STR                  Start with STR typed into the / search
+  -select PATH                Start with the cursor on PATH, e.g. the file picked last time
+  -expect KEYS                Keys that accept too, e.g. ctrl-o,ctrl-d,f1; the one pressed is
+                              printed on the first line (empty for Enter), as with fzf
+  -select-1                   Accept the only file when the scan finds just one
+  -exit-0                     Exit with status 0 and no output when nothing is found
+  -take-first N               Print the first N files in sort order instead of showing the grid,
+                              e.g. -sort mtime -take-first 5 for the newest five
+  -take-all / -no-tui         Print every file in sort order instead of showing the grid;
+                              -action applies to them too
+  -limit N                    Read only the first N files the scan finds, for directories too big
+                              to hold at once; M or the row under the grid loads N more
+  -listen[=PATH]              Take commands from thumbgrid ctl, e.g. from window manager key
+                              bindings; see thumbgrid ctl -help
+  -history                    Pick from files accepted before (with history = true in the
+                              config), most recent first
+  -index                      Remember dimensions, durations, capture dates and details in
+                              CACHE_DIR/index.db, so big libraries start without probing
+                              every file again (index = true in the config turns it on)
+  -resume                     Start on the file, scroll position, zoom and sort the last
+                              -resume run in this directory ended with
+  -sort name|natural|mtime|size|resolution|duration|random
+                              Sort order field; natural puts IMG_2 before IMG_10,
+                              resolution and duration probe each file (ffprobe for videos)
+  -group none|dir|date        Show files under a header per directory, or per day/month taken
+                              (EXIF date, else mtime); z folds one, Z all
+  -layout grid|justified      Fixed tiles, or rows of tiles as wide as each image's aspect ratio
+  -columns N                  Fix the number of tiles per row; tiles are sized to fit
+  -tile-width N               Tile width in cells
+  -tile-height N              Tile height in cells (with any of these three, +/- zoom
+                              is not remembered for the next run)
+  -height N|N%                Draw inline below the prompt in N lines (or N% of the terminal)
+                              instead of full screen, e.g. for shell key bindings
+  -seed N                     Repeat a -sort random shuffle (default: new each run)
   -order asc|desc             Sort direction
+  -print0                     Separate output paths with NUL instead of newline
+  -output lines|json          Print paths, or a JSON array with metadata
+  -format TEMPLATE            Print each item as TEMPLATE, e.g. '{path}\t{size}\t{mtime}', with
+                              {path} {relpath} {name} {kind} {size} {mtime} {index}
+  -cache-max-mb N             Cap the thumbnail cache, evicting least recently used (default 1024)
+  -cache-max-age AGE          Expire thumbnails unused for AGE, e.g. 30d or 72h
+  -cache-format png|webp|avif Store new thumbnails as lossy WebP or AVIF, several times smaller
+                              than PNG (needs magick, or ffmpeg for WebP; default png)
+  -xdg-thumbnails             Read and write the shared freedesktop.org thumbnail cache
+  -jobs N                     Make N thumbnails at once (default half the CPUs, 2 to 8)
+  -nice                       Run thumbnail tools at low CPU and I/O priority (nice, ionice)
+  -filmstrip CxR              Show videos as a CxR contact sheet of frames, e.g. 2x2 or 3x1
+  -backend NAME               Force graphics: kitty, sixel, iterm2, chafa, blocks, braille, none
+                              or auto
+  -open-cmd CMD               Viewer for x; the path is appended (default xdg-open/open)
+  -video-seek POS             Grab video thumbnails at POS, a percentage (25%) or time (00:00:05); default 10%
+  -action copy:DIR|move:DIR   Copy or move the accepted files into DIR instead of printing them
+  -allow-delete               Let d move the current or selected files to the trash
+  -rate                       Let 1 to 5 rate the current or selected files, and 0 clear
+                              the rating, in place of count prefixes
   -version                    Print version and exit
   -help                       Show this help text
 
@@ -128,14 +506,56 @@ Keys:
   Ctrl-B / Ctrl-F             Scroll by a page
   g g                         Jump to top
   G                           Jump to bottom
+  0 / $ or Home / End         First / last item in the row
+  H / L                       First / last tile on screen
+  N j, N G, ...               Repeat a move N times, or go to item N with G / gg
   + / -                       Resize tiles
   p                           Toggle previews
-  Enter                       Accept selection(s)
+  i / F2                      Switch between tiles and a detail list
+  I                           Info panel: dimensions, camera settings, codecs
+  M                           Load more files, past -limit
+  .                           Show / hide hidden files
+  s / S                       Next sort field / reverse the order
+  o / Tab                     Full-screen preview (any key returns)
+  x                           Open in external viewer, then come back
+  y                           Copy current or selected paths to the clipboard (OSC 52)
+  1r ... 5r / r               Rate current or selected files 1 to 5 stars / clear the rating
+                              (1 ... 5 / 0 alone with -rate)
+  t                           Edit the current file's tags, or add tags to the selected files
+  d                           Move to trash, after y/n (needs -allow-delete)
+  /                           Fuzzy search filenames (Enter keeps, Esc clears)
+  n / N                       Next / previous match (with search = "highlight")
+  Space                       Select / unselect current item
+  a / A or * / u              Select all shown, invert, clear selection
+  V                           Visual range (V keeps it, Esc drops it)
+  z / Z                       Fold or unfold the current group / all groups (-group)
+  [ / ]                       Jump to the previous / next group (-group)
+  m{a-z} / '{a-z}             Mark the current item / jump back to it ('' returns)
+  Enter                       Accept selection(s), or open a folder with -browse
+  Backspace                   Go to the parent folder with -browse
   q / Esc                     Cancel
 
+Exit status:
+  0                           Accepted (or nothing found, with -exit-0)
+  64                          Bad flags or config
+  65                          Scan or terminal error
+  66                          Nothing found to pick from
+  74                          Output could not be written
+  130                         Canceled with q, Esc or Ctrl-C
+
 Environment:
   THUMBGRID_CACHE_DIR         Override cache directory
-  THUMBGRID_SELECTION_FILE    Write accepted paths to file`)
+  THUMBGRID_CACHE_MAX_MB      Default for -cache-max-mb
+  THUMBGRID_CACHE_MAX_AGE     Default for -cache-max-age
+  THUMBGRID_XDG_THUMBNAILS    Enable -xdg-thumbnails when set
+  THUMBGRID_VIDEO_TOOL        Video tools to try, e.g. magick or ffmpeg,magick
+  THUMBGRID_IMAGE_TOOL        Image tools to try, e.g. magick or vipsthumbnail,magick
+  THUMBGRID_SELECTION_FILE    Write accepted paths to file
+  THUMBGRID_BACKEND           Default for -backend
+  THUMBGRID_DAEMON_SOCKET     Socket of thumbgrid daemon (default $XDG_RUNTIME_DIR/thumbgrid.sock)
+  THUMBGRID_CONTROL_SOCKET    Default for -listen and ctl (default $XDG_RUNTIME_DIR/thumbgrid-ctl.sock)
+  THUMBGRID_KITTY_TRANSMIT    Kitty image transfer: file, shm or direct (default direct over SSH)
+  THUMBGRID_CONFIG            Config file (default ~/.config/thumbgrid/config.toml)`)
 		os.Exit(0)
 	}
 	if *showVersion {
@@ -156,8 +576,182 @@ Environment:
 	if err != nil {
 		return Config{}, err
 	}
+	normOutput, err := normalizeOutput(*output)
+	if err != nil {
+		return Config{}, err
+	}
+	format, err := parseFormat(*formatFlag)
+	if err == nil && format != "" && normOutput == outputJSON {
+		err = fmt.Errorf("-format and -output json can't be combined")
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	maxAge, err := thumb.ParseAge(*cacheMaxAge)
+	if err != nil {
+		return Config{}, fmt.Errorf("cache-max-age: %w", err)
+	}
+	cacheFmt, err := thumb.ParseFormat(*cacheFormat)
+	if err != nil {
+		return Config{}, err
+	}
+	stripCols, stripRows, err := parseFilmstrip(*filmstrip)
+	if err != nil {
+		return Config{}, err
+	}
+	if *seed == 0 {
+		*seed = rand.Uint64()
+	}
+	if *noRecurse {
+		*maxDepth = 1
+	}
+	if *maxDepth < 0 {
+		return Config{}, fmt.Errorf("invalid max-depth %d", *maxDepth)
+	}
+	switch *groupBy {
+	case "none", "":
+		*groupBy = ""
+	case groupDir, groupDate:
+	default:
+		return Config{}, fmt.Errorf("invalid group %q (expected none, dir or date)", *groupBy)
+	}
+	if *columns < 0 || *tileWidth < 0 || *tileHeight < 0 {
+		return Config{}, fmt.Errorf("-columns, -tile-width and -tile-height must not be negative")
+	}
+	if *takeFirst < 0 || *limit < 0 || *jobs < 0 {
+		return Config{}, fmt.Errorf("-take-first, -limit and -jobs must not be negative")
+	}
+	if *minRating < 0 || *minRating > label.MaxRating {
+		return Config{}, fmt.Errorf("invalid min-rating %d (expected 0 to %d)", *minRating, label.MaxRating)
+	}
+	listenPath := ""
+	if listen.set {
+		listenPath = orDefault(listen.path, controlSocket(defaultCacheDir(fc.CacheDir)))
+	}
+	var expectList []string
+	for _, k := range strings.Split(*expect, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			expectList = append(expectList, k)
+		}
+	}
+	fixedSize, sortGiven := false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "columns", "tile-width", "tile-height":
+			fixedSize = true
+		case "sort", "order", "seed":
+			sortGiven = true
+		}
+	})
+	if *layout != layoutGrid && *layout != layoutJustified {
+		return Config{}, fmt.Errorf("invalid layout %q (expected grid or justified)", *layout)
+	}
+	if fc.Wheel != "" && fc.Wheel != "scroll" && fc.Wheel != "cursor" {
+		return Config{}, fmt.Errorf("invalid wheel %q (expected scroll or cursor)", fc.Wheel)
+	}
+	if fc.Search != "" && fc.Search != "filter" && fc.Search != "highlight" {
+		return Config{}, fmt.Errorf("invalid search %q (expected filter or highlight)", fc.Search)
+	}
+	for _, g := range globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return Config{}, fmt.Errorf("glob %q: %w", g, err)
+		}
+	}
+	var re *regexp.Regexp
+	if *regex != "" {
+		if re, err = regexp.Compile(*regex); err != nil {
+			return Config{}, fmt.Errorf("regex: %w", err)
+		}
+	}
+	act, err := parseAction(*action)
+	if err != nil {
+		return Config{}, err
+	}
+	seek, err := thumb.ParseSeek(*videoSeek)
+	if err != nil {
+		return Config{}, fmt.Errorf("video-seek: %w", err)
+	}
+
+	return Config{
+		Path:            path,
+		CacheDir:        defaultCacheDir(fc.CacheDir),
+		CacheMaxBytes:   int64(max(0, *cacheMaxMB)) << 20,
+		CacheMaxAge:     maxAge,
+		CacheFormat:     cacheFmt,
+		XDGThumbnails:   *xdgThumbs,
+		Jobs:            *jobs,
+		Nice:            *nice,
+		StripCols:       stripCols,
+		StripRows:       stripRows,
+		VideoSeek:       seek,
+		Filter:          normFilter,
+		MaxDepth:        *maxDepth,
+		Hidden:          *hidden,
+		NoIgnore:        *noIgnore,
+		FollowSymlinks:  *followSymlinks,
+		Sniff:           *sniff,
+		Browse:          *browse,
+		GroupBy:         *groupBy,
+		Layout:          *layout,
+		Globs:           globs,
+		Regex:           re,
+		MinRating:       *minRating,
+		Tags:            tags,
+		SortBy:          *sortBy,
+		Order:           *order,
+		Seed:            *seed,
+		Print0:          *print0,
+		Output:          normOutput,
+		Format:          format,
+		Backend:         *backend,
+		OpenCmd:         *openCmd,
+		AllowDelete:     *allowDelete,
+		Rate:            *rate,
+		Action:          act,
+		TileWidth:       *tileWidth,
+		TileHeight:      *tileHeight,
+		Columns:         *columns,
+		Height:          *height,
+		Query:           *query,
+		Expect:          expectList,
+		Select1:         *select1,
+		Exit0:           *exit0,
+		Listen:          listenPath,
+		Watch:           *watch,
+		NoTUI:           *noTUI || *takeAll || *takeFirst > 0,
+		TakeFirst:       *takeFirst,
+		Limit:           *limit,
+		Select:          *selectPath,
+		RememberZoom:    !fixedSize,
+		Resume:          *resume,
+		SortGiven:       sortGiven,
+		History:         *history,
+		RecordHistory:   fc.History,
+		UseIndex:        *useIndex,
+		WheelCursor:     fc.Wheel == "cursor",
+		HighlightSearch: fc.Search == "highlight",
+		Keys:            fc.Keys,
+		Colors:          fc.Colors,
+	}, nil
+}
+
+// stringList collects a flag given more than once.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
 
-	return Config{Path: path, CacheDir: defaultCacheDir(), Filter: normFilter, SortBy: *sortBy, Order: *order}, nil
+func parseFilmstrip(s string) (int, int, error) {
+	if s == "" || s == "off" {
+		return 0, 0, nil
+	}
+	c, r, ok := strings.Cut(strings.ToLower(s), "x")
+	cols, err1 := strconv.Atoi(c)
+	rows, err2 := strconv.Atoi(r)
+	if !ok || err1 != nil || err2 != nil || cols < 1 || rows < 1 || cols*rows > 16 {
+		return 0, 0, fmt.Errorf("invalid filmstrip %q (expected CxR, e.g. 2x2 or 3x1)", s)
+	}
+	return cols, rows, nil
 }
 
 func normalizeFilter(filter string) (string, error) {
@@ -173,6 +767,27 @@ func normalizeFilter(filter string) (string, error) {
 	}
 }
 
+// trapSignals cancels the returned context on SIGTERM or SIGHUP, so the
+// picker clears its images and restores the terminal instead of dying mid
+// frame. caught reports the signal once the picker has returned.
+func trapSignals() (ctx context.Context, caught func() os.Signal) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGHUP)
+	var got atomic.Value
+	go func() {
+		if sig, ok := <-ch; ok {
+			got.Store(sig)
+			cancel()
+		}
+	}()
+	return ctx, func() os.Signal {
+		signal.Stop(ch)
+		sig, _ := got.Load().(os.Signal)
+		return sig
+	}
+}
+
 func fatalUsage(code int, format string, a ...any) {
 	fmt.Fprintf(os.Stderr, "thumbgrid: "+format+"\n", a...)
 	os.Exit(code)
@@ -213,10 +828,49 @@ func writeSelectionFile(dest string, sel []string) error {
 	return os.Rename(tmp, dest)
 }
 
-func defaultCacheDir() string {
+func defaultCacheMaxMB() int {
+	if v := os.Getenv("THUMBGRID_CACHE_MAX_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return 1024
+}
+
+func newGenerator(cfg Config) *thumb.Generator {
+	g := thumb.NewGenerator(cfg.CacheDir)
+	g.XDG = cfg.XDGThumbnails
+	g.StripCols, g.StripRows = cfg.StripCols, cfg.StripRows
+	g.Seek = cfg.VideoSeek
+	g.Nice = cfg.Nice
+	g.Format = cfg.CacheFormat
+	g.Durations = meta.Durations{}
+	if _, err := os.Stat(daemonSocket(cfg.CacheDir)); err == nil {
+		g.Daemon = daemonSocket(cfg.CacheDir)
+	}
+	if v := os.Getenv("THUMBGRID_VIDEO_TOOL"); v != "" {
+		g.VideoTools = strings.Split(strings.ToLower(v), ",")
+	}
+	if v := os.Getenv("THUMBGRID_IMAGE_TOOL"); v != "" {
+		g.ImageTools = strings.Split(strings.ToLower(v), ",")
+	}
+	return g
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func defaultCacheDir(configured string) string {
 	if v := os.Getenv("THUMBGRID_CACHE_DIR"); v != "" {
 		return v
 	}
+	if configured != "" {
+		return configured
+	}
 	if dir, err := os.UserCacheDir(); err == nil && dir != "" {
 		return filepath.Join(dir, "thumbgrid")
 	}
@@ -230,106 +884,6 @@ func defaultCacheDir() string {
 	return filepath.Join(home, ".cache", "thumbgrid")
 }
 
-func scanPath(root string, cfg Config) ([]Candidate, error) {
-	var cands []Candidate
-	cacheAbs := toAbs(cfg.CacheDir)
-	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-
-			if toAbs(path) == cacheAbs {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		kind := classify(path)
-		if !passes(kind, cfg.Filter) {
-			return nil
-		}
-		info, ierr := d.Info()
-		if ierr != nil {
-			return nil
-		}
-		cands = append(cands, Candidate{
-			Path:  path,
-			Name:  d.Name(),
-			Size:  info.Size(),
-			MTime: info.ModTime(),
-			Kind:  kind,
-		})
-		return nil
-	})
-	return cands, err
-}
-
-func classify(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".tif", ".tiff", ".avif", ".heic":
-		return "image"
-	case ".mp4", ".mov", ".mkv", ".webm", ".avi", ".m4v":
-		return "video"
-	default:
-		return "other"
-	}
-}
-
-func filterCandidates(in []Candidate, mode string) []Candidate {
-	out := in[:0]
-	for _, c := range in {
-		if passes(c.Kind, mode) {
-			out = append(out, c)
-		}
-	}
-	return out
-}
-
-func passes(kind, filter string) bool {
-	switch filter {
-	case filterImages:
-		return kind == "image"
-	case filterVideos:
-		return kind == "video"
-	case filterBoth, "":
-		return kind == "image" || kind == "video"
-	default:
-		return false
-	}
-}
-
-func sortCandidates(cands []Candidate, by, order string) error {
-	desc := strings.EqualFold(order, "desc")
-	switch by {
-	case "name":
-		sort.Slice(cands, func(i, j int) bool {
-			a, b := strings.ToLower(cands[i].Name), strings.ToLower(cands[j].Name)
-			if desc {
-				return a > b
-			}
-			return a < b
-		})
-	case "mtime":
-		sort.Slice(cands, func(i, j int) bool {
-			if desc {
-				return cands[i].MTime.After(cands[j].MTime)
-			}
-			return cands[i].MTime.Before(cands[j].MTime)
-		})
-	case "size":
-		sort.Slice(cands, func(i, j int) bool {
-			if desc {
-				return cands[i].Size > cands[j].Size
-			}
-			return cands[i].Size < cands[j].Size
-		})
-	default:
-		return fmt.Errorf("invalid sort: %s", by)
-	}
-	return nil
-}
-
 func toAbs(p string) string {
 	if p == "" {
 		return p
@@ -344,842 +898,4 @@ func toAbs(p string) string {
 	return ap
 }
 
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
-
 func isTerminal(fd uintptr) bool { return xt.IsTerminal(int(fd)) }
-
-func humanSize(n int64) string {
-	const (
-		KB = 1024
-		MB = 1024 * KB
-		GB = 1024 * MB
-	)
-	switch {
-	case n >= GB:
-		return fmt.Sprintf("%.1fG", float64(n)/float64(GB))
-	case n >= MB:
-		return fmt.Sprintf("%.1fM", float64(n)/float64(MB))
-	case n >= KB:
-		return fmt.Sprintf("%.1fK", float64(n)/float64(KB))
-	default:
-		return fmt.Sprintf("%dB", n)
-	}
-}
-
-func sanitizePrintable(s string) string {
-	rs := []rune(s)
-	b := make([]rune, 0, len(rs))
-	for _, r := range rs {
-		if r == '\x1b' || r == '\n' || r == '\r' || r == '\t' || (r < 0x20) || (r == 0x7f) {
-			b = append(b, ' ')
-		} else {
-			b = append(b, r)
-		}
-	}
-	return string(b)
-}
-
-func dispWidth(s string) int { return runewidth.StringWidth(s) }
-
-func truncateMiddleDisp(s string, width int) string {
-	s = sanitizePrintable(s)
-	if width <= 0 {
-		return ""
-	}
-	if dispWidth(s) <= width {
-		return s
-	}
-	if width <= 3 {
-		return runewidth.Truncate(s, width, "")
-	}
-	avail := width - 3
-	left := avail / 2
-	right := avail - left
-	rs := []rune(s)
-
-	lPart := make([]rune, 0, len(rs))
-	w := 0
-	for _, r := range rs {
-		rw := runewidth.RuneWidth(r)
-		if w+rw > left {
-			break
-		}
-		lPart = append(lPart, r)
-		w += rw
-	}
-
-	rPart := make([]rune, 0, len(rs))
-	w = 0
-	for i := len(rs) - 1; i >= 0; i-- {
-		r := rs[i]
-		rw := runewidth.RuneWidth(r)
-		if w+rw > right {
-			break
-		}
-		rPart = append(rPart, r)
-		w += rw
-	}
-
-	for i, j := 0, len(rPart)-1; i < j; i, j = i+1, j-1 {
-		rPart[i], rPart[j] = rPart[j], rPart[i]
-	}
-	out := string(lPart) + "..." + string(rPart)
-
-	if dispWidth(out) > width {
-		out = runewidth.Truncate(out, width, "")
-	}
-	return out
-}
-
-func padRightToWidth(s string, w int) string {
-	sw := dispWidth(s)
-	if sw >= w {
-		if sw == w {
-			return s
-		}
-		return runewidth.Truncate(s, w, "")
-	}
-	return s + strings.Repeat(" ", w-sw)
-}
-
-func ternary[T any](cond bool, a, b T) T {
-	if cond {
-		return a
-	}
-	return b
-}
-
-func runGridTUI(cands []Candidate, cfg Config) ([]string, int, error) {
-	fdIn := int(os.Stdin.Fd())
-	old, err := xt.MakeRaw(fdIn)
-	if err != nil {
-		return nil, 65, fmt.Errorf("raw mode: %w", err)
-	}
-	defer xt.Restore(fdIn, old)
-
-	fmt.Fprint(os.Stdout, "\x1b[?1000h\x1b[?1002h\x1b[?1006h")
-	defer fmt.Fprint(os.Stdout, "\x1b[?1006l\x1b[?1002l\x1b[?1000l")
-	bname, _ := term.Detect("auto")
-	renderer, _ := term.New(bname)
-	useGraphics := renderer != nil && renderer.Name() != "none"
-	var sched *term.Scheduler
-	if useGraphics {
-		sched = term.NewScheduler(renderer, 128)
-
-		defer func() { _ = renderer.ClearAll() }()
-		defer func() { sched.Close() }()
-	}
-
-	cur := 0
-	topRow := 0
-	awaitGG := false
-	showImages := useGraphics
-
-	winch := make(chan os.Signal, 1)
-	signal.Notify(winch, syscall.SIGWINCH)
-	defer signal.Stop(winch)
-
-	w, h, _ := xt.GetSize(int(os.Stdout.Fd()))
-	if h <= 0 {
-		h = 24
-	}
-	if w <= 0 {
-		w = 80
-	}
-
-	headerH := 1
-	footerH := 1
-	contentY := headerH + 1
-	contentH := h - headerH - footerH
-	if contentH < 0 {
-		contentH = 0
-	}
-
-	zoom := 0
-	baseTileW, baseTileH := 18, 6
-	gutter := 2
-	ppcX, ppcY := 10, 20
-	clampTile := func(wd, ht int) (int, int) {
-		if wd < 8 {
-			wd = 8
-		}
-		if ht < 3 {
-			ht = 3
-		}
-		return wd, ht
-	}
-
-	computeLayout := func() (gridX, gridY, gridW, gridH, tileW, tileH, cols, rows int) {
-		gridX, gridY = 1, contentY
-		gridW, gridH = w, contentH
-
-		tileW = baseTileW + zoom*4
-		tileH = baseTileH + zoom*2
-		tileW, tileH = clampTile(tileW, tileH)
-
-		stepW := tileW + gutter
-		if gridW < tileW {
-			cols = 1
-		} else {
-			cols = (gridW + gutter) / stepW
-		}
-		if cols < 1 {
-			cols = 1
-		}
-		stepH := tileH + gutter
-
-		if gridH < tileH {
-			rows = 0
-		} else {
-			rows = 1 + (gridH-tileH)/stepH
-		}
-		return
-	}
-
-	repaintCh := make(chan struct{}, 1)
-
-	type thumbKey struct {
-		path     string
-		wpx, hpx int
-	}
-	thumbReady := make(map[thumbKey]string)
-	thumbInflight := make(map[thumbKey]struct{})
-	var thumbMu sync.Mutex
-	thumbQ := make(chan thumbKey, 256)
-	quitThumb := make(chan struct{})
-	workers := 4
-	for i := 0; i < workers; i++ {
-		go func() {
-			for {
-				select {
-				case k := <-thumbQ:
-					tp, err := thumb.GenerateRect(k.path, k.wpx, k.hpx, cfg.CacheDir)
-					thumbMu.Lock()
-					if err == nil {
-						thumbReady[k] = tp
-					}
-					delete(thumbInflight, k)
-					thumbMu.Unlock()
-					select {
-					case repaintCh <- struct{}{}:
-					default:
-					}
-				case <-quitThumb:
-					return
-				}
-			}
-		}()
-	}
-	defer close(quitThumb)
-
-	ensureThumb := func(path string, wpx, hpx int) (string, bool) {
-		k := thumbKey{path: path, wpx: wpx, hpx: hpx}
-		thumbMu.Lock()
-		if tp, ok := thumbReady[k]; ok {
-			thumbMu.Unlock()
-			return tp, true
-		}
-		if _, inflight := thumbInflight[k]; !inflight {
-			thumbInflight[k] = struct{}{}
-			select {
-			case thumbQ <- k:
-			default:
-			}
-		}
-		thumbMu.Unlock()
-		return "", false
-	}
-
-	drawTile := func(buf *bytes.Buffer, idx, px, py, tileW, tileH int, renderImages bool) {
-		innerW := tileW - 2
-		if innerW < 2 {
-			innerW = 2
-		}
-		corner := "+"
-		hChar := "-"
-		if idx >= 0 && idx < len(cands) && idx == cur {
-			hChar = "="
-			corner = "*"
-		}
-		top := corner + strings.Repeat(hChar, max(0, tileW-2)) + corner
-		bot := top
-		fmt.Fprintf(buf, "\x1b[%d;%dH%s", py, px, top)
-		fmt.Fprintf(buf, "\x1b[%d;%dH%s", py+tileH-1, px, bot)
-
-		for rr := 1; rr < tileH-1; rr++ {
-			fmt.Fprintf(buf, "\x1b[%d;%dH|", py+rr, px)
-			fmt.Fprintf(buf, "\x1b[%d;%dH|", py+rr, px+tileW-1)
-		}
-
-		if idx < 0 || idx >= len(cands) {
-			for r := 1; r < tileH-1; r++ {
-				fmt.Fprintf(buf, "\x1b[%d;%dH|%s|", py+r, px, strings.Repeat(" ", innerW))
-			}
-			return
-		}
-
-		c := cands[idx]
-		imgH := max(1, tileH-3)
-		isImg := c.Kind == "image" || c.Kind == "video"
-		if renderImages || !useGraphics || !isImg {
-			for r := 1; r < tileH-1; r++ {
-				fmt.Fprintf(buf, "\x1b[%d;%dH|%s|", py+r, px, strings.Repeat(" ", innerW))
-			}
-		}
-		if renderImages && isImg {
-			wpx := max(8, innerW*ppcX)
-			hpx := max(8, imgH*ppcY)
-			if tp, ok := ensureThumb(c.Path, wpx, hpx); ok && sched != nil {
-				sched.Enqueue(tp, px+1, py+1, innerW, imgH)
-			}
-		}
-		if !(renderImages && isImg) {
-			icon := otherIcon(c.Path)
-			if dispWidth(icon) > innerW {
-				icon = runewidth.Truncate(icon, innerW, "")
-			}
-			ix := px + 1 + max(0, (innerW-dispWidth(icon))/2)
-			iy := py + 1 + max(0, (imgH-1)/2)
-			fmt.Fprintf(buf, "\x1b[%d;%dH%s", iy, ix, icon)
-		}
-		name := truncateMiddleDisp(c.Name, innerW-3)
-		line := fmt.Sprintf("%c %s", ternary(idx == cur, '>', ' '), name)
-		line = padRightToWidth(line, innerW)
-		if tileH >= 3 {
-			fmt.Fprintf(buf, "\x1b[%d;%dH|%s|", py+tileH-2, px, line)
-		}
-	}
-	firstDraw := true
-	var frameBuf bytes.Buffer
-	draw := func() {
-		term.Lock()
-		defer term.Unlock()
-		frameBuf.Reset()
-		if firstDraw {
-			fmt.Fprint(&frameBuf, "\x1b[2J")
-			firstDraw = false
-		}
-		fmt.Fprint(&frameBuf, "\x1b[H")
-		header := fmt.Sprintf("[%s] Arrows/hjkl move • Enter accept • q/Esc cancel", ternary(useGraphics, renderer.Name(), "none"))
-		if dispWidth(header) > w {
-			header = runewidth.Truncate(header, w, "")
-		}
-		fmt.Fprintf(&frameBuf, "\x1b[1;1H%s\x1b[K", header)
-		for row := 0; row < contentH; row++ {
-			fmt.Fprintf(&frameBuf, "\x1b[%d;1H\x1b[K", contentY+row)
-		}
-		gridX, gridY, _, _, tileW, tileH, cols, rows := computeLayout()
-
-		prefetchRows := 1
-		if showImages && rows > 0 && cols > 0 {
-			for r := -prefetchRows; r < rows+prefetchRows; r++ {
-				rr := topRow + r
-				if rr < 0 {
-					continue
-				}
-				for ccol := 0; ccol < cols; ccol++ {
-					idx := rr*cols + ccol
-					if idx < 0 || idx >= len(cands) {
-						continue
-					}
-					c := cands[idx]
-					if c.Kind != "image" && c.Kind != "video" {
-						continue
-					}
-					innerW := tileW - 2
-					if innerW < 2 {
-						innerW = 2
-					}
-					imgH := max(1, tileH-3)
-					wpx := max(8, innerW*ppcX)
-					hpx := max(8, imgH*ppcY)
-					_, _ = ensureThumb(c.Path, wpx, hpx)
-				}
-			}
-		}
-		renderImages := showImages
-		if rows > 0 && cols > 0 {
-			for r := 0; r < rows; r++ {
-				for ccol := 0; ccol < cols; ccol++ {
-					idx := (topRow+r)*cols + ccol
-					px := gridX + ccol*(tileW+gutter)
-					py := gridY + r*(tileH+gutter)
-					drawTile(&frameBuf, idx, px, py, tileW, tileH, renderImages)
-				}
-			}
-		}
-		var status string
-		if len(cands) > 0 {
-			c := cands[cur]
-			idx := cur + 1
-			_, _, _, _, tileW, tileH, cols, rows = computeLayout()
-			status = fmt.Sprintf("%d/%d • Name: %s • Type: %s • Size: %s • Grid: %dx%d • Tile: %dx%d",
-				idx, len(cands), truncateMiddleDisp(c.Name, max(10, w/3)), c.Kind, humanSize(c.Size), cols, rows, tileW, tileH)
-		} else {
-			status = "(no items)"
-		}
-		if h >= 2 {
-			s := sanitizePrintable(status)
-			if dispWidth(s) > w {
-				s = runewidth.Truncate(s, w, "")
-			}
-			fmt.Fprintf(&frameBuf, "\x1b[%d;1H%s\x1b[K", h, s)
-		}
-		_, _ = os.Stdout.Write(frameBuf.Bytes())
-	}
-	dataRows := func() int {
-		_, _, _, _, _, _, cols, _ := computeLayout()
-		return int((len(cands) + cols - 1) / cols)
-	}
-	curRow := func() int {
-		_, _, _, _, _, _, cols, _ := computeLayout()
-		return cur / cols
-	}
-	curCol := func() int {
-		_, _, _, _, _, _, cols, _ := computeLayout()
-		return cur % cols
-	}
-
-	moveTo := func(ncur int) {
-		if ncur < 0 {
-			ncur = 0
-		}
-		if ncur >= len(cands) {
-			ncur = len(cands) - 1
-		}
-		cur = ncur
-		r := curRow()
-		if r < topRow {
-			topRow = r
-		}
-		_, _, _, _, _, _, _, rows := computeLayout()
-		if r >= topRow+rows {
-			topRow = r - rows + 1
-		}
-		if topRow < 0 {
-			topRow = 0
-		}
-		maxTop := max(0, dataRows()-rows)
-		if topRow > maxTop {
-			topRow = maxTop
-		}
-	}
-
-	var stateMu sync.Mutex
-	quitRender := make(chan struct{})
-	var renderWG sync.WaitGroup
-	requestRepaint := func() {
-		select {
-		case repaintCh <- struct{}{}:
-		default:
-		}
-	}
-	renderWG.Add(1)
-	go func() {
-		defer renderWG.Done()
-		ticker := time.NewTicker(16 * time.Millisecond)
-		defer ticker.Stop()
-		dirty := true
-		for {
-			select {
-			case <-quitRender:
-				return
-			case <-repaintCh:
-				dirty = true
-			case <-ticker.C:
-				if !dirty {
-					continue
-				}
-				if sched != nil {
-					sched.NextFrame()
-				}
-				stateMu.Lock()
-				draw()
-				stateMu.Unlock()
-				dirty = false
-			}
-		}
-	}()
-	defer func() { close(quitRender); renderWG.Wait() }()
-
-	requestRepaint()
-	br := bufio.NewReader(os.Stdin)
-	for {
-		select {
-		case <-winch:
-			w2, h2, _ := xt.GetSize(int(os.Stdout.Fd()))
-			stateMu.Lock()
-			if h2 > 0 {
-				h = h2
-			} else {
-				h = 24
-			}
-			if w2 > 0 {
-				w = w2
-			} else {
-				w = 80
-			}
-			contentH = h - headerH - footerH
-			if contentH < 0 {
-				contentH = 0
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-			continue
-		default:
-		}
-		b, err := br.ReadByte()
-		if err != nil {
-			return nil, 65, fmt.Errorf("read: %w", err)
-		}
-		switch b {
-		case 'q':
-			if renderer != nil {
-				_ = renderer.ClearAll()
-			}
-			fmt.Fprint(os.Stdout, "\x1b[2J\x1b[H")
-			return nil, 130, fmt.Errorf("canceled")
-		case 0x03:
-			if renderer != nil {
-				_ = renderer.ClearAll()
-			}
-			fmt.Fprint(os.Stdout, "\x1b[2J\x1b[H")
-			return nil, 130, fmt.Errorf("canceled")
-		case 0x1b:
-			if br.Buffered() == 0 {
-				if renderer != nil {
-					_ = renderer.ClearAll()
-				}
-				fmt.Fprint(os.Stdout, "\x1b[2J\x1b[H")
-				return nil, 130, fmt.Errorf("canceled")
-			}
-			next, _ := br.ReadByte()
-			if next == '[' {
-				b3, _ := br.ReadByte()
-				if b3 == '<' {
-					buf := make([]byte, 0, 32)
-					for {
-						x, err := br.ReadByte()
-						if err != nil {
-							break
-						}
-						buf = append(buf, x)
-						if x == 'M' || x == 'm' {
-							break
-						}
-					}
-					s := string(buf)
-					parts := strings.Split(strings.TrimRight(s, "Mm"), ";")
-					if len(parts) == 3 && parts[0] != "" {
-						btn, _ := strconv.Atoi(parts[0])
-						cx, _ := strconv.Atoi(parts[1])
-						cy, _ := strconv.Atoi(parts[2])
-						stateMu.Lock()
-						gridX, gridY, _, _, tileW, tileH, cols, rows := computeLayout()
-						stateMu.Unlock()
-						_ = rows
-						if cx >= gridX && cy >= gridY {
-							offX := cx - gridX
-							offY := cy - gridY
-							stepW := tileW + gutter
-							stepH := tileH + gutter
-							ccol := offX / stepW
-							rrow := offY / stepH
-
-							if btn == 64 {
-								stateMu.Lock()
-								if topRow > 0 {
-									topRow--
-								}
-								stateMu.Unlock()
-								requestRepaint()
-								awaitGG = false
-								continue
-							}
-							if btn == 65 {
-								stateMu.Lock()
-								_, _, _, _, _, _, _, r := computeLayout()
-								maxTop := max(0, dataRows()-r)
-								if topRow < maxTop {
-									topRow++
-								}
-								stateMu.Unlock()
-								requestRepaint()
-								awaitGG = false
-								continue
-							}
-							if ccol >= 0 && ccol < cols && rrow >= 0 {
-								px := gridX + ccol*stepW
-								py := gridY + rrow*stepH
-								if cx <= px+tileW-1 && cy <= py+tileH-1 {
-									idx := (topRow+rrow)*cols + ccol
-									if idx >= 0 && idx < len(cands) {
-										if btn < 64 {
-											stateMu.Lock()
-											moveTo(idx)
-											stateMu.Unlock()
-											requestRepaint()
-										}
-									}
-								}
-							}
-						}
-					}
-					awaitGG = false
-					continue
-				}
-				switch b3 {
-				case 'A':
-					stateMu.Lock()
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					if cur-cols >= 0 {
-						moveTo(cur - cols)
-					}
-					stateMu.Unlock()
-				case 'B':
-					stateMu.Lock()
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					if cur+cols < len(cands) {
-						moveTo(cur + cols)
-					}
-					stateMu.Unlock()
-				case 'C':
-					stateMu.Lock()
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					if (cur%cols) < cols-1 && cur+1 < len(cands) {
-						moveTo(cur + 1)
-					}
-					stateMu.Unlock()
-				case 'D':
-					stateMu.Lock()
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					if (cur % cols) > 0 {
-						moveTo(cur - 1)
-					}
-					stateMu.Unlock()
-				case '5':
-					stateMu.Lock()
-					_, _, _, _, _, _, _, rows := computeLayout()
-					col := curCol()
-					newRow := curRow() - rows
-					if newRow < 0 {
-						newRow = 0
-					}
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					idx := newRow*cols + col
-					if idx >= len(cands) {
-						idx = len(cands) - 1
-					}
-					moveTo(idx)
-					stateMu.Unlock()
-					_, _ = br.ReadByte()
-				case '6':
-					stateMu.Lock()
-					_, _, _, _, _, _, _, rows := computeLayout()
-					col := curCol()
-					newRow := curRow() + rows
-					maxRow := dataRows() - 1
-					if newRow > maxRow {
-						newRow = maxRow
-					}
-					_, _, _, _, _, _, cols, _ := computeLayout()
-					idx := newRow*cols + col
-					if idx >= len(cands) {
-						idx = len(cands) - 1
-					}
-					moveTo(idx)
-					stateMu.Unlock()
-					_, _ = br.ReadByte()
-				}
-				requestRepaint()
-				awaitGG = false
-				continue
-			}
-			if renderer != nil {
-				_ = renderer.ClearAll()
-			}
-			fmt.Fprint(os.Stdout, "\x1b[2J\x1b[H")
-			return nil, 130, fmt.Errorf("canceled")
-		case 0x0c:
-			requestRepaint()
-			awaitGG = false
-		case 0x05:
-			stateMu.Lock()
-			_, _, _, _, _, _, _, rows := computeLayout()
-			_ = rows
-			maxTop := max(0, dataRows()-rows)
-			if topRow < maxTop {
-				topRow++
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 0x19:
-			stateMu.Lock()
-			if topRow > 0 {
-				topRow--
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 0x04:
-			stateMu.Lock()
-			_, _, _, _, _, _, _, rows := computeLayout()
-			delta := max(1, rows/2)
-			maxTop := max(0, dataRows()-rows)
-			topRow += delta
-			if topRow > maxTop {
-				topRow = maxTop
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 0x15:
-			stateMu.Lock()
-			_, _, _, _, _, _, _, rows := computeLayout()
-			delta := max(1, rows/2)
-			topRow -= delta
-			if topRow < 0 {
-				topRow = 0
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 0x06:
-			stateMu.Lock()
-			_, _, _, _, _, _, _, rows := computeLayout()
-			col := curCol()
-			newRow := curRow() + rows
-			maxRow := dataRows() - 1
-			if newRow > maxRow {
-				newRow = maxRow
-			}
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			idx := newRow*cols + col
-			if idx >= len(cands) {
-				idx = len(cands) - 1
-			}
-			moveTo(idx)
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 0x02:
-			stateMu.Lock()
-			_, _, _, _, _, _, _, rows := computeLayout()
-			col := curCol()
-			newRow := curRow() - rows
-			if newRow < 0 {
-				newRow = 0
-			}
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			idx := newRow*cols + col
-			if idx >= len(cands) {
-				idx = len(cands) - 1
-			}
-			moveTo(idx)
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'G':
-			stateMu.Lock()
-			moveTo(len(cands) - 1)
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'g':
-			if awaitGG {
-				stateMu.Lock()
-				moveTo(0)
-				topRow = 0
-				stateMu.Unlock()
-				requestRepaint()
-				awaitGG = false
-			} else {
-				awaitGG = true
-			}
-		case 'k':
-			stateMu.Lock()
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			if cur-cols >= 0 {
-				moveTo(cur - cols)
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'j':
-			stateMu.Lock()
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			if cur+cols < len(cands) {
-				moveTo(cur + cols)
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'h':
-			stateMu.Lock()
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			if (cur % cols) > 0 {
-				moveTo(cur - 1)
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'l':
-			stateMu.Lock()
-			_, _, _, _, _, _, cols, _ := computeLayout()
-			if (cur%cols) < cols-1 && cur+1 < len(cands) {
-				moveTo(cur + 1)
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case '+', '=':
-			stateMu.Lock()
-			zoom++
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case '-', '_':
-			stateMu.Lock()
-			zoom--
-			if zoom < 0 {
-				zoom = 0
-			}
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case 'p':
-			stateMu.Lock()
-			showImages = !showImages
-			stateMu.Unlock()
-			requestRepaint()
-			awaitGG = false
-		case '\r', '\n':
-			out := []string{toAbs(cands[cur].Path)}
-			if renderer != nil {
-				_ = renderer.ClearAll()
-			}
-			fmt.Fprint(os.Stdout, "\x1b[2J\x1b[H")
-			return out, 0, nil
-		default:
-			awaitGG = false
-		}
-	}
-}
-
-func otherIcon(path string) string {
-	ext := strings.ToUpper(strings.TrimPrefix(filepath.Ext(path), "."))
-	if ext == "" {
-		return "FILE"
-	}
-	if len(ext) > 4 {
-		ext = ext[:4]
-	}
-	return "[" + ext + "]"
-}
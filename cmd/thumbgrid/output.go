@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/label"
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+)
+
+type selectionJSON struct {
+	Path     string    `json:"path"`
+	Kind     string    `json:"kind"`
+	Size     int64     `json:"size"`
+	MTime    time.Time `json:"mtime"`
+	Width    int       `json:"width,omitempty"`
+	Height   int       `json:"height,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Rating   int       `json:"rating,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+}
+
+func normalizeOutput(output string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(output)) {
+	case "", outputLines, "text":
+		return outputLines, nil
+	case outputJSON:
+		return outputJSON, nil
+	default:
+		return "", fmt.Errorf("invalid output %q (expected lines or json)", output)
+	}
+}
+
+// formatField matches a -format placeholder such as {path}.
+var formatField = regexp.MustCompile(`\{(\w+)\}`)
+
+var formatEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\0`, "\x00", `\\`, `\`)
+
+// parseFormat checks a -format template and turns its \t, \n, \0 and \\
+// escapes into the characters.
+func parseFormat(s string) (string, error) {
+	for _, m := range formatField.FindAllStringSubmatch(s, -1) {
+		switch m[1] {
+		case "path", "relpath", "name", "kind", "size", "mtime", "index":
+		default:
+			return "", fmt.Errorf("format: unknown field {%s} (expected path, relpath, name, kind, size, mtime or index)", m[1])
+		}
+	}
+	return formatEscapes.Replace(s), nil
+}
+
+// formatSelection fills in the -format template for s; relpath is relative
+// to base.
+func formatSelection(format string, s picker.Selection, base string) string {
+	return formatField.ReplaceAllStringFunc(format, func(m string) string {
+		switch m[1 : len(m)-1] {
+		case "path":
+			return toAbs(s.Path)
+		case "relpath":
+			if rel, err := filepath.Rel(toAbs(base), toAbs(s.Path)); err == nil {
+				return rel
+			}
+			return toAbs(s.Path)
+		case "name":
+			return s.Name
+		case "kind":
+			return s.Kind
+		case "size":
+			return strconv.FormatInt(s.Size, 10)
+		case "mtime":
+			return s.MTime.Format(time.RFC3339)
+		case "index":
+			return strconv.Itoa(s.Index)
+		}
+		return m
+	})
+}
+
+// fillStat looks up the sizes and mtimes that a lazy walk left out.
+func fillStat(sel []picker.Selection) {
+	for i, s := range sel {
+		if s.MTime.IsZero() && s.Kind != "dir" {
+			if info, err := os.Stat(s.Path); err == nil {
+				sel[i].Size, sel[i].MTime = info.Size(), info.ModTime()
+			}
+		}
+	}
+}
+
+func selectionPaths(sel []picker.Selection) []string {
+	out := make([]string, 0, len(sel))
+	for _, c := range sel {
+		out = append(out, toAbs(c.Path))
+	}
+	return out
+}
+
+// writeOutput prints the selection. With -expect the key that accepted it
+// comes first on a line of its own, empty for Enter, as fzf does.
+func writeOutput(w io.Writer, key string, sel []picker.Selection, cfg Config) error {
+	sep := byte('\n')
+	if cfg.Print0 {
+		sep = 0
+	}
+	bw := bufio.NewWriter(w)
+	if len(cfg.Expect) > 0 {
+		bw.WriteString(key)
+		bw.WriteByte(sep)
+	}
+	if cfg.Output == outputJSON || cfg.Format != "" {
+		fillStat(sel)
+	}
+	if cfg.Output == outputJSON {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		return writeJSON(w, sel)
+	}
+	if cfg.Format != "" {
+		base := cfg.Path
+		if base == "" || base == "-" {
+			base = "."
+		}
+		for _, s := range sel {
+			bw.WriteString(formatSelection(cfg.Format, s, base))
+			bw.WriteByte(sep)
+		}
+		return bw.Flush()
+	}
+	for _, p := range selectionPaths(sel) {
+		bw.WriteString(p)
+		bw.WriteByte(sep)
+	}
+	return bw.Flush()
+}
+
+func writeJSON(w io.Writer, sel []picker.Selection) error {
+	out := make([]selectionJSON, 0, len(sel))
+	for _, c := range sel {
+		item := selectionJSON{
+			Path:   toAbs(c.Path),
+			Kind:   c.Kind,
+			Size:   c.Size,
+			MTime:  c.MTime,
+			Rating: label.Rating(c.Path),
+			Tags:   label.Tags(c.Path),
+		}
+		if info, err := meta.Probe(c.Path, c.Kind); err == nil {
+			item.Width, item.Height, item.Duration = info.Width, info.Height, info.Duration
+		}
+		out = append(out, item)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/exif"
+	"github.com/ck-zhang/thumbgrid/internal/ignore"
+	"github.com/ck-zhang/thumbgrid/internal/index"
+	"github.com/ck-zhang/thumbgrid/internal/label"
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+const (
+	scanBatchSize     = 256
+	scanFlushInterval = 50 * time.Millisecond
+)
+
+func startScan(cfg Config, fromStdin bool) <-chan picker.Batch {
+	return cfg.probe(scanFeed(context.Background(), cfg, fromStdin))
+}
+
+// rescanner walks cfg.Path again when . shows or hides hidden files in the
+// grid, since the walk leaves hidden directories out unless asked.
+func rescanner(cfg Config) func(ctx context.Context, showHidden bool) <-chan picker.Batch {
+	return func(ctx context.Context, showHidden bool) <-chan picker.Batch {
+		c := cfg
+		c.Hidden = showHidden
+		return c.probe(scanFeed(ctx, c, false))
+	}
+}
+
+// probe adds the dimensions and durations that the sort or a justified
+// layout needs. Justified rows only need images; ffprobe is too slow to run
+// on every video for that.
+func (cfg Config) probe(feed <-chan picker.Batch) <-chan picker.Batch {
+	videos := needsProbe(cfg.SortBy)
+	images := videos && cfg.SortBy != "duration" || cfg.Layout == layoutJustified
+	if !images && !videos {
+		return feed
+	}
+	return probeBatches(feed, images, videos, cfg.Index)
+}
+
+// scanFeed stops walking, and sending, once ctx is done.
+func scanFeed(ctx context.Context, cfg Config, fromStdin bool) <-chan picker.Batch {
+	ch := make(chan picker.Batch, 16)
+	send := func(b picker.Batch) {
+		select {
+		case ch <- b:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(ch)
+		if fromStdin || cfg.History {
+			var r io.Reader = os.Stdin
+			if cfg.History {
+				paths, _ := historyPaths()
+				r = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+			}
+			cands, err := readCandidates(r, cfg)
+			if len(cands) > 0 {
+				send(picker.Batch{Candidates: cands})
+			}
+			if err != nil {
+				send(picker.Batch{Err: fmt.Errorf("read stdin: %w", err)})
+			}
+			return
+		}
+		var batch []Candidate
+		last := time.Now()
+		err := scanPath(ctx, cfg.Path, cfg, func(c Candidate) {
+			batch = append(batch, c)
+			if len(batch) >= scanBatchSize || time.Since(last) >= scanFlushInterval {
+				send(picker.Batch{Candidates: batch})
+				batch = nil
+				last = time.Now()
+			}
+		})
+		if len(batch) > 0 {
+			send(picker.Batch{Candidates: batch})
+		}
+		if err != nil && ctx.Err() == nil {
+			send(picker.Batch{Err: err})
+		}
+	}()
+	return ch
+}
+
+// browser lists one directory at a time for -browse: subdirectories become
+// folder tiles, files go through the usual filters.
+func browser(cfg Config) func(ctx context.Context, dir string) <-chan picker.Batch {
+	cacheAbs := toAbs(cfg.CacheDir)
+	return func(ctx context.Context, dir string) <-chan picker.Batch {
+		ch := make(chan picker.Batch, 2)
+		go func() {
+			defer close(ch)
+			entries, err := os.ReadDir(dir)
+			var ign *ignore.Tree
+			if !cfg.NoIgnore {
+				ign = ignore.New(dir, ".gitignore", ".thumbgridignore")
+			}
+			var cands []Candidate
+			for _, e := range entries {
+				if ctx.Err() != nil {
+					return
+				}
+				path := filepath.Join(dir, e.Name())
+				info, serr := os.Stat(path)
+				if serr != nil || ign != nil && ign.Ignored(path, info.IsDir()) {
+					continue
+				}
+				kind, rating := "dir", 0
+				var tags []string
+				if !info.IsDir() {
+					if !cfg.matches(path) {
+						continue
+					}
+					if kind = cfg.classify(path); !passes(kind, cfg.Filter) {
+						continue
+					}
+					var ok bool
+					if rating, tags, ok = cfg.labels(path); !ok {
+						continue
+					}
+				} else if path == cacheAbs {
+					continue
+				}
+				cands = append(cands, Candidate{
+					Path:   path,
+					Name:   e.Name(),
+					Size:   info.Size(),
+					MTime:  info.ModTime(),
+					Kind:   kind,
+					Rating: rating,
+					Tags:   tags,
+					Hidden: hidden(dir, path),
+				})
+			}
+			if len(cands) > 0 {
+				ch <- picker.Batch{Candidates: cands}
+			}
+			if err != nil {
+				ch <- picker.Batch{Err: err}
+			}
+		}()
+		return cfg.probe(ch)
+	}
+}
+
+// probeBatches fills in dimensions and duration before candidates reach
+// the grid. Probes run in parallel since each video costs an ffprobe run;
+// images and videos say which kinds to probe. Files x has up to date
+// records for aren't probed again.
+func probeBatches(in <-chan picker.Batch, images, videos bool, x *index.Index) <-chan picker.Batch {
+	out := make(chan picker.Batch, 16)
+	go func() {
+		defer close(out)
+		sem := make(chan struct{}, runtime.NumCPU())
+		for b := range in {
+			var wg sync.WaitGroup
+			for i := range b.Candidates {
+				c := &b.Candidates[i]
+				if !(c.Kind == "image" && images || c.Kind == "video" && videos) {
+					continue
+				}
+				if r, ok := x.Lookup(c.Path, c.Size, c.MTime); ok && r.Probed {
+					c.Width, c.Height, c.Duration = r.Width, r.Height, r.Duration
+					continue
+				}
+				wg.Add(1)
+				sem <- struct{}{}
+				go func() {
+					defer func() { <-sem; wg.Done() }()
+					// meta.Probe records what it finds in the index.
+					if info, err := meta.Probe(c.Path, c.Kind); err == nil {
+						c.Width, c.Height, c.Duration = info.Width, info.Height, info.Duration
+					}
+				}()
+			}
+			wg.Wait()
+			out <- b
+		}
+	}()
+	return out
+}
+
+// collectScan drains feed for the non-interactive path, dropping hidden
+// candidates unless showHidden. With a limit above zero it stops reading
+// after that many, leaving the rest of the scan blocked.
+func collectScan(feed <-chan picker.Batch, showHidden bool, limit int) ([]Candidate, error) {
+	var cands []Candidate
+	var err error
+	for res := range feed {
+		if res.Err != nil {
+			err = res.Err
+			continue
+		}
+		for _, c := range res.Candidates {
+			if showHidden || !c.Hidden {
+				cands = append(cands, c)
+			}
+		}
+		if limit > 0 && len(cands) >= limit {
+			return cands[:limit], err
+		}
+	}
+	return cands, err
+}
+
+// scanWorkers bounds how many directories are read, and batches of files
+// statted, at once. It is well above the CPU count because the walk spends
+// its time waiting on the disk, or on the server for NFS.
+const (
+	scanWorkers = 32
+	scanChunk   = 64
+)
+
+// scanPath walks root in parallel: each directory, and each run of
+// scanChunk files in it, goes to a free worker, or is done by the worker
+// that found it when none is free. emit is called from one goroutine at a
+// time, in no particular order. The first error, or ctx ending, ends the
+// walk. Hidden files and directories are passed over unless cfg.Hidden.
+func scanPath(ctx context.Context, root string, cfg Config, emit func(Candidate)) error {
+	return scanUnder(ctx, root, root, cfg, emit)
+}
+
+// scanUnder walks start, a file or directory under root, as scanPath walks
+// root: depth, hidden names and ignore files count from root.
+func scanUnder(ctx context.Context, root, start string, cfg Config, emit func(Candidate)) error {
+	cacheAbs := toAbs(cfg.CacheDir)
+	ign := cfg.ignorer(root)
+	// A symlinked root is walked through, as find -H does.
+	info, err := os.Stat(start)
+	if err != nil {
+		return err
+	}
+	if cfg.excluded(root, start, info.IsDir(), ign) {
+		return nil
+	}
+	if !info.IsDir() {
+		if c, ok := cfg.candidate(root, start, func() (os.FileInfo, error) { return info, nil }); ok {
+			emit(c)
+		}
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, scanWorkers)
+		mu       sync.Mutex // guards visited and firstErr
+		emitMu   sync.Mutex
+		firstErr error
+		// With -follow-symlinks every directory is remembered by inode, so a
+		// link back up the tree, or into a tree already walked, is not
+		// entered.
+		visited = map[any]bool{fileID(start, info): true}
+	)
+	spawn := func(fn func()) {
+		select {
+		case sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				fn()
+			}()
+		default:
+			fn()
+		}
+	}
+	// failed keeps err if it is the first, and reports whether the walk
+	// has failed; failed(nil) only asks.
+	failed := func(err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		return firstErr != nil
+	}
+	visit := func(path string, info os.FileInfo) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		id := fileID(path, info)
+		if visited[id] {
+			return false
+		}
+		visited[id] = true
+		return true
+	}
+	type file struct {
+		path string
+		d    os.DirEntry
+		info os.FileInfo
+	}
+	// A directory is read scanChunk entries at a time, each run handed on
+	// as it is read, so a huge one neither sits in memory whole nor holds
+	// back the first files.
+	var walk func(dir string)
+	walk = func(dir string) {
+		if failed(ctx.Err()) {
+			return
+		}
+		if cfg.Entered != nil {
+			cfg.Entered(dir)
+		}
+		fd, err := os.Open(dir)
+		if err != nil {
+			failed(err)
+			return
+		}
+		defer fd.Close()
+		for {
+			entries, err := fd.ReadDir(scanChunk)
+			var files []file
+			for _, d := range entries {
+				if !cfg.Hidden && hiddenName(d.Name()) {
+					continue
+				}
+				path := filepath.Join(dir, d.Name())
+				isDir, info := d.IsDir(), os.FileInfo(nil)
+				if cfg.FollowSymlinks && d.Type()&os.ModeSymlink != 0 {
+					var serr error
+					if info, serr = os.Stat(path); serr != nil {
+						continue
+					}
+					isDir = info.IsDir()
+				}
+				if ign != nil && ign.Ignored(path, isDir) {
+					continue
+				}
+				if !isDir {
+					files = append(files, file{path, d, info})
+					continue
+				}
+				if toAbs(path) == cacheAbs || cfg.MaxDepth > 0 && depth(root, path) >= cfg.MaxDepth {
+					continue
+				}
+				if cfg.FollowSymlinks {
+					if info == nil {
+						var ierr error
+						if info, ierr = d.Info(); ierr != nil {
+							continue
+						}
+					}
+					if !visit(path, info) {
+						continue
+					}
+				}
+				spawn(func() { walk(path) })
+			}
+			if len(files) > 0 {
+				spawn(func() {
+					for _, f := range files {
+						c, ok := cfg.candidate(root, f.path, func() (os.FileInfo, error) {
+							if f.info != nil {
+								return f.info, nil
+							}
+							return f.d.Info()
+						})
+						if ok {
+							emitMu.Lock()
+							emit(c)
+							emitMu.Unlock()
+						}
+					}
+				})
+			}
+			if err == io.EOF {
+				return
+			}
+			if failed(err) || failed(ctx.Err()) {
+				return
+			}
+		}
+	}
+	walk(start)
+	wg.Wait()
+	return firstErr
+}
+
+// ignorer reads the ignore files under root, unless -no-ignore.
+func (cfg Config) ignorer(root string) *ignore.Tree {
+	if cfg.NoIgnore {
+		return nil
+	}
+	return ignore.New(root, ".gitignore", ".thumbgridignore")
+}
+
+// excluded reports whether path, under root, is left out of a walk of
+// root: it is hidden, ignored, the cache, or a directory at -max-depth.
+func (cfg Config) excluded(root, path string, isDir bool, ign *ignore.Tree) bool {
+	if path == root {
+		return false
+	}
+	if !cfg.Hidden && hidden(root, path) || ign != nil && ign.Ignored(path, isDir) {
+		return true
+	}
+	return isDir && (toAbs(path) == toAbs(cfg.CacheDir) || cfg.MaxDepth > 0 && depth(root, path) >= cfg.MaxDepth)
+}
+
+// candidate describes the file at path under root, or reports false when
+// the filters leave it out. stat is only called for files that pass, and
+// not at all when lazyStat.
+func (cfg Config) candidate(root, path string, stat func() (os.FileInfo, error)) (Candidate, bool) {
+	if !cfg.matches(path) {
+		return Candidate{}, false
+	}
+	kind := cfg.classify(path)
+	if !passes(kind, cfg.Filter) {
+		return Candidate{}, false
+	}
+	var info os.FileInfo
+	if !cfg.lazyStat() {
+		var err error
+		if info, err = stat(); err != nil {
+			return Candidate{}, false
+		}
+	}
+	rating, tags, ok := cfg.labels(path)
+	if !ok {
+		return Candidate{}, false
+	}
+	c := Candidate{
+		Path:   path,
+		Name:   filepath.Base(path),
+		Kind:   kind,
+		Rating: rating,
+		Tags:   tags,
+		Hidden: hidden(root, path),
+		Group:  cfg.group(path, kind, info),
+	}
+	if info != nil {
+		c.Size, c.MTime = info.Size(), info.ModTime()
+	}
+	return c, true
+}
+
+// lazyStat reports whether the walk can leave out each file's size and
+// mtime, because nothing sorts, groups or indexes by them. That saves a
+// system call per file on a large tree; the picker and the output look
+// them up for the files they show.
+func (cfg Config) lazyStat() bool {
+	return !statSort(cfg.SortBy) && cfg.GroupBy != groupDate && cfg.Index == nil
+}
+
+// depth counts the directories between root and path: 1 for a direct child.
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// junkDirs hold generated or vendored files nobody browses on purpose.
+var junkDirs = map[string]bool{
+	"node_modules": true,
+	"__pycache__":  true,
+	"__MACOSX":     true,
+}
+
+// hidden reports whether path, below root, is a dotfile or sits under a dot
+// or junk directory. Root itself never counts, so thumbgrid ~/.cache works.
+func hidden(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(strings.Split(rel, string(filepath.Separator)), hiddenName)
+}
+
+// hiddenName reports whether a file or directory called name is hidden.
+func hiddenName(name string) bool {
+	return name != "." && name != ".." && strings.HasPrefix(name, ".") || junkDirs[name]
+}
+
+func readCandidates(r io.Reader, cfg Config) ([]Candidate, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var cands []Candidate
+	seen := make(map[string]struct{})
+	for _, line := range strings.Split(string(data), sep) {
+		path := strings.TrimSuffix(line, "\r")
+		if sep == "\n" {
+			path = strings.TrimSpace(path)
+		}
+		if path == "" {
+			continue
+		}
+		if _, dup := seen[path]; dup {
+			continue
+		}
+		seen[path] = struct{}{}
+		if !cfg.matches(path) {
+			continue
+		}
+		kind := cfg.classify(path)
+		if !passes(kind, cfg.Filter) {
+			continue
+		}
+		info, serr := os.Stat(path)
+		if serr != nil || info.IsDir() {
+			continue
+		}
+		rating, tags, ok := cfg.labels(path)
+		if !ok {
+			continue
+		}
+		cands = append(cands, Candidate{
+			Path:   path,
+			Name:   filepath.Base(path),
+			Size:   info.Size(),
+			MTime:  info.ModTime(),
+			Kind:   kind,
+			Rating: rating,
+			Tags:   tags,
+			Group:  cfg.group(path, kind, info),
+		})
+	}
+	return cands, nil
+}
+
+func classify(path string) string {
+	if thumb.IsRaw(path) {
+		return "image"
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp", ".tif", ".tiff", ".avif", ".heic":
+		return "image"
+	case ".mp4", ".mov", ".mkv", ".webm", ".avi", ".m4v":
+		return "video"
+	default:
+		return "other"
+	}
+}
+
+// group names the grid section a file goes in with -group. Date groups are
+// a day for today and yesterday and a month before that, written so that
+// they sort by time; dateTitle names them.
+func (cfg Config) group(path, kind string, info os.FileInfo) string {
+	switch cfg.GroupBy {
+	case groupDir:
+		return filepath.Dir(path)
+	case groupDate:
+		t := info.ModTime()
+		if kind == "image" {
+			if d, ok := cfg.taken(path, info); ok {
+				t = d
+			}
+		}
+		now := time.Now()
+		day := t.Format(time.DateOnly)
+		if day == now.Format(time.DateOnly) || day == now.AddDate(0, 0, -1).Format(time.DateOnly) {
+			return day
+		}
+		return t.Format("2006-01")
+	}
+	return ""
+}
+
+// taken returns when the photo at path was taken, from -index when it
+// knows.
+func (cfg Config) taken(path string, info os.FileInfo) (time.Time, bool) {
+	if r, ok := cfg.Index.Lookup(path, info.Size(), info.ModTime()); ok && r.Dated {
+		return time.Unix(r.Taken, 0), r.Taken != 0
+	}
+	d, ok := exif.DateTime(path)
+	cfg.Index.Update(path, info.Size(), info.ModTime(), func(r *index.Record) {
+		r.Dated, r.Taken = true, 0
+		if ok {
+			r.Taken = d.Unix()
+		}
+	})
+	return d, ok
+}
+
+func dateTitle(group string) string {
+	now := time.Now()
+	switch group {
+	case now.Format(time.DateOnly):
+		return "Today"
+	case now.AddDate(0, 0, -1).Format(time.DateOnly):
+		return "Yesterday"
+	}
+	return group
+}
+
+// classify falls back to the file's magic bytes with -sniff, for names
+// without a known extension.
+func (cfg Config) classify(path string) string {
+	kind := classify(path)
+	if kind == "other" && cfg.Sniff {
+		kind = orDefault(thumb.Sniff(path), "other")
+	}
+	return kind
+}
+
+func passes(kind, filter string) bool {
+	switch filter {
+	case filterImages:
+		return kind == "image"
+	case filterVideos:
+		return kind == "video"
+	case filterBoth, "":
+		return kind == "image" || kind == "video"
+	default:
+		return false
+	}
+}
+
+// matches applies -glob, to the file name, and -regex, to the whole path.
+// Any one glob has to match.
+func (cfg Config) matches(path string) bool {
+	if len(cfg.Globs) > 0 {
+		name := filepath.Base(path)
+		ok := false
+		for _, g := range cfg.Globs {
+			if m, _ := filepath.Match(g, name); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return cfg.Regex == nil || cfg.Regex.MatchString(path)
+}
+
+// labels returns the stars and tags given to path, and whether they are
+// enough for -min-rating and every -tag. They are always read from the
+// file, which can change them without a new mtime, but only when filtering
+// by them: that takes a few system calls per file, and otherwise the
+// picker reads them for the files it shows.
+func (cfg Config) labels(path string) (int, []string, bool) {
+	if !cfg.filterLabels() {
+		return 0, nil, true
+	}
+	n, tags := label.Rating(path), label.Tags(path)
+	if n < cfg.MinRating {
+		return 0, nil, false
+	}
+	for _, t := range cfg.Tags {
+		if !label.HasTag(tags, t) {
+			return 0, nil, false
+		}
+	}
+	return n, tags, true
+}
+
+// filterLabels reports whether -min-rating or -tag is set.
+func (cfg Config) filterLabels() bool { return cfg.MinRating > 0 || len(cfg.Tags) > 0 }
+
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		kind, filter string
+		want         bool
+	}{
+		{"image", "", true},
+		{"video", "", true},
+		{"other", "", false},
+		{"image", filterBoth, true},
+		{"image", filterImages, true},
+		{"video", filterImages, false},
+		{"video", filterVideos, true},
+		{"image", filterVideos, false},
+		{"image", "audio", false},
+	}
+	for _, tt := range tests {
+		if got := passes(tt.kind, tt.filter); got != tt.want {
+			t.Errorf("passes(%q, %q) = %v, want %v", tt.kind, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := map[string]string{
+		"a.jpg":      "image",
+		"a.JPEG":     "image",
+		"a.heic":     "image",
+		"a.mkv":      "video",
+		"a.MOV":      "video",
+		"a.txt":      "other",
+		"noext":      "other",
+		"dir.png/a":  "other",
+		"/x/y/z.gif": "image",
+	}
+	for path, want := range tests {
+		if got := classify(path); got != want {
+			t.Errorf("classify(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		globs []string
+		regex string
+		path  string
+		want  bool
+	}{
+		{"no filter", nil, "", "/p/a.png", true},
+		{"glob", []string{"*.png"}, "", "/p/a.png", true},
+		{"glob on the name only", []string{"p/*"}, "", "/p/a.png", false},
+		{"any glob", []string{"*.jpg", "a.*"}, "", "/p/a.png", true},
+		{"no glob", []string{"*.jpg", "b.*"}, "", "/p/a.png", false},
+		{"regex on the path", nil, "^/p/", "/p/a.png", true},
+		{"regex misses", nil, "^/q/", "/p/a.png", false},
+		{"both", []string{"*.png"}, "/q/", "/p/a.png", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Globs: tt.globs}
+			if tt.regex != "" {
+				cfg.Regex = regexp.MustCompile(tt.regex)
+			}
+			if got := cfg.matches(tt.path); got != tt.want {
+				t.Errorf("matches(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/config"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+const sheetUsage = `thumbgrid sheet -o FILE [-cols N] [-size WxH] [-title TEXT] [-no-labels] [-sort FIELD]
+                [-order asc|desc] [-filter image|video|both] [-hidden] [PATH]
+
+Put the thumbnails of the images and videos under PATH (default .) into one
+image, N to a row with their names underneath, for a quick overview of a
+shoot or dataset. FILE ends in .png or .jpg; - writes a PNG to stdout.`
+
+func runSheetCommand(args []string, fc config.File) int {
+	cfg, err := thumbConfig(fc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: sheet: config: %v\n", err)
+		return 64
+	}
+	fs := flag.NewFlagSet("sheet", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, sheetUsage) }
+	sf := addScanFlags(fs, fc, "240x180")
+	out := fs.String("o", "", "Image to write: .png, .jpg, or - for a PNG on stdout")
+	cols := fs.Int("cols", 6, "Thumbnails per row")
+	title := fs.String("title", "", "Heading (default: the directory name)")
+	noLabels := fs.Bool("no-labels", false, "Leave out the file names")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	if *out == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return 64
+	}
+	var encode func(io.Writer, image.Image) error
+	switch strings.ToLower(filepath.Ext(*out)) {
+	case ".png":
+		encode = png.Encode
+	case ".jpg", ".jpeg":
+		encode = func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, &jpeg.Options{Quality: 90}) }
+	default:
+		if *out != "-" {
+			fmt.Fprintf(os.Stderr, "thumbgrid: sheet: can't tell the format of %s (expected .png or .jpg)\n", *out)
+			return 64
+		}
+		encode = png.Encode
+	}
+	if *cols < 1 {
+		fmt.Fprintln(os.Stderr, "thumbgrid: sheet: -cols must be at least 1")
+		return 64
+	}
+	cfg.Path = orDefault(fs.Arg(0), ".")
+	// A sheet from an earlier run is not part of the next one.
+	skip := ""
+	if *out != "-" {
+		skip = toAbs(*out)
+	}
+	gal, status := sf.thumbnailed("sheet", cfg, skip)
+	if status != 0 {
+		return status
+	}
+
+	tiles := make([]thumb.SheetTile, len(gal.cands))
+	for i, c := range gal.cands {
+		tiles[i] = thumb.SheetTile{Thumb: gal.thumbs[i], Label: c.Name}
+	}
+	img := thumb.ContactSheet(tiles, *cols, gal.w, gal.h, !*noLabels, orDefault(*title, filepath.Base(toAbs(cfg.Path))))
+	if *out == "-" {
+		err = encode(os.Stdout, img)
+	} else if f, cerr := os.Create(*out); cerr != nil {
+		err = cerr
+	} else {
+		err = encode(f, img)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: sheet: %v\n", err)
+		return 74
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+)
+
+// candidateLess returns the ordering for a sort field. Candidates in
+// different groups order by group first, so that each stays together;
+// date groups follow order, newest first by default.
+func candidateLess(by, order string, seed uint64, groupBy string) (func(a, b Candidate) bool, error) {
+	less, err := fieldLess(by, order, seed)
+	if err != nil {
+		return nil, err
+	}
+	groupDesc := groupBy == groupDate && strings.EqualFold(order, "desc")
+	return func(a, b Candidate) bool {
+		if a.Group != b.Group {
+			return (naturalCompare(a.Group, b.Group) < 0) != groupDesc
+		}
+		// Ties go by path, so the order doesn't depend on which of the
+		// walk's workers found a file first.
+		return less(a, b) || !less(b, a) && a.Path < b.Path
+	}, nil
+}
+
+// fieldLess orders by one field. seed only matters for random, which
+// orders by a seeded hash of each path so that batches streaming in can
+// still be merged.
+func fieldLess(by, order string, seed uint64) (func(a, b Candidate) bool, error) {
+	desc := strings.EqualFold(order, "desc")
+	switch by {
+	case "name":
+		return func(a, b Candidate) bool {
+			x, y := strings.ToLower(a.Name), strings.ToLower(b.Name)
+			if desc {
+				return x > y
+			}
+			return x < y
+		}, nil
+	case "natural":
+		return func(a, b Candidate) bool {
+			x, y := strings.ToLower(a.Name), strings.ToLower(b.Name)
+			if desc {
+				return naturalCompare(x, y) > 0
+			}
+			return naturalCompare(x, y) < 0
+		}, nil
+	case "mtime":
+		return func(a, b Candidate) bool {
+			if desc {
+				return a.MTime.After(b.MTime)
+			}
+			return a.MTime.Before(b.MTime)
+		}, nil
+	case "size":
+		return func(a, b Candidate) bool {
+			if desc {
+				return a.Size > b.Size
+			}
+			return a.Size < b.Size
+		}, nil
+	case "resolution":
+		return func(a, b Candidate) bool {
+			x, y := a.Width*a.Height, b.Width*b.Height
+			if desc {
+				return x > y
+			}
+			return x < y
+		}, nil
+	case "duration":
+		return func(a, b Candidate) bool {
+			if desc {
+				return a.Duration > b.Duration
+			}
+			return a.Duration < b.Duration
+		}, nil
+	case "random":
+		return func(a, b Candidate) bool {
+			x, y := shuffleKey(seed, a.Path), shuffleKey(seed, b.Path)
+			if desc {
+				return x > y
+			}
+			return x < y
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid sort: %s", by)
+	}
+}
+
+// sortCycle lists the orderings s steps through, starting with by. Fields
+// that need probing are only offered when the scan probed for them, and
+// mtime and size when it didn't skip the stat.
+func sortCycle(by, order string, seed uint64, groupBy string, lazyStat bool) []picker.Sort {
+	fields := []string{by}
+	for _, f := range []string{"name", "natural", "mtime", "size", "resolution", "duration"} {
+		if f != by && (!needsProbe(f) || needsProbe(by)) && (!statSort(f) || !lazyStat) {
+			fields = append(fields, f)
+		}
+	}
+	var out []picker.Sort
+	for _, f := range fields {
+		less, err := candidateLess(f, order, seed, groupBy)
+		if err != nil {
+			continue
+		}
+		out = append(out, picker.Sort{Name: f + " " + strings.ToLower(order), Less: less})
+	}
+	return out
+}
+
+// needsProbe reports whether sorting by field needs each file's dimensions
+// or duration, which the directory walk doesn't provide.
+func needsProbe(by string) bool { return by == "resolution" || by == "duration" }
+
+// statSort reports whether sorting by field needs each file's size or mtime.
+func statSort(by string) bool { return by == "mtime" || by == "size" }
+
+func sortCandidates(cands []Candidate, by, order string, seed uint64, groupBy string) error {
+	less, err := candidateLess(by, order, seed, groupBy)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return less(cands[i], cands[j]) })
+	return nil
+}
+
+// naturalCompare orders strings with runs of digits compared as numbers, so
+// IMG_2 comes before IMG_10. Equal numbers with fewer leading zeros go first.
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			da, db := digitRun(a), digitRun(b)
+			na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			if c := cmp.Compare(da, db); c != 0 {
+				return c
+			}
+			a, b = a[da:], b[db:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func shuffleKey(seed uint64, path string) uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], seed)
+	h.Write(b[:])
+	h.Write([]byte(path))
+	return h.Sum64()
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func digitRun(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
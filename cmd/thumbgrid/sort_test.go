@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"img2", "img10", -1},
+		{"img10", "img2", 1},
+		{"img2", "img2", 0},
+		{"img02", "img2", 1},
+		{"img002", "img02", 1},
+		{"img2a", "img2b", -1},
+		{"img", "img1", -1},
+		{"a10b2", "a10b10", -1},
+		{"10", "9", 1},
+		{"", "a", -1},
+	}
+	for _, tt := range tests {
+		if got := naturalCompare(tt.a, tt.b); got != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSortCandidates(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC) }
+	cands := []Candidate{
+		{Path: "/p/b10.png", Name: "b10.png", MTime: at(1), Size: 30, Width: 10, Height: 10},
+		{Path: "/p/B2.png", Name: "B2.png", MTime: at(3), Size: 10, Width: 40, Height: 30},
+		{Path: "/p/a.png", Name: "a.png", MTime: at(2), Size: 20, Width: 20, Height: 20},
+		{Path: "/q/a.png", Name: "a.png", MTime: at(2), Size: 20, Width: 20, Height: 20},
+	}
+	tests := []struct {
+		by, order, groupBy string
+		groups             []string
+		want               []string
+	}{
+		{"name", "asc", "", nil, []string{"/p/a.png", "/q/a.png", "/p/b10.png", "/p/B2.png"}},
+		{"name", "desc", "", nil, []string{"/p/B2.png", "/p/b10.png", "/p/a.png", "/q/a.png"}},
+		{"natural", "asc", "", nil, []string{"/p/a.png", "/q/a.png", "/p/B2.png", "/p/b10.png"}},
+		{"mtime", "desc", "", nil, []string{"/p/B2.png", "/p/a.png", "/q/a.png", "/p/b10.png"}},
+		{"size", "asc", "", nil, []string{"/p/B2.png", "/p/a.png", "/q/a.png", "/p/b10.png"}},
+		{"resolution", "desc", "", nil, []string{"/p/B2.png", "/p/a.png", "/q/a.png", "/p/b10.png"}},
+		{"name", "asc", groupDir, []string{"/p", "/p", "/p", "/q"}, []string{"/p/a.png", "/p/b10.png", "/p/B2.png", "/q/a.png"}},
+		{"name", "asc", groupDate, []string{"2023-12", "2024-01", "2024-01", "2023-12"}, []string{"/q/a.png", "/p/b10.png", "/p/a.png", "/p/B2.png"}},
+		{"name", "desc", groupDate, []string{"2023-12", "2024-01", "2024-01", "2023-12"}, []string{"/p/B2.png", "/p/a.png", "/p/b10.png", "/q/a.png"}},
+	}
+	for _, tt := range tests {
+		name := tt.by + " " + tt.order + " " + tt.groupBy
+		t.Run(name, func(t *testing.T) {
+			in := slices.Clone(cands)
+			for i, g := range tt.groups {
+				in[i].Group = g
+			}
+			// Tie-breaking must not depend on the order files arrived in.
+			slices.Reverse(in)
+			if err := sortCandidates(in, tt.by, tt.order, 1, tt.groupBy); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range in {
+				got = append(got, c.Path)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if err := sortCandidates(cands, "colour", "asc", 0, ""); err == nil {
+		t.Error("sorting by an unknown field did not fail")
+	}
+}
+
+func TestSortRandomIsSeeded(t *testing.T) {
+	var cands []Candidate
+	for _, p := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		cands = append(cands, Candidate{Path: "/" + p, Name: p})
+	}
+	order := func(seed uint64, in []Candidate) []string {
+		in = slices.Clone(in)
+		if err := sortCandidates(in, "random", "asc", seed, ""); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, c := range in {
+			out = append(out, c.Path)
+		}
+		return out
+	}
+	first := order(1, cands)
+	reversed := slices.Clone(cands)
+	slices.Reverse(reversed)
+	if again := order(1, reversed); !slices.Equal(first, again) {
+		t.Errorf("same seed gave %q and %q", first, again)
+	}
+	if other := order(2, cands); slices.Equal(first, other) {
+		t.Errorf("seeds 1 and 2 both gave %q", first)
+	}
+}
+
+func TestSortCycle(t *testing.T) {
+	tests := []struct {
+		by       string
+		lazyStat bool
+		want     []string
+	}{
+		{"name", false, []string{"name asc", "natural asc", "mtime asc", "size asc"}},
+		{"name", true, []string{"name asc", "natural asc"}},
+		{"size", false, []string{"size asc", "name asc", "natural asc", "mtime asc"}},
+		{"resolution", false, []string{"resolution asc", "name asc", "natural asc", "mtime asc", "size asc", "duration asc"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range sortCycle(tt.by, "ASC", 0, "", tt.lazyStat) {
+			got = append(got, s.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("sortCycle(%s, lazy %v) = %q, want %q", tt.by, tt.lazyStat, got, tt.want)
+		}
+	}
+}
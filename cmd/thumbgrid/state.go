@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+)
+
+// statePath names a file kept between runs under $XDG_STATE_HOME (default
+// ~/.local/state), like the +/- zoom level.
+func statePath(name string) string {
+	if x := os.Getenv("XDG_STATE_HOME"); x != "" {
+		return filepath.Join(x, "thumbgrid", name)
+	}
+	home, _ := os.UserHomeDir()
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "thumbgrid", name)
+}
+
+func loadZoom() int {
+	p := statePath("zoom")
+	if p == "" {
+		return 0
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// saveZoom is best effort; a read-only home just forgets the zoom.
+func saveZoom(n int) {
+	p := statePath("zoom")
+	if p == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return
+	}
+	_ = os.WriteFile(p, []byte(strconv.Itoa(n)+"\n"), 0o644)
+}
+
+// sessions.json in the cache dir remembers where -resume left each
+// directory; the least recently used are dropped past maxSessions.
+const maxSessions = 200
+
+type session struct {
+	picker.State
+	Used time.Time
+}
+
+func sessionsPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "sessions.json")
+}
+
+func readSessions(cacheDir string) map[string]session {
+	m := make(map[string]session)
+	if b, err := os.ReadFile(sessionsPath(cacheDir)); err == nil {
+		_ = json.Unmarshal(b, &m)
+	}
+	return m
+}
+
+func loadSession(cacheDir, dir string) *picker.State {
+	s, ok := readSessions(cacheDir)[dir]
+	if !ok {
+		return nil
+	}
+	return &s.State
+}
+
+// saveSession is best effort, like saveZoom.
+func saveSession(cacheDir, dir string, st picker.State) {
+	m := readSessions(cacheDir)
+	m[dir] = session{st, time.Now()}
+	if len(m) > maxSessions {
+		keys := slices.SortedFunc(maps.Keys(m), func(a, b string) int { return m[b].Used.Compare(m[a].Used) })
+		for _, k := range keys[maxSessions:] {
+			delete(m, k)
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil || os.MkdirAll(cacheDir, 0o755) != nil {
+		return
+	}
+	p := sessionsPath(cacheDir)
+	tmp := fmt.Sprintf("%s.%d.tmp", p, os.Getpid())
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return
+	}
+	if os.Rename(tmp, p) != nil {
+		_ = os.Remove(tmp)
+	}
+}
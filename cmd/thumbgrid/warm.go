@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/config"
+	"github.com/ck-zhang/thumbgrid/internal/term"
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+const warmUsage = `thumbgrid warm [-size WxH] [-backend NAME] [-jobs N] [-hidden] [-filter image|video|both] [PATH]
+
+Generate the thumbnail of every image and video under PATH (default .)
+ahead of time, e.g. from a nightly cron job. -size is the thumbnail size in
+pixels; the default matches the grid's tiles, as sized by tile_width and
+tile_height in the config, with the terminal's cells or else 10x20 pixels,
+and the square sizes
+kitty's tiles share when -backend (or the config, or the terminal warm
+runs in) says kitty.`
+
+func runWarmCommand(args []string, fc config.File) int {
+	fs := flag.NewFlagSet("warm", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, warmUsage) }
+	size := fs.String("size", "", "Thumbnail size in pixels (default: the grid's)")
+	backend := fs.String("backend", defaultBackend(fc), "Graphics the thumbnails are for, which decides their default size")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Thumbnails to generate at once")
+	hidden := fs.Bool("hidden", false, "Include dotfiles and directories such as .cache")
+	filter := fs.String("filter", orDefault(fc.Filter, filterBoth), "Filter candidate types")
+	xdgThumbs := fs.Bool("xdg-thumbnails", false, "Fill the shared freedesktop.org thumbnail cache too")
+	if err := fs.Parse(args); err != nil {
+		return 64
+	}
+	if *size == "" {
+		*size = defaultWarmSize(fc, *backend)
+	}
+	w, h, err := parseSize(*size)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: warm: %v\n", err)
+		return 64
+	}
+	if *jobs < 1 {
+		fmt.Fprintln(os.Stderr, "thumbgrid: warm: -jobs must be at least 1")
+		return 64
+	}
+	normFilter, err := normalizeFilter(*filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: warm: %v\n", err)
+		return 64
+	}
+	cfg, err := thumbConfig(fc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: warm: config: %v\n", err)
+		return 64
+	}
+	cfg.Path = orDefault(fs.Arg(0), ".")
+	cfg.XDGThumbnails = cfg.XDGThumbnails || *xdgThumbs
+	cfg.Filter, cfg.Hidden = normFilter, *hidden
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var paths []string
+	if err := scanPath(ctx, cfg.Path, cfg, func(c Candidate) {
+		paths = append(paths, c.Path)
+	}); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "thumbgrid: warm: %v\n", err)
+		return 65
+	}
+	thumbs := renderThumbs(ctx, newGenerator(cfg), paths, w, h, *jobs)
+	if ctx.Err() != nil {
+		fmt.Fprintln(os.Stderr, "thumbgrid: warm: interrupted")
+		return 130
+	}
+	fmt.Fprintf(os.Stderr, "%d thumbnails, %d failed\n", len(paths), countEmpty(thumbs))
+	return 0
+}
+
+// renderThumbs makes a w×h thumbnail of each path with jobs workers, with a
+// progress bar when stderr is a terminal. Those that fail, or are not
+// reached before ctx is done, are left "".
+func renderThumbs(ctx context.Context, gen *thumb.Generator, paths []string, w, h, jobs int) []string {
+	thumbs := make([]string, len(paths))
+	work := make(chan int)
+	var done atomic.Int64
+	var wg sync.WaitGroup
+	for range min(jobs, max(1, len(paths))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				thumbs[i], _ = gen.GenerateRect(ctx, paths[i], w, h)
+				done.Add(1)
+			}
+		}()
+	}
+	progress := isTerminal(os.Stderr.Fd())
+	finished := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case <-tick.C:
+				if progress {
+					fmt.Fprint(os.Stderr, "\r"+progressBar(int(done.Load()), len(paths), 30))
+				}
+			}
+		}
+	}()
+feed:
+	for i := range paths {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	close(finished)
+	if progress {
+		fmt.Fprint(os.Stderr, "\r"+progressBar(int(done.Load()), len(paths), 30)+"\n")
+	}
+	return thumbs
+}
+
+func countEmpty(s []string) int {
+	n := 0
+	for _, v := range s {
+		if v == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// thumbConfig holds the settings from the config file and environment that
+// decide what a thumbnail looks like, for the subcommands without the full
+// flag set.
+func thumbConfig(fc config.File) (Config, error) {
+	cols, rows, err := parseFilmstrip(fc.Filmstrip)
+	if err != nil {
+		return Config{}, err
+	}
+	seek, err := thumb.ParseSeek(fc.VideoSeek)
+	if err != nil {
+		return Config{}, fmt.Errorf("video-seek: %w", err)
+	}
+	format, err := thumb.ParseFormat(fc.CacheFormat)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{
+		CacheDir:      defaultCacheDir(fc.CacheDir),
+		XDGThumbnails: os.Getenv("THUMBGRID_XDG_THUMBNAILS") != "",
+		StripCols:     cols,
+		StripRows:     rows,
+		VideoSeek:     seek,
+		Nice:          fc.Nice,
+		CacheFormat:   format,
+	}, nil
+}
+
+// defaultBackend is -backend's default: $THUMBGRID_BACKEND, the config's,
+// or auto.
+func defaultBackend(fc config.File) string {
+	return orDefault(os.Getenv("THUMBGRID_BACKEND"), orDefault(fc.Backend, "auto"))
+}
+
+// defaultWarmSize is the size the picker asks for before any zoom.
+func defaultWarmSize(fc config.File, backend string) string {
+	tw, th := 18, 6
+	if fc.TileWidth > 0 {
+		tw = fc.TileWidth
+	}
+	if fc.TileHeight > 0 {
+		th = fc.TileHeight
+	}
+	cw, ch := 10, 20
+	if x, y, ok := term.CellSizeFromWinsize(); ok {
+		cw, ch = x, y
+	}
+	w, h := picker.TileThumbSize(tw, th, cw, ch, term.ScalesLikely(backend))
+	return fmt.Sprintf("%dx%d", w, h)
+}
+
+// parseSize reads a WxH pixel size, e.g. 160x60.
+func parseSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	w, err1 := strconv.Atoi(ws)
+	h, err2 := strconv.Atoi(hs)
+	if !ok || err1 != nil || err2 != nil || w < 8 || h < 8 {
+		return 0, 0, fmt.Errorf("invalid size %q (expected WxH in pixels, each at least 8)", s)
+	}
+	return w, h, nil
+}
+
+func progressBar(done, total, width int) string {
+	filled := width
+	if total > 0 {
+		filled = done * width / total
+	}
+	return fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat(" ", width-filled), done, total)
+}
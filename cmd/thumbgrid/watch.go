@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/pkg/picker"
+	"github.com/fsnotify/fsnotify"
+)
+
+// A watched file is reported once nothing has happened to it for
+// settleDelay, so one being written arrives finished, and once. One written
+// to without pause, like a log or a download, is reported every settleMax.
+const (
+	settleDelay = 250 * time.Millisecond
+	settleMax   = 2 * time.Second
+)
+
+// settling is when a pending path was first and last heard of.
+type settling struct{ first, last time.Time }
+
+// settled takes the paths due at now out of pending, and returns them with
+// when the next one left is due, or the zero time if none is.
+func settled(pending map[string]settling, now time.Time) ([]string, time.Time) {
+	var due []string
+	var next time.Time
+	for p, s := range pending {
+		at := s.last.Add(settleDelay)
+		if limit := s.first.Add(settleMax); limit.Before(at) {
+			at = limit
+		}
+		if !at.After(now) {
+			due = append(due, p)
+			delete(pending, p)
+		} else if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return due, next
+}
+
+// watchTree reports changes under cfg.Path for -watch: new and modified
+// files as candidates, and paths that went away as removals. It watches
+// the directories the scan walks, which it learns through the returned
+// enter, leaving out the same hidden and ignored ones.
+func watchTree(cfg Config) (<-chan picker.Batch, func(dir string), func(), error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	root := cfg.Path
+	if err := w.Add(root); err != nil {
+		w.Close()
+		return nil, nil, nil, err
+	}
+	enter := func(dir string) { _ = w.Add(dir) }
+	cfg.Entered = enter
+	ign := cfg.ignorer(root)
+
+	out := make(chan picker.Batch)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		defer w.Close()
+		pending := make(map[string]settling)
+		var settle <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				now := time.Now()
+				st, ok := pending[ev.Name]
+				if !ok {
+					st.first = now
+				}
+				st.last = now
+				pending[ev.Name] = st
+				// The timer may go off before this path is due; the
+				// paths that are, if any, go then, and it is set again.
+				if settle == nil {
+					settle = time.After(settleDelay)
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			case <-settle:
+				due, next := settled(pending, time.Now())
+				settle = nil
+				if !next.IsZero() {
+					settle = time.After(time.Until(next))
+				}
+				var b picker.Batch
+				seen := make(map[string]bool)
+				for _, p := range due {
+					info, err := os.Stat(p)
+					switch {
+					case err != nil:
+						b.Removed = append(b.Removed, p)
+					case info.IsDir():
+						// A new directory is walked as the scan would,
+						// which watches it and what is under it.
+						_ = scanUnder(context.Background(), root, p, cfg, func(c Candidate) {
+							if !seen[c.Path] {
+								seen[c.Path] = true
+								b.Candidates = append(b.Candidates, c)
+							}
+						})
+					case !seen[p] && !cfg.excluded(root, p, false, ign):
+						if c, ok := cfg.candidate(root, p, func() (os.FileInfo, error) { return info, nil }); ok {
+							seen[p] = true
+							b.Candidates = append(b.Candidates, c)
+						}
+					}
+				}
+				if len(b.Candidates) == 0 && len(b.Removed) == 0 {
+					continue
+				}
+				select {
+				case out <- b:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return cfg.probe(out), enter, func() { close(done) }, nil
+}
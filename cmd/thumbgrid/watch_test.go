@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSettled(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+	tests := []struct {
+		name     string
+		pending  map[string]settling
+		now      time.Time
+		want     []string
+		wantNext time.Time
+		left     int
+	}{
+		{
+			name:    "none",
+			pending: map[string]settling{},
+			now:     ms(0),
+		},
+		{
+			name:     "still changing",
+			pending:  map[string]settling{"a": {ms(0), ms(100)}},
+			now:      ms(200),
+			wantNext: ms(350),
+			left:     1,
+		},
+		{
+			name:    "quiet for settleDelay",
+			pending: map[string]settling{"a": {ms(0), ms(100)}},
+			now:     ms(350),
+			want:    []string{"a"},
+		},
+		{
+			// A file written to without pause is reported every settleMax.
+			name:    "busy past settleMax",
+			pending: map[string]settling{"a": {ms(0), ms(1900)}},
+			now:     ms(2000),
+			want:    []string{"a"},
+		},
+		{
+			name:     "busy before settleMax",
+			pending:  map[string]settling{"a": {ms(0), ms(1900)}},
+			now:      ms(1950),
+			wantNext: ms(2000),
+			left:     1,
+		},
+		{
+			// A busy file does not hold back one that settled.
+			name: "each on its own",
+			pending: map[string]settling{
+				"busy":  {ms(900), ms(1000)},
+				"done":  {ms(0), ms(0)},
+				"later": {ms(950), ms(1050)},
+			},
+			now:      ms(1100),
+			want:     []string{"done"},
+			wantNext: ms(1250),
+			left:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next := settled(tt.pending, tt.now)
+			slices.Sort(due)
+			if !slices.Equal(due, tt.want) {
+				t.Errorf("due = %q, want %q", due, tt.want)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("next = %v, want %v", next.Sub(t0), tt.wantNext.Sub(t0))
+			}
+			if len(tt.pending) != tt.left {
+				t.Errorf("%d left pending, want %d", len(tt.pending), tt.left)
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ module github.com/ck-zhang/thumbgrid
 go 1.23
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/mattn/go-runewidth v0.0.16
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/image v0.18.0
 	golang.org/x/sys v0.25.0
 	golang.org/x/term v0.24.0
 )
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type File struct {
+	Filter      string
+	Sort        string
+	Order       string
+	Backend     string
+	CacheDir    string
+	CacheFormat string
+	Filmstrip   string
+	Layout      string
+	VideoSeek   string
+	OpenCmd     string
+	TileWidth   int
+	TileHeight  int
+	Columns     int
+	Jobs        int
+	Height      string
+	Wheel       string
+	Search      string
+	History     bool
+	Index       bool
+	Nice        bool
+	Keys        map[string]string
+	Colors      map[string]string
+}
+
+func DefaultPath() string {
+	if v := os.Getenv("THUMBGRID_CONFIG"); v != "" {
+		return v
+	}
+	if x := os.Getenv("XDG_CONFIG_HOME"); x != "" {
+		return filepath.Join(x, "thumbgrid", "config.toml")
+	}
+	home, _ := os.UserHomeDir()
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".config", "thumbgrid", "config.toml")
+}
+
+func Load(path string) (File, error) {
+	f := File{Keys: map[string]string{}, Colors: map[string]string{}}
+	if path == "" {
+		return f, nil
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return f, err
+	}
+	defer fh.Close()
+
+	section := ""
+	sc := bufio.NewScanner(fh)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return f, fmt.Errorf("%s:%d: malformed table header", path, n)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return f, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		val, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return f, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if err := f.set(section, key, val); err != nil {
+			return f, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	return f, sc.Err()
+}
+
+func (f *File) set(section, key string, val any) error {
+	switch section {
+	case "":
+		switch key {
+		case "filter":
+			return setString(&f.Filter, key, val)
+		case "sort":
+			return setString(&f.Sort, key, val)
+		case "order":
+			return setString(&f.Order, key, val)
+		case "backend":
+			return setString(&f.Backend, key, val)
+		case "cache_dir":
+			if err := setString(&f.CacheDir, key, val); err != nil {
+				return err
+			}
+			f.CacheDir = expandHome(f.CacheDir)
+			return nil
+		case "cache_format":
+			return setString(&f.CacheFormat, key, val)
+		case "filmstrip":
+			return setString(&f.Filmstrip, key, val)
+		case "layout":
+			return setString(&f.Layout, key, val)
+		case "video_seek":
+			return setString(&f.VideoSeek, key, val)
+		case "open_cmd":
+			return setString(&f.OpenCmd, key, val)
+		case "tile_width":
+			return setInt(&f.TileWidth, key, val)
+		case "tile_height":
+			return setInt(&f.TileHeight, key, val)
+		case "columns":
+			return setInt(&f.Columns, key, val)
+		case "height":
+			return setString(&f.Height, key, val)
+		case "wheel":
+			return setString(&f.Wheel, key, val)
+		case "search":
+			return setString(&f.Search, key, val)
+		case "history":
+			return setBool(&f.History, key, val)
+		case "index":
+			return setBool(&f.Index, key, val)
+		case "jobs":
+			return setInt(&f.Jobs, key, val)
+		case "nice":
+			return setBool(&f.Nice, key, val)
+		}
+	case "keys":
+		var s string
+		if err := setString(&s, key, val); err != nil {
+			return err
+		}
+		f.Keys[key] = s
+		return nil
+	case "colors":
+		var s string
+		if err := setString(&s, key, val); err != nil {
+			return err
+		}
+		f.Colors[key] = s
+		return nil
+	default:
+		return fmt.Errorf("unknown table [%s]", section)
+	}
+	return fmt.Errorf("unknown key %q", key)
+}
+
+func setString(dst *string, key string, val any) error {
+	s, ok := val.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected a string", key)
+	}
+	*dst = s
+	return nil
+}
+
+func setBool(dst *bool, key string, val any) error {
+	b, ok := val.(bool)
+	if !ok {
+		return fmt.Errorf("%s: expected true or false", key)
+	}
+	*dst = b
+	return nil
+}
+
+func setInt(dst *int, key string, val any) error {
+	n, ok := val.(int)
+	if !ok {
+		return fmt.Errorf("%s: expected an integer", key)
+	}
+	*dst = n
+	return nil
+}
+
+func parseValue(raw string) (any, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("missing value")
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, `"`):
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", raw)
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return nil, fmt.Errorf("bad string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	n, err := strconv.Atoi(strings.ReplaceAll(raw, "_", ""))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported value %s", raw)
+	}
+	return n, nil
+}
+
+func stripComment(line string) string {
+	inStr := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inStr != 0 && c == '\\' && inStr == '"':
+			i++
+		case inStr != 0 && c == inStr:
+			inStr = 0
+		case inStr == 0 && (c == '"' || c == '\''):
+			inStr = c
+		case inStr == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
@@ -0,0 +1,417 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	tagCompression     = 0x0103
+	tagStripOffsets    = 0x0111
+	tagOrientation     = 0x0112
+	tagStripByteCounts = 0x0117
+	tagThumbOffset     = 0x0201
+	tagThumbLength     = 0x0202
+	tagDateTime        = 0x0132
+	tagMake            = 0x010f
+	tagModel           = 0x0110
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagExposureTime    = 0x829a
+	tagFNumber         = 0x829d
+	tagISO             = 0x8827
+	tagDateTimeOrig    = 0x9003
+	tagFocalLength     = 0x920a
+	tagLensModel       = 0xa434
+
+	maxPreview = 32 << 20
+)
+
+var errNoExif = errors.New("no exif data")
+
+type entry struct {
+	typ   uint16
+	count uint32
+	value [4]byte
+}
+
+type tiff struct {
+	r    io.ReaderAt
+	base int64
+	bo   binary.ByteOrder
+}
+
+// Orientation returns the EXIF orientation (1-8) of a JPEG or TIFF-based
+// file, or 1 when there is none.
+func Orientation(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	t, err := open(f)
+	if err != nil {
+		return 1
+	}
+	ifd0, _, err := t.ifd(t.first())
+	if err != nil {
+		return 1
+	}
+	if e, ok := ifd0[tagOrientation]; ok {
+		if o := int(t.bo.Uint16(e.value[:2])); o >= 1 && o <= 8 {
+			return o
+		}
+	}
+	return 1
+}
+
+// DateTime returns when a photo was taken: DateTimeOriginal, or the IFD0
+// DateTime when that is missing. EXIF has no zone, so it is local time.
+func DateTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	t, err := open(f)
+	if err != nil {
+		return time.Time{}, false
+	}
+	ifd0, _, err := t.ifd(t.first())
+	if err != nil {
+		return time.Time{}, false
+	}
+	if p, ok := ifd0[tagExifIFD]; ok {
+		if sub, _, err := t.ifd(t.long(p)); err == nil {
+			if d, ok := t.date(sub[tagDateTimeOrig]); ok {
+				return d, true
+			}
+		}
+	}
+	return t.date(ifd0[tagDateTime])
+}
+
+// Details is what a photo's EXIF says about the camera and the shot.
+// Fields the file doesn't record are zero.
+type Details struct {
+	Make, Model, Lens string
+	ISO               int
+	// Exposure is in seconds and FocalLength in millimetres.
+	Exposure, FNumber, FocalLength float64
+	// Lat and Lon are in degrees, south and west negative, when HasGPS.
+	Lat, Lon float64
+	HasGPS   bool
+}
+
+// ReadDetails returns the camera, lens, exposure and location recorded in a
+// JPEG or TIFF-based file.
+func ReadDetails(path string) (Details, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Details{}, false
+	}
+	defer f.Close()
+	t, err := open(f)
+	if err != nil {
+		return Details{}, false
+	}
+	ifd0, _, err := t.ifd(t.first())
+	if err != nil {
+		return Details{}, false
+	}
+	d := Details{Make: t.ascii(ifd0[tagMake]), Model: t.ascii(ifd0[tagModel])}
+	if p, ok := ifd0[tagExifIFD]; ok {
+		if sub, _, err := t.ifd(t.long(p)); err == nil {
+			d.Lens = t.ascii(sub[tagLensModel])
+			if e, ok := sub[tagISO]; ok {
+				d.ISO = int(t.long(e))
+			}
+			d.Exposure = t.rational(sub[tagExposureTime])
+			d.FNumber = t.rational(sub[tagFNumber])
+			d.FocalLength = t.rational(sub[tagFocalLength])
+		}
+	}
+	if p, ok := ifd0[tagGPSIFD]; ok {
+		if gps, _, err := t.ifd(t.long(p)); err == nil {
+			// Tags 1-4: latitude N/S and degrees, longitude E/W and degrees.
+			lat, okLat := t.degrees(gps[2])
+			lon, okLon := t.degrees(gps[4])
+			if okLat && okLon {
+				if t.ascii(gps[1]) == "S" {
+					lat = -lat
+				}
+				if t.ascii(gps[3]) == "W" {
+					lon = -lon
+				}
+				d.Lat, d.Lon, d.HasGPS = lat, lon, true
+			}
+		}
+	}
+	return d, true
+}
+
+// ascii reads an ASCII entry, which sits in the value itself when it is
+// four bytes or less.
+func (t *tiff) ascii(e entry) string {
+	if e.typ != 2 || e.count == 0 || e.count > 256 {
+		return ""
+	}
+	buf := e.value[:min(e.count, 4)]
+	if e.count > 4 {
+		buf = make([]byte, e.count)
+		if _, err := t.r.ReadAt(buf, t.base+int64(t.bo.Uint32(e.value[:]))); err != nil {
+			return ""
+		}
+	}
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(bytes.TrimSpace(buf))
+}
+
+// rationals reads the numbers of a RATIONAL entry, which is always stored
+// at an offset.
+func (t *tiff) rationals(e entry) []float64 {
+	if e.typ != 5 || e.count == 0 || e.count > 16 {
+		return nil
+	}
+	buf := make([]byte, 8*e.count)
+	if _, err := t.r.ReadAt(buf, t.base+int64(t.bo.Uint32(e.value[:]))); err != nil {
+		return nil
+	}
+	out := make([]float64, e.count)
+	for i := range out {
+		num, den := t.bo.Uint32(buf[8*i:]), t.bo.Uint32(buf[8*i+4:])
+		if den == 0 {
+			return nil
+		}
+		out[i] = float64(num) / float64(den)
+	}
+	return out
+}
+
+func (t *tiff) rational(e entry) float64 {
+	if r := t.rationals(e); len(r) > 0 {
+		return r[0]
+	}
+	return 0
+}
+
+// degrees reads a GPS coordinate stored as degrees, minutes and seconds.
+func (t *tiff) degrees(e entry) (float64, bool) {
+	r := t.rationals(e)
+	if len(r) != 3 {
+		return 0, false
+	}
+	return r[0] + r[1]/60 + r[2]/3600, true
+}
+
+// date parses an ASCII "2006:01:02 15:04:05" entry.
+func (t *tiff) date(e entry) (time.Time, bool) {
+	if e.typ != 2 || e.count < 19 || e.count > 64 {
+		return time.Time{}, false
+	}
+	buf := make([]byte, 19)
+	if _, err := t.r.ReadAt(buf, t.base+int64(t.bo.Uint32(e.value[:]))); err != nil {
+		return time.Time{}, false
+	}
+	d, err := time.ParseInLocation("2006:01:02 15:04:05", string(buf), time.Local)
+	return d, err == nil
+}
+
+// Preview is an embedded JPEG inside a file.
+type Preview struct {
+	Offset int64
+	Length int64
+}
+
+// Previews lists the JPEG thumbnails and previews embedded in a JPEG or RAW
+// file: the EXIF thumbnail first, then any JPEG-compressed IFD0 strip (e.g.
+// the full-size preview in CR2 files) or the RAF preview.
+func Previews(path string) ([]Preview, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Preview
+	if t, err := open(f); err == nil {
+		out = t.previews()
+	}
+	if raf, ok := rafPreview(f); ok {
+		out = append(out, raf)
+	}
+	return out, nil
+}
+
+func (t *tiff) previews() []Preview {
+	ifd0, next, err := t.ifd(t.first())
+	if err != nil {
+		return nil
+	}
+	var out []Preview
+	add := func(ifd map[uint16]entry, offTag, lenTag uint16) {
+		o, ok1 := ifd[offTag]
+		n, ok2 := ifd[lenTag]
+		if !ok1 || !ok2 || o.count != 1 || n.count != 1 {
+			return
+		}
+		p := Preview{Offset: t.base + int64(t.long(o)), Length: int64(t.long(n))}
+		if p.Length <= 0 || p.Length > maxPreview {
+			return
+		}
+		var soi [2]byte
+		if _, err := t.r.ReadAt(soi[:], p.Offset); err != nil || soi[0] != 0xff || soi[1] != 0xd8 {
+			return
+		}
+		out = append(out, p)
+	}
+	if ifd1, _, err := t.ifd(next); err == nil {
+		add(ifd1, tagThumbOffset, tagThumbLength)
+	}
+	add(ifd0, tagThumbOffset, tagThumbLength)
+	if c, ok := ifd0[tagCompression]; ok && t.bo.Uint16(c.value[:2]) == 6 {
+		add(ifd0, tagStripOffsets, tagStripByteCounts)
+	}
+	return out
+}
+
+func rafPreview(r io.ReaderAt) (Preview, bool) {
+	var hdr [92]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[:16]) != "FUJIFILMCCD-RAW " {
+		return Preview{}, false
+	}
+	p := Preview{
+		Offset: int64(binary.BigEndian.Uint32(hdr[84:88])),
+		Length: int64(binary.BigEndian.Uint32(hdr[88:92])),
+	}
+	return p, p.Length > 0 && p.Length <= maxPreview
+}
+
+// ReadPreview returns the bytes of p from the file at path.
+func ReadPreview(path string, p Preview) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, p.Length)
+	if _, err := f.ReadAt(buf, p.Offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// open finds the TIFF structure either at the start of the file, inside a
+// JPEG APP1 segment, or inside the JPEG embedded in a Fujifilm RAF.
+func open(r io.ReaderAt) (*tiff, error) {
+	if t, ok := tiffAt(r, 0); ok {
+		return t, nil
+	}
+	var hdr [16]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:]) == "FUJIFILMCCD-RAW " {
+		var off [4]byte
+		if _, err := r.ReadAt(off[:], 84); err != nil {
+			return nil, err
+		}
+		return jpegTIFF(r, int64(binary.BigEndian.Uint32(off[:])))
+	}
+	return jpegTIFF(r, 0)
+}
+
+func jpegTIFF(r io.ReaderAt, start int64) (*tiff, error) {
+	var soi [2]byte
+	if _, err := r.ReadAt(soi[:], start); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xff || soi[1] != 0xd8 {
+		return nil, errNoExif
+	}
+	for off := start + 2; ; {
+		var seg [10]byte
+		if _, err := r.ReadAt(seg[:4], off); err != nil {
+			return nil, err
+		}
+		if seg[0] != 0xff {
+			return nil, errNoExif
+		}
+		marker := seg[1]
+		if marker == 0xff {
+			off++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			return nil, errNoExif
+		}
+		n := int64(binary.BigEndian.Uint16(seg[2:4]))
+		if marker == 0xe1 && n >= 8 {
+			if _, err := r.ReadAt(seg[4:10], off+4); err == nil && bytes.Equal(seg[4:10], []byte("Exif\x00\x00")) {
+				if t, ok := tiffAt(r, off+10); ok {
+					return t, nil
+				}
+			}
+		}
+		off += 2 + n
+	}
+}
+
+func tiffAt(r io.ReaderAt, base int64) (*tiff, bool) {
+	var hdr [4]byte
+	if _, err := r.ReadAt(hdr[:], base); err != nil {
+		return nil, false
+	}
+	// Olympus (IIRO/MMOR) and Panasonic (IIU) RAWs use their own magic.
+	switch string(hdr[:]) {
+	case "II*\x00", "IIRO", "IIU\x00":
+		return &tiff{r: r, base: base, bo: binary.LittleEndian}, true
+	case "MM\x00*", "MMOR":
+		return &tiff{r: r, base: base, bo: binary.BigEndian}, true
+	}
+	return nil, false
+}
+
+func (t *tiff) first() uint32 {
+	var b [4]byte
+	if _, err := t.r.ReadAt(b[:], t.base+4); err != nil {
+		return 0
+	}
+	return t.bo.Uint32(b[:])
+}
+
+// long reads a SHORT or LONG entry value.
+func (t *tiff) long(e entry) uint32 {
+	if e.typ == 3 {
+		return uint32(t.bo.Uint16(e.value[:2]))
+	}
+	return t.bo.Uint32(e.value[:])
+}
+
+func (t *tiff) ifd(off uint32) (map[uint16]entry, uint32, error) {
+	if off == 0 {
+		return nil, 0, errNoExif
+	}
+	var nb [2]byte
+	if _, err := t.r.ReadAt(nb[:], t.base+int64(off)); err != nil {
+		return nil, 0, err
+	}
+	n := int(t.bo.Uint16(nb[:]))
+	buf := make([]byte, n*12+4)
+	if _, err := t.r.ReadAt(buf, t.base+int64(off)+2); err != nil {
+		return nil, 0, err
+	}
+	out := make(map[uint16]entry, n)
+	for i := 0; i < n; i++ {
+		b := buf[i*12:]
+		e := entry{typ: t.bo.Uint16(b[2:4]), count: t.bo.Uint32(b[4:8])}
+		copy(e.value[:], b[8:12])
+		out[t.bo.Uint16(b[0:2])] = e
+	}
+	return out, t.bo.Uint32(buf[n*12:]), nil
+}
@@ -0,0 +1,155 @@
+package fuzzy
+
+import (
+	"unicode"
+)
+
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	bonusBoundary    = scoreMatch / 2
+	bonusNonWord     = scoreMatch / 2
+	bonusCamel       = bonusBoundary + scoreGapExtension
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	bonusFirstChar   = 2
+)
+
+type charClass int
+
+const (
+	classNonWord charClass = iota
+	classLower
+	classUpper
+	classDigit
+	classLetter
+)
+
+// Match reports whether every rune of pattern appears in text in order,
+// ignoring case, and scores the shortest such window the way fzf's v1
+// algorithm does: word boundaries and consecutive runs rank higher, gaps
+// lower.
+func Match(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	pr := []rune(pattern)
+	for i, r := range pr {
+		pr[i] = unicode.ToLower(r)
+	}
+	tr := []rune(text)
+	lr := make([]rune, len(tr))
+	for i, r := range tr {
+		lr[i] = unicode.ToLower(r)
+	}
+
+	pi, start, end := 0, -1, -1
+	for i, r := range lr {
+		if r != pr[pi] {
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		pi++
+		if pi == len(pr) {
+			end = i + 1
+			break
+		}
+	}
+	if end < 0 {
+		return 0, false
+	}
+
+	pi = len(pr) - 1
+	for i := end - 1; i >= start; i-- {
+		if lr[i] == pr[pi] {
+			pi--
+			if pi < 0 {
+				start = i
+				break
+			}
+		}
+	}
+	return score(tr, lr, pr, start, end), true
+}
+
+func score(tr, lr, pr []rune, start, end int) int {
+	total := 0
+	pi := 0
+	consecutive := 0
+	firstBonus := 0
+	inGap := false
+	prev := classNonWord
+	if start > 0 {
+		prev = classOf(tr[start-1])
+	}
+	for i := start; i < end; i++ {
+		class := classOf(tr[i])
+		if pi < len(pr) && lr[i] == pr[pi] {
+			total += scoreMatch
+			b := bonusFor(prev, class)
+			if consecutive == 0 {
+				firstBonus = b
+			} else {
+				if b >= bonusBoundary && b > firstBonus {
+					firstBonus = b
+				}
+				b = max(b, firstBonus, bonusConsecutive)
+			}
+			if pi == 0 {
+				b *= bonusFirstChar
+			}
+			total += b
+			consecutive++
+			inGap = false
+			pi++
+		} else {
+			if inGap {
+				total += scoreGapExtension
+			} else {
+				total += scoreGapStart
+			}
+			inGap = true
+			consecutive = 0
+			firstBonus = 0
+		}
+		prev = class
+	}
+	return total
+}
+
+func classOf(r rune) charClass {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return classLower
+	case r >= 'A' && r <= 'Z':
+		return classUpper
+	case r >= '0' && r <= '9':
+		return classDigit
+	case unicode.IsLower(r):
+		return classLower
+	case unicode.IsUpper(r):
+		return classUpper
+	case unicode.IsLetter(r):
+		return classLetter
+	case unicode.IsNumber(r):
+		return classDigit
+	default:
+		return classNonWord
+	}
+}
+
+func bonusFor(prev, class charClass) int {
+	switch {
+	case prev == classNonWord && class != classNonWord:
+		return bonusBoundary
+	case prev == classLower && class == classUpper,
+		prev != classDigit && class == classDigit:
+		return bonusCamel
+	case class == classNonWord:
+		return bonusNonWord
+	}
+	return 0
+}
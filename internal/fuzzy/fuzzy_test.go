@@ -0,0 +1,49 @@
+package fuzzy
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		want          bool
+	}{
+		{"", "anything", true},
+		{"abc", "abc", true},
+		{"abc", "a_b_c", true},
+		{"ABC", "xaxbxc", true},
+		{"abc", "ABC.png", true},
+		{"été", "Été 2023", true},
+		{"acb", "abc", false},
+		{"abcd", "abc", false},
+		{"x", "", false},
+	}
+	for _, tt := range tests {
+		if _, ok := Match(tt.pattern, tt.text); ok != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.text, ok, tt.want)
+		}
+	}
+}
+
+// Each text of a pair is expected to score higher than the second.
+func TestMatchRanking(t *testing.T) {
+	tests := []struct {
+		pattern, better, worse string
+	}{
+		{"cat", "cat.png", "c_a_t.png"},
+		{"cat", "my_cat.png", "mycat.png"},
+		{"fb", "fooBar.jpg", "foobar.jpg"},
+		{"ph", "PhotoHome.jpg", "graph.jpg"},
+		// The shortest window counts, not the first one found.
+		{"ab", "a____ab", "a____a_b"},
+	}
+	for _, tt := range tests {
+		b, ok1 := Match(tt.pattern, tt.better)
+		w, ok2 := Match(tt.pattern, tt.worse)
+		if !ok1 || !ok2 {
+			t.Fatalf("%q does not match both %q and %q", tt.pattern, tt.better, tt.worse)
+		}
+		if b <= w {
+			t.Errorf("%q: %q scored %d, not above %q's %d", tt.pattern, tt.better, b, tt.worse, w)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package ignore applies .gitignore-style pattern files to a directory walk.
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// anchored rules match the path relative to their file's directory;
+	// the rest match the base name at any depth.
+	anchored bool
+}
+
+// Tree reads the named ignore files from every directory under root as the
+// walk reaches it, and from those above it up to the top of the git
+// repository it is in, whose .git/info/exclude applies too. Rules in deeper
+// directories win over those above, and later lines over earlier ones, as
+// with git. A Tree is safe for concurrent use, so a parallel walk can
+// share one.
+type Tree struct {
+	root  string
+	names []string
+	// base is the top of the repository, or root outside one; prefix is
+	// root relative to it, "" when they are the same. exclude is the
+	// repository's exclude file.
+	base, prefix string
+	exclude      string
+	mu           sync.Mutex
+	dirs         map[string][]rule
+}
+
+func New(root string, names ...string) *Tree {
+	root = filepath.Clean(root)
+	t := &Tree{root: root, base: root, names: names, dirs: make(map[string][]rule)}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return t
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if fi, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			up, _ := filepath.Rel(abs, dir)
+			t.base = filepath.Join(root, up)
+			if t.prefix, _ = filepath.Rel(dir, abs); t.prefix == "." {
+				t.prefix = ""
+			}
+			t.exclude = filepath.Join(gitDir(dir, fi), "info", "exclude")
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return t
+}
+
+// gitDir finds the repository's git directory: .git itself, or where a
+// .git file points, as in a worktree or submodule.
+func gitDir(top string, fi os.FileInfo) string {
+	dotGit := filepath.Join(top, ".git")
+	if fi.IsDir() {
+		return dotGit
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return dotGit
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return dotGit
+	}
+	if dir = strings.TrimSpace(dir); !filepath.IsAbs(dir) {
+		dir = filepath.Join(top, dir)
+	}
+	return dir
+}
+
+// Ignored reports whether path, which lies under the root, is excluded.
+// Callers should not descend into ignored directories.
+func (t *Tree) Ignored(path string, isDir bool) bool {
+	path = filepath.Clean(path)
+	if path == t.root {
+		return false
+	}
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	if t.prefix != "" {
+		rel = filepath.Join(t.prefix, rel)
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	ignored := false
+	dir := t.base
+	for i := range parts {
+		sub := strings.Join(parts[i:], "/")
+		for _, r := range t.rules(dir) {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			target := sub
+			if !r.anchored {
+				target = parts[len(parts)-1]
+			}
+			if r.re.MatchString(target) {
+				ignored = !r.negate
+			}
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+func (t *Tree) rules(dir string) []rule {
+	t.mu.Lock()
+	rs, ok := t.dirs[dir]
+	t.mu.Unlock()
+	if ok {
+		return rs
+	}
+	if dir == t.base && t.exclude != "" {
+		rs = load(t.exclude)
+	}
+	for _, name := range t.names {
+		rs = append(rs, load(filepath.Join(dir, name))...)
+	}
+	t.mu.Lock()
+	t.dirs[dir] = rs
+	t.mu.Unlock()
+	return rs
+}
+
+func load(path string) []rule {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rs []rule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if r, ok := parse(sc.Text()); ok {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+func parse(line string) (rule, bool) {
+	line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if s, ok := strings.CutSuffix(line, "/"); ok {
+		r.dirOnly = true
+		line = s
+	}
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+	re, err := regexp.Compile("^" + translate(line) + "$")
+	if err != nil {
+		return rule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// translate turns a glob with git's ** into a regular expression.
+func translate(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob) && (i == 0 || glob[i-1] == '/'):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return b.String()
+}
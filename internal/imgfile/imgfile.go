@@ -0,0 +1,179 @@
+// Package imgfile reads cached thumbnails in any of the formats the cache
+// can keep them in: PNG, WebP or AVIF.
+package imgfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// Ext is the file extension for encoded image data: ".webp", ".avif", or
+// ".png" for anything else.
+func Ext(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return ".webp"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis"):
+		return ".avif"
+	}
+	return ".png"
+}
+
+// Intact reports whether the file at path holds a whole image, going by its
+// signature and, for PNG and WebP, how it ends or the length it declares.
+// AVIF only has its signature checked.
+func Intact(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	var head [12]byte
+	if _, err := io.ReadFull(f, head[:]); err != nil || Ext(head[:]) != strings.ToLower(filepath.Ext(path)) {
+		return false
+	}
+	switch Ext(head[:]) {
+	case ".png":
+		var tail [12]byte
+		if _, err := f.ReadAt(tail[:], fi.Size()-int64(len(tail))); err != nil {
+			return false
+		}
+		return bytes.HasPrefix(head[:], pngSig) && bytes.Equal(tail[:], pngEnd)
+	case ".webp":
+		return int64(binary.LittleEndian.Uint32(head[4:8]))+8 == fi.Size()
+	}
+	return true
+}
+
+var (
+	pngSig = []byte("\x89PNG\r\n\x1a\n")
+	// pngEnd is the empty IEND chunk every PNG ends with.
+	pngEnd = []byte("\x00\x00\x00\x00IEND\xaeB`\x82")
+)
+
+// IsPNG reports whether path is a PNG, going by its extension as the cache
+// names files.
+func IsPNG(path string) bool {
+	return !strings.EqualFold(filepath.Ext(path), ".webp") && !strings.EqualFold(filepath.Ext(path), ".avif")
+}
+
+// Decode reads the image at path. Go has no AVIF decoder, so AVIF goes
+// through magick or ffmpeg.
+func Decode(path string) (image.Image, error) {
+	if strings.EqualFold(filepath.Ext(path), ".avif") {
+		data, err := avifPNG(path)
+		if err != nil {
+			return nil, err
+		}
+		return png.Decode(bytes.NewReader(data))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".webp") {
+		return webp.Decode(f)
+	}
+	return png.Decode(f)
+}
+
+// PNG returns the image at path as PNG data, converting it if it is stored
+// as something else, for terminals that only take PNG.
+func PNG(path string) ([]byte, error) {
+	if IsPNG(path) {
+		return os.ReadFile(path)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".avif") {
+		return avifPNG(path)
+	}
+	img, err := Decode(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// avifTimeout bounds one AVIF conversion, which runs while a frame is
+// being drawn.
+const avifTimeout = 5 * time.Second
+
+// avifKeep is how many converted AVIF thumbnails avifPNG remembers, since
+// the renderers ask for the same one again as it is redrawn.
+const avifKeep = 64
+
+type avifEntry struct {
+	size  int64
+	mtime time.Time
+	data  []byte
+}
+
+var (
+	avifMu    sync.Mutex
+	avifDone  = make(map[string]avifEntry)
+	avifOrder []string
+)
+
+// avifPNG converts the AVIF file at path to PNG data once, through magick
+// or ffmpeg, and keeps the result for the next call while the file is
+// unchanged.
+func avifPNG(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	avifMu.Lock()
+	e, ok := avifDone[path]
+	avifMu.Unlock()
+	if ok && e.size == fi.Size() && e.mtime.Equal(fi.ModTime()) {
+		return e.data, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), avifTimeout)
+	defer cancel()
+	var data []byte
+	switch {
+	case hasExec("magick"):
+		data, err = exec.CommandContext(ctx, "magick", path, "png:-").Output()
+	case hasExec("ffmpeg"):
+		data, err = exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", path, "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-").Output()
+	default:
+		return nil, errors.New("reading AVIF thumbnails needs magick or ffmpeg")
+	}
+	if err != nil {
+		return nil, err
+	}
+	avifMu.Lock()
+	if _, ok := avifDone[path]; !ok {
+		avifOrder = append(avifOrder, path)
+	}
+	avifDone[path] = avifEntry{fi.Size(), fi.ModTime(), data}
+	if len(avifOrder) > avifKeep {
+		delete(avifDone, avifOrder[0])
+		avifOrder = avifOrder[1:]
+	}
+	avifMu.Unlock()
+	return data, nil
+}
+
+func hasExec(name string) bool { _, err := exec.LookPath(name); return err == nil }
@@ -0,0 +1,201 @@
+// Package index keeps what thumbgrid learns about files (dimensions,
+// durations, capture dates, details) in a bolt database, so that runs over a
+// large library only probe the files that changed since the last one.
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucket = []byte("files")
+
+// flushSize and flushInterval bound how many updates wait in memory before
+// they are written in one transaction.
+const (
+	flushSize     = 512
+	flushInterval = time.Second
+)
+
+// A Record is what the index knows about one file. It holds while the file
+// keeps the Size and MTime it was recorded with.
+type Record struct {
+	Size  int64 `json:"size"`
+	MTime int64 `json:"mtime"`
+	// Width, Height and Duration (seconds) are known once Probed is set;
+	// they stay zero for files that couldn't be probed, and ProbeError
+	// says why.
+	Probed     bool    `json:"probed,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	ProbeError string  `json:"probe_error,omitempty"`
+	// Taken is the EXIF capture time in Unix seconds, 0 for none, once
+	// Dated is set.
+	Dated bool  `json:"dated,omitempty"`
+	Taken int64 `json:"taken,omitempty"`
+	// Details are the info panel's camera or codec lines, once Detailed is
+	// set.
+	Detailed bool    `json:"detailed,omitempty"`
+	Details  []Field `json:"details,omitempty"`
+}
+
+// A Field is one labelled line of a file's details.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (r Record) fresh(size int64, mtime time.Time) bool {
+	return r.Size == size && r.MTime == mtime.UnixNano()
+}
+
+// An Index is an open database. A nil *Index is valid and knows nothing,
+// so callers needn't check whether -index is on.
+type Index struct {
+	db *bolt.DB
+	// wd resolves relative paths; records are kept by absolute path.
+	wd      string
+	mu      sync.Mutex
+	pending map[string][]byte
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Open opens or creates the database at path. Only one process can have it
+// open; another gets an error after a short wait.
+func Open(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 100 * time.Millisecond, NoFreelistSync: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	wd, _ := os.Getwd()
+	x := &Index{db: db, wd: wd, pending: make(map[string][]byte), done: make(chan struct{})}
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+		t := time.NewTicker(flushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				x.flush()
+			case <-x.done:
+				return
+			}
+		}
+	}()
+	return x, nil
+}
+
+// Close writes what is pending and closes the database.
+func (x *Index) Close() error {
+	if x == nil {
+		return nil
+	}
+	close(x.done)
+	x.wg.Wait()
+	x.flush()
+	return x.db.Close()
+}
+
+// Lookup returns the record for path if it was made for a file of this size
+// and modification time.
+func (x *Index) Lookup(path string, size int64, mtime time.Time) (Record, bool) {
+	if x == nil {
+		return Record{}, false
+	}
+	r, ok := x.load(path)
+	return r, ok && r.fresh(size, mtime)
+}
+
+// Update changes path's record with fn, starting over when the file changed
+// since the record was made.
+func (x *Index) Update(path string, size int64, mtime time.Time, fn func(*Record)) {
+	if x == nil {
+		return
+	}
+	path = x.abs(path)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	old := x.pending[path]
+	if old == nil {
+		_ = x.db.View(func(tx *bolt.Tx) error {
+			old = bytes.Clone(tx.Bucket(bucket).Get([]byte(path)))
+			return nil
+		})
+	}
+	var r Record
+	if old != nil && json.Unmarshal(old, &r) == nil && !r.fresh(size, mtime) {
+		r = Record{}
+	}
+	r.Size, r.MTime = size, mtime.UnixNano()
+	fn(&r)
+	b, err := json.Marshal(r)
+	if err != nil || bytes.Equal(b, old) {
+		return
+	}
+	x.pending[path] = b
+	if len(x.pending) >= flushSize {
+		x.flushLocked()
+	}
+}
+
+func (x *Index) abs(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(x.wd, path)
+}
+
+func (x *Index) load(path string) (Record, bool) {
+	path = x.abs(path)
+	x.mu.Lock()
+	b := x.pending[path]
+	x.mu.Unlock()
+	if b == nil {
+		_ = x.db.View(func(tx *bolt.Tx) error {
+			b = bytes.Clone(tx.Bucket(bucket).Get([]byte(path)))
+			return nil
+		})
+	}
+	var r Record
+	return r, b != nil && json.Unmarshal(b, &r) == nil
+}
+
+func (x *Index) flush() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.flushLocked()
+}
+
+// flushLocked writes the pending records; it is called with mu held. A
+// failed write loses them, which only means probing those files again.
+func (x *Index) flushLocked() {
+	if len(x.pending) == 0 {
+		return
+	}
+	_ = x.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		for k, v := range x.pending {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	clear(x.pending)
+}
@@ -0,0 +1,102 @@
+// Package label keeps the ratings and tags users give files. They are stored in an
+// extended attribute on the file where the filesystem allows it, so they
+// follow the file around, and otherwise in a .thumbgrid.json sidecar in
+// the file's directory.
+package label
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Attribute names, as extended attributes; the sidecar uses the part after
+// the last dot. Tags use the freedesktop.org name, comma-separated, so file
+// managers that read it see them too.
+const (
+	attrRating = "user.thumbgrid.rating"
+	attrTags   = "user.xdg.tags"
+)
+
+// MaxRating is the most stars a file can have.
+const MaxRating = 5
+
+// Rating returns the stars given to path, 0 when it has none.
+func Rating(path string) int {
+	v, _ := get(path, attrRating)
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > MaxRating {
+		return 0
+	}
+	return n
+}
+
+// SetRating gives path n stars; 0 takes its rating away.
+func SetRating(path string, n int) error {
+	if n < 0 || n > MaxRating {
+		return fmt.Errorf("rating %d is out of range 0-%d", n, MaxRating)
+	}
+	v := ""
+	if n > 0 {
+		v = strconv.Itoa(n)
+	}
+	return set(path, attrRating, v)
+}
+
+// get reads attr from the file, then from the sidecar.
+func get(path, attr string) (string, bool) {
+	if v, err := getXattr(path, attr); err == nil {
+		return v, true
+	}
+	return sidecarGet(path, attr)
+}
+
+// set writes attr to the file, or to the sidecar when the file can't hold
+// it, and drops any copy the other one has. An empty value removes it.
+func set(path, attr, v string) error {
+	if v == "" {
+		_ = removeXattr(path, attr)
+		return sidecarSet(path, attr, "")
+	}
+	if err := setXattr(path, attr, v); err != nil {
+		return sidecarSet(path, attr, v)
+	}
+	if _, ok := sidecarGet(path, attr); ok {
+		return sidecarSet(path, attr, "")
+	}
+	return nil
+}
+
+// Tags returns the tags on path, in the order they were given.
+func Tags(path string) []string {
+	v, _ := get(path, attrTags)
+	return ParseTags(v)
+}
+
+// SetTags replaces the tags on path; none removes them.
+func SetTags(path string, tags []string) error {
+	return set(path, attrTags, strings.Join(ParseTags(strings.Join(tags, ",")), ","))
+}
+
+// ParseTags splits a comma-separated list of tags, dropping blanks and
+// repeats (which differ only in case).
+func ParseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" && !HasTag(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether tags holds tag, ignoring case.
+func HasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package label
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SidecarName is the file in each directory that holds the labels of files
+// whose filesystem has no extended attributes. It maps file names to their
+// attributes, e.g. {"a.jpg": {"rating": "4"}}.
+const SidecarName = ".thumbgrid.json"
+
+type sidecar struct {
+	mtime   time.Time
+	entries map[string]map[string]string
+}
+
+// sidecars caches each directory's sidecar until the file changes, since a
+// scan asks about every file in the directory.
+var (
+	sidecarMu sync.Mutex
+	sidecars  = make(map[string]sidecar)
+)
+
+func sidecarKey(attr string) string {
+	return attr[strings.LastIndexByte(attr, '.')+1:]
+}
+
+// loadSidecar returns the entries of the sidecar at p, empty when there is
+// none. Called with sidecarMu held.
+func loadSidecar(p string) map[string]map[string]string {
+	info, err := os.Stat(p)
+	if err != nil {
+		delete(sidecars, p)
+		return map[string]map[string]string{}
+	}
+	if s, ok := sidecars[p]; ok && s.mtime.Equal(info.ModTime()) {
+		return s.entries
+	}
+	entries := map[string]map[string]string{}
+	if b, err := os.ReadFile(p); err == nil {
+		_ = json.Unmarshal(b, &entries)
+	}
+	sidecars[p] = sidecar{info.ModTime(), entries}
+	return entries
+}
+
+func sidecarGet(path, attr string) (string, bool) {
+	sidecarMu.Lock()
+	defer sidecarMu.Unlock()
+	v, ok := loadSidecar(filepath.Join(filepath.Dir(path), SidecarName))[filepath.Base(path)][sidecarKey(attr)]
+	return v, ok
+}
+
+// sidecarSet stores v for path, or removes it when v is empty; a sidecar
+// left empty is deleted.
+func sidecarSet(path, attr, v string) error {
+	sidecarMu.Lock()
+	defer sidecarMu.Unlock()
+	p := filepath.Join(filepath.Dir(path), SidecarName)
+	entries := loadSidecar(p)
+	name, key := filepath.Base(path), sidecarKey(attr)
+	if _, ok := entries[name][key]; !ok && v == "" {
+		return nil
+	}
+	delete(sidecars, p)
+	if v == "" {
+		delete(entries[name], key)
+		if len(entries[name]) == 0 {
+			delete(entries, name)
+		}
+	} else {
+		if entries[name] == nil {
+			entries[name] = map[string]string{}
+		}
+		entries[name][key] = v
+	}
+	if len(entries) == 0 {
+		return os.Remove(p)
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), SidecarName+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
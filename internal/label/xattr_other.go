@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package label
+
+import "errors"
+
+var errNoXattr = errors.New("extended attributes are not supported")
+
+func getXattr(path, attr string) (string, error) { return "", errNoXattr }
+
+func setXattr(path, attr, v string) error { return errNoXattr }
+
+func removeXattr(path, attr string) error { return errNoXattr }
@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package label
+
+import "golang.org/x/sys/unix"
+
+func getXattr(path, attr string) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		n, err := unix.Getxattr(path, attr, buf)
+		if err == unix.ERANGE {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
+
+func setXattr(path, attr, v string) error {
+	return unix.Setxattr(path, attr, []byte(v), 0)
+}
+
+func removeXattr(path, attr string) error {
+	return unix.Removexattr(path, attr)
+}
@@ -0,0 +1,141 @@
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/exif"
+	"github.com/ck-zhang/thumbgrid/internal/index"
+)
+
+// A Field is one labelled line of an item's details.
+type Field = index.Field
+
+// detailed caches Details, as probed does Probe.
+var detailed memo[probeKey, []Field]
+
+// Details describes what the camera recorded for an image (camera, lens,
+// exposure, location) or how a video is encoded (codecs, frame rate,
+// bitrate), leaving out whatever the file doesn't say.
+func Details(path, kind string) []Field {
+	fk, ok := keyOf(path)
+	if !ok {
+		return nil
+	}
+	k := probeKey{fk, kind}
+	if f, ok := detailed.load(k); ok {
+		return f
+	}
+	if r, hit := store.Lookup(path, fk.size, fk.modTime()); hit && r.Detailed {
+		detailed.store(k, r.Details)
+		return r.Details
+	}
+	var f []Field
+	switch kind {
+	case "image":
+		f = imageDetails(path)
+	case "video":
+		f = videoDetails(path)
+	}
+	detailed.store(k, f)
+	store.Update(path, fk.size, fk.modTime(), func(r *index.Record) { r.Detailed, r.Details = true, f })
+	return f
+}
+
+func imageDetails(path string) []Field {
+	d, ok := exif.ReadDetails(path)
+	if !ok {
+		return nil
+	}
+	var f []Field
+	add := func(name, value string) {
+		if value != "" {
+			f = append(f, Field{Name: name, Value: value})
+		}
+	}
+	// Most models already start with the make ("Canon EOS R5").
+	camera := d.Model
+	if d.Make != "" && !strings.HasPrefix(strings.ToLower(d.Model), strings.ToLower(strings.Fields(d.Make)[0])) {
+		camera = strings.TrimSpace(d.Make + " " + d.Model)
+	}
+	add("Camera", camera)
+	add("Lens", d.Lens)
+	if d.ISO > 0 {
+		add("ISO", strconv.Itoa(d.ISO))
+	}
+	switch {
+	case d.Exposure >= 1:
+		add("Shutter", strconv.FormatFloat(d.Exposure, 'f', -1, 64)+"s")
+	case d.Exposure > 0:
+		add("Shutter", fmt.Sprintf("1/%.0fs", 1/d.Exposure))
+	}
+	if d.FNumber > 0 {
+		add("Aperture", "f/"+strconv.FormatFloat(d.FNumber, 'f', -1, 64))
+	}
+	if d.FocalLength > 0 {
+		add("Focal", strconv.FormatFloat(d.FocalLength, 'f', -1, 64)+"mm")
+	}
+	if d.HasGPS {
+		add("GPS", fmt.Sprintf("%.5f, %.5f", d.Lat, d.Lon))
+	}
+	return f
+}
+
+func videoDetails(path string) []Field {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil
+	}
+	out, err := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,avg_frame_rate:format=bit_rate",
+		"-of", "json",
+		path,
+	).Output()
+	if err != nil {
+		return nil
+	}
+	var res struct {
+		Streams []struct {
+			Type  string `json:"codec_type"`
+			Codec string `json:"codec_name"`
+			Rate  string `json:"avg_frame_rate"`
+		} `json:"streams"`
+		Format struct {
+			BitRate string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil
+	}
+	var f []Field
+	var video, audio bool
+	for _, s := range res.Streams {
+		switch {
+		case s.Type == "video" && !video:
+			video = true
+			f = append(f, Field{Name: "Video", Value: s.Codec})
+			num, den, _ := strings.Cut(s.Rate, "/")
+			n, _ := strconv.ParseFloat(num, 64)
+			d, _ := strconv.ParseFloat(den, 64)
+			if n > 0 && d > 0 {
+				f = append(f, Field{Name: "Frame rate", Value: strconv.FormatFloat(math.Round(n/d*100)/100, 'f', -1, 64) + " fps"})
+			}
+		case s.Type == "audio" && !audio:
+			audio = true
+			f = append(f, Field{Name: "Audio", Value: s.Codec})
+		}
+	}
+	if b, err := strconv.ParseFloat(res.Format.BitRate, 64); err == nil && b > 0 {
+		if b >= 1e6 {
+			f = append(f, Field{Name: "Bitrate", Value: fmt.Sprintf("%.1f Mb/s", b/1e6)})
+		} else {
+			f = append(f, Field{Name: "Bitrate", Value: fmt.Sprintf("%.0f kb/s", b/1e3)})
+		}
+	}
+	return f
+}
@@ -0,0 +1,260 @@
+package meta
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/exif"
+	"github.com/ck-zhang/thumbgrid/internal/index"
+)
+
+type Info struct {
+	Width    int
+	Height   int
+	Duration float64
+}
+
+type probeKey struct {
+	fileKey
+	kind string
+}
+
+type probeResult struct {
+	info Info
+	err  error
+}
+
+// probed remembers results while the files keep them: sorting and JSON
+// output both ask, and ffprobe is slow.
+var probed memo[probeKey, probeResult]
+
+// store, when set, keeps probe results across runs too.
+var store *index.Index
+
+// UseIndex has Probe, Details and Duration consult x before probing files
+// and record what they find there.
+func UseIndex(x *index.Index) { store = x }
+
+// A fileKey names a file as it is now, so that what is remembered under it
+// lapses when the file changes.
+type fileKey struct {
+	path  string
+	size  int64
+	mtime int64
+}
+
+func keyOf(path string) (fileKey, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileKey{}, false
+	}
+	return fileKey{path, fi.Size(), fi.ModTime().UnixNano()}, true
+}
+
+// memoSize bounds each memo.
+const memoSize = 1 << 14
+
+// A memo remembers up to memoSize results, forgetting the oldest first.
+type memo[K comparable, V any] struct {
+	mu    sync.Mutex
+	m     map[K]V
+	order []K
+}
+
+func (c *memo[K, V]) load(k K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+
+func (c *memo[K, V]) store(k K, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[K]V)
+	}
+	if _, ok := c.m[k]; !ok {
+		c.order = append(c.order, k)
+		if len(c.order) > memoSize {
+			delete(c.m, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.m[k] = v
+}
+
+func (k fileKey) modTime() time.Time { return time.Unix(0, k.mtime) }
+
+// settled reports whether probing again would go the same way: the file
+// was read, whether or not it made sense, and the tools were there.
+func settled(err error) bool {
+	var pe *fs.PathError
+	return !errors.As(err, &pe) && !errors.Is(err, exec.ErrNotFound)
+}
+
+func Probe(path, kind string) (Info, error) {
+	fk, ok := keyOf(path)
+	if !ok {
+		return probe(path, kind)
+	}
+	k := probeKey{fk, kind}
+	if r, ok := probed.load(k); ok {
+		return r.info, r.err
+	}
+	if r, hit := store.Lookup(path, fk.size, fk.modTime()); hit && r.Probed {
+		info := Info{Width: r.Width, Height: r.Height, Duration: r.Duration}
+		var err error
+		if r.ProbeError != "" {
+			err = errors.New(r.ProbeError)
+		}
+		probed.store(k, probeResult{info, err})
+		return info, err
+	}
+	info, err := probe(path, kind)
+	if !settled(err) {
+		return info, err
+	}
+	probed.store(k, probeResult{info, err})
+	// A file that can't be probed is remembered too, so it isn't tried on
+	// every run.
+	store.Update(path, fk.size, fk.modTime(), func(r *index.Record) {
+		r.Probed, r.Width, r.Height, r.Duration = true, info.Width, info.Height, info.Duration
+		r.ProbeError = ""
+		if err != nil {
+			r.ProbeError = err.Error()
+		}
+	})
+	return info, err
+}
+
+// durations holds lengths learnt through SetDuration.
+var durations memo[fileKey, float64]
+
+// Duration returns a video's length if it is already known, from a probe in
+// this run or one recorded in the index, without running ffprobe.
+func Duration(path string) (float64, bool) {
+	k, ok := keyOf(path)
+	if !ok {
+		return 0, false
+	}
+	if r, ok := probed.load(probeKey{k, "video"}); ok && r.info.Duration > 0 {
+		return r.info.Duration, true
+	}
+	if d, ok := durations.load(k); ok {
+		return d, true
+	}
+	if r, hit := store.Lookup(path, k.size, k.modTime()); hit && r.Duration > 0 {
+		durations.store(k, r.Duration)
+		return r.Duration, true
+	}
+	return 0, false
+}
+
+// SetDuration records a video's length found some other way, for Duration.
+func SetDuration(path string, d float64) {
+	if k, ok := keyOf(path); ok {
+		durations.store(k, d)
+		store.Update(path, k.size, k.modTime(), func(r *index.Record) { r.Duration = d })
+	}
+}
+
+// Durations hands Duration and SetDuration to a thumb.Generator.
+type Durations struct{}
+
+func (Durations) Duration(path string) (float64, bool) { return Duration(path) }
+func (Durations) SetDuration(path string, d float64)   { SetDuration(path, d) }
+
+func probe(path, kind string) (Info, error) {
+	switch kind {
+	case "image":
+		return probeImage(path)
+	case "video":
+		return probeVideo(path)
+	default:
+		return Info{}, fmt.Errorf("unsupported kind: %s", kind)
+	}
+}
+
+func probeImage(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return Info{}, err
+	}
+	if exif.Orientation(path) >= 5 {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	return Info{Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+func probeVideo(path string) (Info, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return Info{}, err
+	}
+	cmd := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, err
+	}
+	var res struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return Info{}, err
+	}
+	var info Info
+	if len(res.Streams) > 0 {
+		info.Width, info.Height = res.Streams[0].Width, res.Streams[0].Height
+	}
+	if d, err := strconv.ParseFloat(res.Format.Duration, 64); err == nil && d > 0 {
+		info.Duration = d
+	}
+	return info, nil
+}
+
+func HumanSize(n int64) string {
+	const (
+		KB = 1024
+		MB = 1024 * KB
+		GB = 1024 * MB
+	)
+	switch {
+	case n >= GB:
+		return fmt.Sprintf("%.1fG", float64(n)/float64(GB))
+	case n >= MB:
+		return fmt.Sprintf("%.1fM", float64(n)/float64(MB))
+	case n >= KB:
+		return fmt.Sprintf("%.1fK", float64(n)/float64(KB))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
@@ -2,114 +2,318 @@ package term
 
 import (
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"golang.org/x/sys/unix"
 	xt "golang.org/x/term"
 )
 
+// Renderer draws images on the terminal. ClearAll and Clear are called with
+// Lock held. Draw appends what shows an image in the given cells to buf for
+// the Scheduler to write, and is called without it.
 type Renderer interface {
 	Name() string
 	ClearAll() error
-	Draw(path string, cellX, cellY, cellW, cellH int) error
+	// Clear removes images drawn in the given cells that text written over
+	// them would not erase.
+	Clear(cellX, cellY, cellW, cellH int) error
+	Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error
 	Close() error
 }
 
 var writeMu sync.Mutex
 
+var (
+	ttyIn  = os.Stdin
+	ttyOut = os.Stdout
+)
+
+func SetTTY(in, out *os.File) {
+	ttyIn, ttyOut = in, out
+}
+
 func Lock()   { writeMu.Lock() }
 func Unlock() { writeMu.Unlock() }
 
+// frame is where output goes between BeginFrame and EndFrame.
+var frame *bytes.Buffer
+
+// BeginFrame sends what renderers write to buf instead of the terminal
+// until EndFrame, so that a frame's text and image commands reach it in one
+// write. Both are called with Lock held.
+func BeginFrame(buf *bytes.Buffer) { frame = buf }
+func EndFrame()                    { frame = nil }
+
+// emit writes s to the frame being drawn, or else to the terminal. It is
+// called with Lock held.
+func emit(s string) error {
+	if frame != nil {
+		frame.WriteString(s)
+		return nil
+	}
+	_, err := io.WriteString(ttyOut, s)
+	return err
+}
+
+// A scroller is a Renderer that keeps track of where its images are, and so
+// must hear when the terminal moves them.
+type scroller interface {
+	scroll(top, bottom, n int) error
+}
+
+// Scroll moves lines top through bottom up by n lines, or down for negative
+// n, with the images on them, leaving blank lines behind. It is called with
+// Lock held.
+func Scroll(r Renderer, top, bottom, n int) error {
+	if s, ok := r.(scroller); ok {
+		if err := s.scroll(top, bottom, n); err != nil {
+			return err
+		}
+	}
+	seq := fmt.Sprintf("\x1b[%dS", n)
+	if n < 0 {
+		seq = fmt.Sprintf("\x1b[%dT", -n)
+	}
+	return emit(fmt.Sprintf("\x1b[%d;%dr%s\x1b[r", top, bottom, seq))
+}
+
+// Scales reports whether r fits whatever image it is given to the cells it
+// is drawn in, trimming transparent margins, so that one square thumbnail
+// serves tiles of any shape and size.
+func Scales(r Renderer) bool {
+	s, ok := r.(interface{ scales() bool })
+	return ok && s.scales()
+}
+
+// ScalesLikely guesses, without asking the terminal, whether the backend
+// pref resolves to scales images as Scales says, for making thumbnails
+// ahead of a grid.
+func ScalesLikely(pref string) bool {
+	switch strings.ToLower(strings.TrimSpace(pref)) {
+	case "kitty":
+	case "auto", "":
+		if os.Getenv("KITTY_WINDOW_ID") == "" && os.Getenv("GHOSTTY_RESOURCES_DIR") == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	return !inTmux()
+}
+
+// Detect resolves a backend preference. An explicit choice is trusted even
+// when the terminal doesn't answer the probe (screen, some multiplexers);
+// auto picks the best protocol the terminal reports.
 func Detect(pref string) (string, error) {
 	p := strings.ToLower(strings.TrimSpace(pref))
 	switch p {
 	case "kitty":
-		if kittyProtocolAvailable(75 * time.Millisecond) {
-			return "kitty", nil
-		}
-		return "", errors.New("kitty graphics protocol not available")
+		// Still probe: it tells whether the terminal can read our files.
+		probeTerminal(75 * time.Millisecond)
+		return "kitty", nil
+	case "sixel", "iterm2", "chafa", "blocks", "braille", "none":
+		return p, nil
 	case "auto", "":
-		if kittyProtocolAvailable(75 * time.Millisecond) {
+		pr := probeTerminal(75 * time.Millisecond)
+		if pr.kitty {
 			return "kitty", nil
 		}
+		if iterm2Likely() {
+			return "iterm2", nil
+		}
+		if pr.sixel() {
+			return "sixel", nil
+		}
+		// GNU screen turns 24-bit color into a muddy 256.
+		screen := os.Getenv("STY") != ""
+		if truecolorLikely() && !screen {
+			return "blocks", nil
+		}
+		// chafa matches whatever colors the terminal has left.
+		if chafaAvailable() && os.Getenv("TERM") != "dumb" {
+			return "chafa", nil
+		}
+		if screen {
+			return "braille", nil
+		}
 		return "none", nil
 	default:
 		return "", errors.New("unknown backend: " + pref)
 	}
 }
 
-func kittyProtocolAvailable(timeout time.Duration) bool {
+type probeResult struct {
+	kitty bool
+	da1   []string
+}
+
+func (p probeResult) sixel() bool {
+	for _, a := range p.da1 {
+		if a == "4" {
+			return true
+		}
+	}
+	return false
+}
+
+func probeTerminal(timeout time.Duration) probeResult {
+	kq := "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\"
+	// A second query hands over a file: a terminal on another machine, or
+	// in another container, can't open it.
+	if f, err := os.CreateTemp("", "thumbgrid-probe"); err == nil {
+		_, _ = f.Write([]byte{0, 0, 0})
+		f.Close()
+		defer os.Remove(f.Name())
+		kq += "\x1b_Gi=32,s=1,v=1,a=q,t=f,f=24;" + base64.StdEncoding.EncodeToString([]byte(f.Name())) + "\x1b\\"
+	}
+	tmux := inTmux()
+	if tmux {
+		enableTmuxPassthrough()
+		kq = tmuxPassthrough(kq)
+	}
+	// tmux answers the synchronized output query itself.
+	resp := queryTerminal(kq+syncQuery+"\x1b[c", timeout, func(b []byte) bool { return da1Params(b) != nil })
+	syncOnce.Do(func() { syncOK = syncReply(resp) })
+	kitty := bytes.Contains(resp, []byte("\x1b_G"))
+	if kitty {
+		noSharedFiles.Store(!bytes.Contains(resp, []byte("\x1b_Gi=32;OK")))
+	}
+	if !kitty && tmux {
+		kitty = os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("GHOSTTY_RESOURCES_DIR") != ""
+	}
+	return probeResult{kitty: kitty, da1: da1Params(resp)}
+}
+
+var noSharedFiles atomic.Bool
+
+// Remote reports whether the terminal seems to run on another machine: the
+// session came in over SSH, or the terminal could not read a file we wrote.
+func Remote() bool { return remoteSession() || noSharedFiles.Load() }
+
+func truecolorLikely() bool {
+	if runtime.GOOS == "windows" {
+		// conhost and Windows Terminal both take 24-bit color.
+		return true
+	}
+	switch os.Getenv("TERM") {
+	case "", "dumb", "linux", "vt100", "vt220":
+		return false
+	}
+	return true
+}
+
+func enableTmuxPassthrough() {
+	_ = exec.Command("tmux", "set", "-p", "allow-passthrough", "on").Run()
+}
+
+// da1Params returns the parameters of the DA1 reply in b, or nil until it
+// has arrived. Other replies starting with CSI ? may come before it, like
+// the DECRPM to syncQuery, and are skipped.
+func da1Params(b []byte) []string {
+	for {
+		i := bytes.Index(b, []byte("\x1b[?"))
+		if i < 0 {
+			return nil
+		}
+		b = b[i+3:]
+		j := 0
+		for j < len(b) && (b[j] >= '0' && b[j] <= '9' || b[j] == ';') {
+			j++
+		}
+		if j < len(b) && b[j] == 'c' {
+			return strings.Split(string(b[:j]), ";")
+		}
+	}
+}
+
+func queryTerminal(query string, timeout time.Duration, done func([]byte) bool) []byte {
 	if timeout <= 0 {
 		timeout = 50 * time.Millisecond
 	}
-	stdin := os.Stdin
-	stdout := os.Stdout
+	stdin := ttyIn
+	stdout := ttyOut
 	if stdin == nil || stdout == nil {
-		return false
+		return nil
 	}
 	fdIn := int(stdin.Fd())
 	fdOut := int(stdout.Fd())
 	if fdIn < 0 || fdOut < 0 {
-		return false
+		return nil
 	}
 	if !xt.IsTerminal(fdIn) || !xt.IsTerminal(fdOut) {
-		return false
+		return nil
 	}
-	query := "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\"
 	if _, err := fmt.Fprint(stdout, query); err != nil {
-		return false
+		return nil
 	}
 	_ = stdout.Sync()
-	oldFlags, err := unix.FcntlInt(uintptr(fdIn), unix.F_GETFL, 0)
-	if err != nil {
-		return false
-	}
-	defer func() {
-		_, _ = unix.FcntlInt(uintptr(fdIn), unix.F_SETFL, oldFlags)
-	}()
-	if err := unix.SetNonblock(fdIn, true); err != nil {
-		return false
-	}
 	deadline := time.Now().Add(timeout)
 	buf := make([]byte, 512)
 	var acc bytes.Buffer
 	for time.Now().Before(deadline) {
-		remaining := int(time.Until(deadline) / time.Millisecond)
-		if remaining <= 0 {
-			remaining = 1
-		}
-		fds := []unix.PollFd{{Fd: int32(fdIn), Events: unix.POLLIN}}
-		_, err := unix.Poll(fds, remaining)
-		if err != nil {
-			return false
-		}
-		if fds[0].Revents&unix.POLLIN == 0 {
-			continue
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			remaining = time.Millisecond
 		}
-		n, err := unix.Read(fdIn, buf)
+		n, err := ReadTimeout(stdin, buf, remaining)
 		if n > 0 {
 			acc.Write(buf[:n])
-			if bytes.Contains(acc.Bytes(), []byte("\x1b_G")) {
-				return true
+			if done(acc.Bytes()) {
+				return acc.Bytes()
 			}
 		}
-		if err != nil && err != unix.EAGAIN {
-			return false
+		if err != nil {
+			return acc.Bytes()
 		}
 	}
-	return false
+	return acc.Bytes()
+}
+
+// CursorPos asks the terminal where the cursor is, 1-based.
+func CursorPos() (row, col int, ok bool) {
+	resp := queryTerminal("\x1b[6n", 200*time.Millisecond, func(b []byte) bool { return bytes.IndexByte(b, 'R') >= 0 })
+	i := bytes.LastIndex(resp, []byte("\x1b["))
+	if i < 0 {
+		return 0, 0, false
+	}
+	rest, _, found := bytes.Cut(resp[i+2:], []byte("R"))
+	if !found {
+		return 0, 0, false
+	}
+	r, c, _ := strings.Cut(string(rest), ";")
+	row, err1 := strconv.Atoi(r)
+	col, err2 := strconv.Atoi(c)
+	if err1 != nil || err2 != nil || row < 1 || col < 1 {
+		return 0, 0, false
+	}
+	return row, col, true
 }
 
 func New(backend string) (Renderer, error) {
 	b := strings.ToLower(backend)
 	switch b {
 	case "kitty":
-		return &kittyRenderer{}, nil
+		return newKittyRenderer(), nil
+	case "sixel":
+		return newSixelRenderer(), nil
+	case "iterm2":
+		return newITerm2Renderer(), nil
+	case "chafa":
+		return newChafaRenderer(), nil
+	case "blocks":
+		return newBlocksRenderer(), nil
+	case "braille":
+		return newBrailleRenderer(), nil
 	case "none":
 		return &noopRenderer{}, nil
 	default:
@@ -119,7 +323,41 @@ func New(backend string) (Renderer, error) {
 
 type noopRenderer struct{}
 
-func (n *noopRenderer) Name() string                          { return "none" }
-func (n *noopRenderer) ClearAll() error                       { return nil }
-func (n *noopRenderer) Draw(string, int, int, int, int) error { return nil }
-func (n *noopRenderer) Close() error                          { return nil }
+func (n *noopRenderer) Name() string                                         { return "none" }
+func (n *noopRenderer) ClearAll() error                                      { return nil }
+func (n *noopRenderer) Clear(int, int, int, int) error                       { return nil }
+func (n *noopRenderer) Draw(*bytes.Buffer, string, int, int, int, int) error { return nil }
+func (n *noopRenderer) Close() error                                         { return nil }
+
+func CellSize() (int, int, bool) {
+	if w, h, ok := CellSizeFromWinsize(); ok {
+		return w, h, true
+	}
+	resp := queryTerminal("\x1b[16t", 75*time.Millisecond, func(b []byte) bool {
+		_, _, ok := parseCellSizeReport(b)
+		return ok
+	})
+	return parseCellSizeReport(resp)
+}
+
+func parseCellSizeReport(b []byte) (int, int, bool) {
+	i := bytes.Index(b, []byte("\x1b[6;"))
+	if i < 0 {
+		return 0, 0, false
+	}
+	rest := b[i+4:]
+	j := bytes.IndexByte(rest, 't')
+	if j < 0 {
+		return 0, 0, false
+	}
+	parts := strings.Split(string(rest[:j]), ";")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	w, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
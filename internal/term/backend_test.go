@@ -0,0 +1,55 @@
+package term
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCellSizeReport(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		w, h  int
+		valid bool
+	}{
+		{"report", "\x1b[6;20;10t", 10, 20, true},
+		{"after other replies", "\x1b[?62;4c\x1b[6;17;8t", 8, 17, true},
+		{"empty", "", 0, 0, false},
+		{"unterminated", "\x1b[6;20;10", 0, 0, false},
+		{"one field", "\x1b[6;20t", 0, 0, false},
+		{"three fields", "\x1b[6;1;20;10t", 0, 0, false},
+		{"zero", "\x1b[6;0;10t", 0, 0, false},
+		{"not a number", "\x1b[6;x;10t", 0, 0, false},
+		{"window size report", "\x1b[4;600;800t", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, ok := parseCellSizeReport([]byte(tt.in))
+			if w != tt.w || h != tt.h || ok != tt.valid {
+				t.Errorf("parseCellSizeReport(%q) = %d, %d, %v, want %d, %d, %v", tt.in, w, h, ok, tt.w, tt.h, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDA1Params(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"sixel", "\x1b[?62;4;22c", []string{"62", "4", "22"}},
+		{"single", "\x1b[?1c", []string{"1"}},
+		{"after a DECRPM", "\x1b[?2026;2$y\x1b[?64;4c", []string{"64", "4"}},
+		{"no reply", "", nil},
+		{"unterminated", "\x1b[?62;4", nil},
+		{"not DA1", "\x1b[6;20;10t", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := da1Params([]byte(tt.in)); !slices.Equal(got, tt.want) {
+				t.Errorf("da1Params(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const blocksCacheMax = 512
+
+// The newest decoded thumbnails are kept apart from the encoded tiles, so a
+// thumbnail drawn at another cell size is sampled again rather than read.
+const (
+	blocksImagesMax   = 64
+	blocksImagesBytes = 32 << 20
+)
+
+type blocksRenderer struct {
+	cache  *lru[[]byte]
+	images *lru[image.Image]
+}
+
+func newBlocksRenderer() *blocksRenderer {
+	return &blocksRenderer{
+		cache: newEncodedCache(blocksCacheMax),
+		images: newLRU(blocksImagesMax, blocksImagesBytes, func(img image.Image) int {
+			return img.Bounds().Dx() * img.Bounds().Dy() * 4
+		}),
+	}
+}
+
+func (r *blocksRenderer) Name() string { return "blocks" }
+
+func (r *blocksRenderer) ClearAll() error { return nil }
+
+func (r *blocksRenderer) Clear(int, int, int, int) error { return nil }
+
+func (r *blocksRenderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellW <= 0 || cellH <= 0 || path == "" {
+		return nil
+	}
+	key := fmt.Sprintf("%s|%dx%d", path, cellW, cellH)
+	data, err := r.cache.get(key, func() ([]byte, error) {
+		img, err := r.images.get(path, func() (image.Image, error) { return imgfile.Decode(path) })
+		if err != nil {
+			return nil, err
+		}
+		return []byte(encodeBlocks(sampleRGBA(img, cellW, cellH*2), cellW, cellH)), nil
+	})
+	if err != nil {
+		return err
+	}
+	for row, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s", cellY+row, cellX, line)
+	}
+	return nil
+}
+
+func (r *blocksRenderer) Close() error { return nil }
+
+func encodeBlocks(px []rgba, cellW, cellH int) string {
+	var b strings.Builder
+	for row := 0; row < cellH; row++ {
+		if row > 0 {
+			b.WriteByte('\n')
+		}
+		for col := 0; col < cellW; col++ {
+			top := px[(row*2)*cellW+col]
+			bot := px[(row*2+1)*cellW+col]
+			topOn, botOn := top.a >= 0x80, bot.a >= 0x80
+			switch {
+			case topOn && botOn:
+				fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top.r, top.g, top.b, bot.r, bot.g, bot.b)
+			case topOn:
+				fmt.Fprintf(&b, "\x1b[49m\x1b[38;2;%d;%d;%dm▀", top.r, top.g, top.b)
+			case botOn:
+				fmt.Fprintf(&b, "\x1b[49m\x1b[38;2;%d;%d;%dm▄", bot.r, bot.g, bot.b)
+			default:
+				b.WriteString("\x1b[49m ")
+			}
+		}
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
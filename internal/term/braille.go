@@ -0,0 +1,142 @@
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const brailleCacheMax = 512
+
+// brailleRenderer draws thumbnails in braille patterns, 2×4 dots to a cell,
+// in the terminal's own foreground color: four times the resolution of half
+// blocks, a fraction of the bytes, and nothing a multiplexer can mangle.
+type brailleRenderer struct {
+	cache  *lru[[]byte]
+	images *lru[image.Image]
+	// dark puts dots on the dark parts, for dark text on a light background.
+	dark bool
+}
+
+func newBrailleRenderer() *brailleRenderer {
+	light, known := LightBackground()
+	return &brailleRenderer{
+		dark:  light && known,
+		cache: newEncodedCache(brailleCacheMax),
+		images: newLRU(blocksImagesMax, blocksImagesBytes, func(img image.Image) int {
+			return img.Bounds().Dx() * img.Bounds().Dy() * 4
+		}),
+	}
+}
+
+func (r *brailleRenderer) Name() string { return "braille" }
+
+func (r *brailleRenderer) ClearAll() error { return nil }
+
+func (r *brailleRenderer) Clear(int, int, int, int) error { return nil }
+
+func (r *brailleRenderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellW <= 0 || cellH <= 0 || path == "" {
+		return nil
+	}
+	key := fmt.Sprintf("%s|%dx%d", path, cellW, cellH)
+	data, err := r.cache.get(key, func() ([]byte, error) {
+		img, err := r.images.get(path, func() (image.Image, error) { return imgfile.Decode(path) })
+		if err != nil {
+			return nil, err
+		}
+		return []byte(encodeBraille(sampleRGBA(img, cellW*2, cellH*4), cellW, cellH, r.dark)), nil
+	})
+	if err != nil {
+		return err
+	}
+	for row, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s", cellY+row, cellX, line)
+	}
+	return nil
+}
+
+func (r *brailleRenderer) Close() error { return nil }
+
+// brailleBits are the dots of a braille cell by column and row.
+var brailleBits = [2][4]rune{{0x01, 0x02, 0x04, 0x40}, {0x08, 0x10, 0x20, 0x80}}
+
+// encodeBraille turns px, (cellW*2)×(cellH*4) samples, into lines of braille
+// with a dot for each light sample, or each dark one when dark is set.
+// Levels are stretched to the image's own range first, so dark and
+// washed-out pictures keep their shapes, and then dithered with
+// Floyd–Steinberg. Transparent samples stay blank.
+func encodeBraille(px []rgba, cellW, cellH int, dark bool) string {
+	w, h := cellW*2, cellH*4
+	lum := make([]float64, len(px))
+	blank := make([]bool, len(px))
+	lo, hi := 1.0, 0.0
+	for i, p := range px {
+		if blank[i] = p.a < 0x80; blank[i] {
+			continue
+		}
+		lum[i] = (0.299*float64(p.r) + 0.587*float64(p.g) + 0.114*float64(p.b)) / 255
+		if dark {
+			lum[i] = 1 - lum[i]
+		}
+		lo, hi = min(lo, lum[i]), max(hi, lum[i])
+	}
+	// A nearly flat picture has no shapes to bring out; its dots go by
+	// plain brightness.
+	if hi-lo < 0.25 {
+		lo, hi = 0, 1
+	}
+	on := make([]bool, len(px))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			if blank[i] {
+				continue
+			}
+			v := (lum[i] - lo) / (hi - lo)
+			on[i] = v >= 0.5
+			e := v - float64(btoi(on[i]))
+			spread := func(dx, dy int, f float64) {
+				if x+dx >= 0 && x+dx < w && y+dy < h && !blank[i+dy*w+dx] {
+					lum[i+dy*w+dx] += e * f * (hi - lo)
+				}
+			}
+			spread(1, 0, 7.0/16)
+			spread(-1, 1, 3.0/16)
+			spread(0, 1, 5.0/16)
+			spread(1, 1, 1.0/16)
+		}
+	}
+	var b strings.Builder
+	for row := 0; row < cellH; row++ {
+		if row > 0 {
+			b.WriteByte('\n')
+		}
+		for col := 0; col < cellW; col++ {
+			var r rune
+			for dx := 0; dx < 2; dx++ {
+				for dy := 0; dy < 4; dy++ {
+					if on[(row*4+dy)*w+col*2+dx] {
+						r |= brailleBits[dx][dy]
+					}
+				}
+			}
+			if r == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteRune(0x2800 + r)
+			}
+		}
+	}
+	return b.String()
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package term
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const chafaCacheMax = 512
+
+// chafaTimeout bounds one run of chafa, which happens while a frame is
+// drawn; a thumbnail it can't finish in time is drawn in half blocks.
+const chafaTimeout = 3 * time.Second
+
+// chafaRenderer draws thumbnails as character art made by chafa, which
+// picks from far more symbols than half blocks and matches the terminal's
+// colors, down to the 16 of a Linux console. A thumbnail chafa can't draw
+// falls back to half blocks.
+type chafaRenderer struct {
+	cache *lru[[]byte]
+}
+
+func newChafaRenderer() *chafaRenderer {
+	return &chafaRenderer{cache: newEncodedCache(chafaCacheMax)}
+}
+
+func chafaAvailable() bool {
+	_, err := exec.LookPath("chafa")
+	return err == nil
+}
+
+func (r *chafaRenderer) Name() string { return "chafa" }
+
+func (r *chafaRenderer) ClearAll() error { return nil }
+
+func (r *chafaRenderer) Clear(int, int, int, int) error { return nil }
+
+func (r *chafaRenderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellW <= 0 || cellH <= 0 || path == "" {
+		return nil
+	}
+	key := fmt.Sprintf("%s|%dx%d", path, cellW, cellH)
+	data, err := r.cache.get(key, func() ([]byte, error) {
+		if out, err := chafaArt(path, cellW, cellH); err == nil {
+			return out, nil
+		}
+		img, err := imgfile.Decode(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(encodeBlocks(sampleRGBA(img, cellW, cellH*2), cellW, cellH)), nil
+	})
+	if err != nil {
+		return err
+	}
+	for row, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s", cellY+row, cellX, line)
+	}
+	return nil
+}
+
+func (r *chafaRenderer) Close() error { return nil }
+
+// chafaArt runs chafa on the image at path and returns cellH lines of art
+// cellW cells wide, each ending with its attributes reset.
+func chafaArt(path string, cellW, cellH int) ([]byte, error) {
+	args := append([]string{
+		"--format", "symbols", "--animate", "off", "--stretch",
+		"--size", strconv.Itoa(cellW) + "x" + strconv.Itoa(cellH),
+	}, chafaQuality(cellW, cellH)...)
+	// chafa may be built without WebP and AVIF loaders, so those thumbnails
+	// go to it as PNG on stdin.
+	in, stdin := path, io.Reader(nil)
+	if !imgfile.IsPNG(path) {
+		data, err := imgfile.PNG(path)
+		if err != nil {
+			return nil, err
+		}
+		in, stdin = "-", bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), chafaTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "chafa", append(args, in)...)
+	cmd.Stdin = stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	// It hides the cursor around the art when it thinks it has the terminal.
+	s := strings.NewReplacer("\x1b[?25l", "", "\x1b[?25h", "", "\r", "").Replace(string(out))
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > cellH {
+		lines = lines[:cellH]
+	}
+	for i := range lines {
+		lines[i] += "\x1b[0m"
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// chafaQuality picks the symbols and effort for a tile of w×h cells. In a
+// small tile each cell carries much of the picture, so every symbol is
+// worth trying, and there are few cells to search; a full-screen preview
+// looks fine in blocks and would take long at full effort.
+func chafaQuality(w, h int) []string {
+	switch n := w * h; {
+	case n <= 400:
+		return []string{"--symbols", "all-wide-ambiguous", "--work", "9"}
+	case n <= 2000:
+		return []string{"--symbols", "block+border+space+diagonal+quad+half", "--work", "6"}
+	}
+	return []string{"--symbols", "block+border+space", "--work", "4"}
+}
@@ -0,0 +1,20 @@
+package term
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// SetClipboard puts text on the system clipboard with OSC 52, which the
+// terminal handles itself, so it works over SSH too. Inside tmux the
+// sequence is passed through to the outer terminal.
+func SetClipboard(text string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\x07"
+	if inTmux() {
+		seq = tmuxPassthrough(seq)
+	}
+	Lock()
+	defer Unlock()
+	_, err := fmt.Fprint(ttyOut, seq)
+	return err
+}
@@ -0,0 +1,81 @@
+package term
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	colorsOnce sync.Once
+	fgColor    color.RGBA
+	bgColor    color.RGBA
+	fgOK, bgOK bool
+)
+
+// DefaultColors asks the terminal for its default foreground and background
+// (OSC 10 and 11); fgOK and bgOK say which it answered. The answer is kept,
+// so the first call must come before anything else reads the terminal.
+func DefaultColors() (fg, bg color.RGBA, fgOK, bgOK bool) {
+	colorsOnce.Do(func() {
+		// DA1, which every terminal answers, marks the end of the replies.
+		resp := queryTerminal("\x1b]10;?\x1b\\\x1b]11;?\x1b\\\x1b[c", 100*time.Millisecond, func(b []byte) bool { return da1Params(b) != nil })
+		fgColor, fgOK = parseOSCColor(resp, "10")
+		bgColor, bgOK = parseOSCColor(resp, "11")
+	})
+	return fgColor, bgColor, fgOK, bgOK
+}
+
+// LightBackground reports whether the terminal has dark text on a light
+// background; known is false when neither the terminal nor COLORFGBG says.
+func LightBackground() (light, known bool) {
+	fg, bg, fgOK, bgOK := DefaultColors()
+	switch {
+	case bgOK:
+		return luma(bg) > 0.5, true
+	case fgOK:
+		return luma(fg) < 0.5, true
+	}
+	// rxvt and Konsole export "fg;bg" as palette indexes.
+	if v := os.Getenv("COLORFGBG"); v != "" {
+		n, err := strconv.Atoi(v[strings.LastIndexByte(v, ';')+1:])
+		if err == nil {
+			return n == 7 || n >= 9 && n <= 15, true
+		}
+	}
+	return false, false
+}
+
+func luma(c color.RGBA) float64 {
+	return (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 255
+}
+
+// parseOSCColor finds the reply to OSC n's query in resp, e.g.
+// "\x1b]11;rgb:ffff/ffff/ffff\x1b\\", whose channels have one to four hex
+// digits each.
+func parseOSCColor(resp []byte, n string) (color.RGBA, bool) {
+	_, rest, ok := bytes.Cut(resp, []byte("\x1b]"+n+";rgb:"))
+	if !ok {
+		return color.RGBA{}, false
+	}
+	if i := bytes.IndexAny(rest, "\x07\x1b"); i >= 0 {
+		rest = rest[:i]
+	}
+	parts := strings.Split(string(rest), "/")
+	if len(parts) != 3 {
+		return color.RGBA{}, false
+	}
+	var ch [3]uint8
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 16, 16)
+		if err != nil || len(p) == 0 || len(p) > 4 {
+			return color.RGBA{}, false
+		}
+		ch[i] = uint8(v * 255 / (1<<(4*len(p)) - 1))
+	}
+	return color.RGBA{ch[0], ch[1], ch[2], 0xff}, true
+}
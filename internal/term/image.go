@@ -0,0 +1,139 @@
+package term
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+// An lru keeps the values most recently asked for, up to max of them and
+// maxBytes in all, so redrawing a tile seen a moment ago reuses the work
+// instead of reading its thumbnail again.
+type lru[V any] struct {
+	mu       sync.Mutex
+	max      int
+	maxBytes int
+	size     func(V) int
+	bytes    int
+	// order runs from the most to the least recently used entry.
+	order *list.List
+	m     map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key  string
+	val  V
+	size int
+}
+
+func newLRU[V any](max, maxBytes int, size func(V) int) *lru[V] {
+	return &lru[V]{max: max, maxBytes: maxBytes, size: size, order: list.New(), m: make(map[string]*list.Element)}
+}
+
+// encodedCacheBytes bounds the memory each renderer keeps encoded images in;
+// a sixel thumbnail can run to hundreds of kilobytes.
+const encodedCacheBytes = 64 << 20
+
+// newEncodedCache holds what a renderer sends for an image.
+func newEncodedCache(max int) *lru[[]byte] {
+	return newLRU(max, encodedCacheBytes, func(b []byte) int { return len(b) })
+}
+
+// get returns key's value, making it with load when it isn't held. Errors
+// aren't kept.
+func (c *lru[V]) get(key string, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if e, ok := c.m[key]; ok {
+		c.order.MoveToFront(e)
+		v := e.Value.(*lruEntry[V]).val
+		c.mu.Unlock()
+		return v, nil
+	}
+	c.mu.Unlock()
+
+	v, err := load()
+	if err != nil {
+		return v, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.m[key]; ok {
+		// Loaded meanwhile by another caller.
+		c.order.MoveToFront(e)
+		return v, nil
+	}
+	n := c.size(v)
+	c.m[key] = c.order.PushFront(&lruEntry[V]{key, v, n})
+	c.bytes += n
+	for c.order.Len() > 1 && (c.order.Len() > c.max || c.bytes > c.maxBytes) {
+		old := c.order.Remove(c.order.Back()).(*lruEntry[V])
+		delete(c.m, old.key)
+		c.bytes -= old.size
+	}
+	return v, nil
+}
+
+// opaqueBounds is the part of img inside its transparent margins: the
+// picture in a letterboxed thumbnail.
+func opaqueBounds(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	empty := func(x0, y0, x1, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	r := b
+	for r.Min.Y < r.Max.Y && empty(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1) {
+		r.Min.Y++
+	}
+	for r.Max.Y > r.Min.Y && empty(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y) {
+		r.Max.Y--
+	}
+	for r.Min.X < r.Max.X && empty(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y) {
+		r.Min.X++
+	}
+	for r.Max.X > r.Min.X && empty(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y) {
+		r.Max.X--
+	}
+	if r.Empty() {
+		return b
+	}
+	return r
+}
+
+func sampleRGBA(img image.Image, w, h int) []rgba {
+	b := img.Bounds()
+	out := make([]rgba, w*h)
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return out
+	}
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += cr
+					g += cg
+					bl += cb
+					a += ca
+					n++
+				}
+			}
+			out[y*w+x] = rgba{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)}
+		}
+	}
+	return out
+}
+
+type rgba struct{ r, g, b, a uint8 }
@@ -0,0 +1,60 @@
+package term
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const iterm2CacheMax = 512
+
+// iterm2Renderer draws with iTerm2's inline image protocol (OSC 1337), which
+// WezTerm, Konsole and Windows Terminal understand too.
+type iterm2Renderer struct {
+	cache *lru[[]byte]
+}
+
+func newITerm2Renderer() *iterm2Renderer {
+	return &iterm2Renderer{cache: newEncodedCache(iterm2CacheMax)}
+}
+
+func (r *iterm2Renderer) Name() string { return "iterm2" }
+
+func (r *iterm2Renderer) ClearAll() error { return nil }
+
+func (r *iterm2Renderer) Clear(int, int, int, int) error { return nil }
+
+func (r *iterm2Renderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellW <= 0 || cellH <= 0 || path == "" {
+		return nil
+	}
+	data, err := r.cache.get(path, func() ([]byte, error) {
+		raw, err := imgfile.PNG(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("size=%d:%s", len(raw), base64.StdEncoding.EncodeToString(raw))), nil
+	})
+	if err != nil {
+		return err
+	}
+	seq := fmt.Sprintf("\x1b]1337;File=inline=1;width=%d;height=%d;preserveAspectRatio=1;doNotMoveCursor=1;%s\a", cellW, cellH, data)
+	if inTmux() {
+		seq = tmuxPassthrough(seq)
+	}
+	fmt.Fprintf(buf, "\x1b[%d;%dH%s", cellY, cellX, seq)
+	return nil
+}
+
+func (r *iterm2Renderer) Close() error { return nil }
+
+func iterm2Likely() bool {
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return true
+	}
+	return os.Getenv("LC_TERMINAL") == "iTerm2"
+}
@@ -1,31 +1,411 @@
 package term
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
+	"image"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
 )
 
-type kittyRenderer struct{}
+const kittyPlaceholder = '\U0010EEEE'
+
+// Row/column diacritics from kitty's rowcolumn-diacritics.txt; index i encodes
+// the value i. Only the row is emitted explicitly, columns are inherited.
+var kittyDiacritics = []rune{
+	0x0305, 0x030D, 0x030E, 0x0310, 0x0312, 0x033D, 0x033E, 0x033F,
+	0x0346, 0x034A, 0x034B, 0x034C, 0x0350, 0x0351, 0x0352, 0x0357,
+	0x035B, 0x0363, 0x0364, 0x0365, 0x0366, 0x0367, 0x0368, 0x0369,
+	0x036A, 0x036B, 0x036C, 0x036D, 0x036E, 0x036F, 0x0483, 0x0484,
+	0x0485, 0x0486, 0x0487, 0x0592, 0x0593, 0x0594, 0x0595, 0x0597,
+	0x0598, 0x0599, 0x059C, 0x059D, 0x059E, 0x059F, 0x05A0, 0x05A1,
+	0x05A8, 0x05A9, 0x05AB, 0x05AC, 0x05AF, 0x05C4, 0x0610, 0x0611,
+	0x0612, 0x0613, 0x0614, 0x0615, 0x0616, 0x0617, 0x0657, 0x0658,
+	0x0659, 0x065A, 0x065B, 0x065D, 0x065E, 0x06D6, 0x06D7, 0x06D8,
+	0x06D9, 0x06DA, 0x06DB, 0x06DC, 0x06DF, 0x06E0, 0x06E1, 0x06E2,
+	0x06E4, 0x06E7, 0x06E8, 0x06EB, 0x06EC, 0x0730, 0x0732, 0x0733,
+	0x0735, 0x0736, 0x073A, 0x073D, 0x073F, 0x0740, 0x0741, 0x0743,
+}
+
+// kittyMaxImages bounds how many transmitted images are kept in the
+// terminal; past it, images with no placement on screen are freed.
+const kittyMaxImages = 512
+
+type kittyRenderer struct {
+	placeholder bool
+	tmux        bool
+	transmit    string
+
+	mu  sync.Mutex
+	ids map[kittyImageKey]uint32
+	// placed is what each cell shows, placeholder images included.
+	placed map[[2]int]kittyPlacement
+	// staged is what the batch being drawn places, and fresh the images it
+	// sends; they are settled by commit once it is written or dropped.
+	// evicted holds the deletes of images freed meanwhile, which the
+	// terminal must get either way.
+	staged  map[[2]int]kittyPlacement
+	fresh   []kittyImageKey
+	evicted strings.Builder
+	nextID  uint32
+	nextPID uint32
+	// crops holds the part of each image worth showing.
+	crops *lru[image.Rectangle]
+}
+
+type kittyImageKey struct {
+	path string
+	w, h int
+}
+
+type kittyPlacement struct {
+	id, pid uint32
+	w       int
+}
+
+func newKittyRenderer() *kittyRenderer {
+	tmux := inTmux()
+	return &kittyRenderer{
+		placeholder: tmux,
+		tmux:        tmux,
+		transmit:    kittyTransmission(),
+		ids:         make(map[kittyImageKey]uint32),
+		placed:      make(map[[2]int]kittyPlacement),
+		staged:      make(map[[2]int]kittyPlacement),
+		crops:       newLRU(kittyMaxImages, kittyMaxImages, func(image.Rectangle) int { return 1 }),
+	}
+}
 
 func (k *kittyRenderer) Name() string { return "kitty" }
 
+// scales needs the size of a cell in pixels to centre images. Placeholder
+// images can't be cropped, so they keep thumbnails made to the tile's shape.
+func (k *kittyRenderer) scales() bool {
+	_, _, ok := CellSizeFromWinsize()
+	return !k.placeholder && ok
+}
+
+// ClearAll removes every placement but keeps the image data, so images that
+// come back into view are placed again without being re-sent. Placeholder
+// images are text and vanish with the screen contents.
 func (k *kittyRenderer) ClearAll() error {
-	_, _ = fmt.Fprint(os.Stdout, "\x1b_Ga=d,q=2;\x1b\\")
-	return nil
+	k.mu.Lock()
+	k.placed = make(map[[2]int]kittyPlacement)
+	k.mu.Unlock()
+	if k.placeholder {
+		return nil
+	}
+	return emit(k.wrap("\x1b_Ga=d,d=a,q=2;\x1b\\"))
 }
 
-func (k *kittyRenderer) Draw(path string, cellX, cellY, cellW, cellH int) error {
+// Clear deletes the placement drawn at the top-left cell of the area.
+// Placeholder images live in the text and go away when it is overwritten.
+func (k *kittyRenderer) Clear(cellX, cellY, cellW, cellH int) error {
+	k.mu.Lock()
+	p, ok := k.placed[[2]int{cellX, cellY}]
+	delete(k.placed, [2]int{cellX, cellY})
+	k.mu.Unlock()
+	if !ok || k.placeholder {
+		return nil
+	}
+	return emit(k.wrap(fmt.Sprintf("\x1b_Ga=d,d=i,i=%d,p=%d,q=2;\x1b\\", p.id, p.pid)))
+}
+
+// scroll follows the placements on lines top..bottom as the terminal moves
+// them up by n lines, deleting those that would leave the region rather than
+// leaving it to the terminal to clip them. Placeholder text scrolls away by
+// itself and is only forgotten.
+func (k *kittyRenderer) scroll(top, bottom, n int) error {
+	var b strings.Builder
+	k.mu.Lock()
+	placed := make(map[[2]int]kittyPlacement, len(k.placed))
+	for cell, p := range k.placed {
+		if y := cell[1]; y >= top && y <= bottom {
+			if y -= n; y < top || y > bottom {
+				if !k.placeholder {
+					b.WriteString(k.wrap(fmt.Sprintf("\x1b_Ga=d,d=i,i=%d,p=%d,q=2;\x1b\\", p.id, p.pid)))
+				}
+				continue
+			}
+			cell[1] = y
+		}
+		placed[cell] = p
+	}
+	k.placed = placed
+	k.mu.Unlock()
+	return emit(b.String())
+}
+
+func (k *kittyRenderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
 	if cellW <= 0 || cellH <= 0 || path == "" {
 		return nil
 	}
-	pb64 := base64.StdEncoding.EncodeToString([]byte(path))
-	cmd := fmt.Sprintf("\x1b[%d;%dH\x1b_Ga=T,t=f,f=100,c=%d,C=1,q=2;%s\x1b\\",
-		cellY, cellX, cellW, pb64)
+	if k.placeholder {
+		return k.drawPlaceholder(buf, path, cellX, cellY, cellW, cellH)
+	}
+	place := fmt.Sprintf("c=%d", cellW)
+	x, y := cellX, cellY
+	if cw, ch, ok := CellSizeFromWinsize(); ok {
+		src, err := k.crops.get(path, func() (image.Rectangle, error) {
+			img, err := imgfile.Decode(path)
+			if err != nil {
+				return image.Rectangle{}, err
+			}
+			return opaqueBounds(img), nil
+		})
+		if err == nil {
+			var dx, dy int
+			place, dx, dy = kittyFit(src, cellW, cellH, cw, ch)
+			x, y = x+dx, y+dy
+		}
+	}
+	var b strings.Builder
+	cell := [2]int{cellX, cellY}
+	k.mu.Lock()
+	key := kittyImageKey{path: path}
+	id, sent := k.ids[key]
+	if !sent {
+		id = k.newID(&b, key)
+		if err := k.send(&b, fmt.Sprintf("a=t,i=%d", id), path); err != nil {
+			delete(k.ids, key)
+			k.mu.Unlock()
+			return err
+		}
+		k.fresh = append(k.fresh, key)
+	}
+	old, had := k.staged[cell]
+	if !had {
+		old, had = k.placed[cell]
+	}
+	if had && old.id == id && old.w == cellW {
+		k.mu.Unlock()
+		return nil
+	}
+	if had {
+		b.WriteString(k.wrap(fmt.Sprintf("\x1b_Ga=d,d=i,i=%d,p=%d,q=2;\x1b\\", old.id, old.pid)))
+	}
+	// A placement can move to another cell when the screen scrolls, so
+	// placement ids are never reused.
+	k.nextPID++
+	pid := k.nextPID
+	k.staged[cell] = kittyPlacement{id: id, pid: pid, w: cellW}
+	k.mu.Unlock()
+	fmt.Fprintf(&b, "\x1b[%d;%dH", y, x)
+	b.WriteString(k.wrap(fmt.Sprintf("\x1b_Ga=p,i=%d,p=%d,%s,C=1,q=2;\x1b\\", id, pid, place)))
+	buf.WriteString(b.String())
+	return nil
+}
+
+// commit settles what the batch just drawn staged: kept, its placements
+// hold; dropped, the images it sent never reached the terminal, and only the
+// deletes of those it freed are returned to be written.
+func (k *kittyRenderer) commit(keep bool) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	evicted := k.evicted.String()
+	if keep {
+		for cell, p := range k.staged {
+			k.placed[cell] = p
+		}
+		evicted = ""
+	} else {
+		for _, key := range k.fresh {
+			delete(k.ids, key)
+		}
+	}
+	clear(k.staged)
+	k.fresh = k.fresh[:0]
+	k.evicted.Reset()
+	return evicted
+}
+
+// kittyFit shows src, a part of an image, as large as fits in cellW×cellH
+// cells of cw×ch pixels and centred there. It returns the placement keys and
+// the cell to place from, relative to the area's top-left.
+func kittyFit(src image.Rectangle, cellW, cellH, cw, ch int) (string, int, int) {
+	boxW, boxH := cellW*cw, cellH*ch
+	sw, sh := src.Dx(), src.Dy()
+	keys := fmt.Sprintf("x=%d,y=%d,w=%d,h=%d", src.Min.X, src.Min.Y, sw, sh)
+	if sw*boxH >= sh*boxW {
+		// Full width; the terminal works out the height.
+		off := (boxH - sh*boxW/sw) / 2
+		return fmt.Sprintf("%s,c=%d,Y=%d", keys, cellW, off%ch), 0, off / ch
+	}
+	off := (boxW - sw*boxH/sh) / 2
+	return fmt.Sprintf("%s,r=%d,X=%d", keys, cellH, off%cw), off / cw, 0
+}
+
+// newID assigns an id to key, first freeing images that are not on screen
+// if the terminal holds too many. Called with k.mu held.
+func (k *kittyRenderer) newID(b *strings.Builder, key kittyImageKey) uint32 {
+	if len(k.ids) >= kittyMaxImages {
+		shown := make(map[uint32]bool, len(k.placed)+len(k.staged))
+		for _, p := range k.placed {
+			shown[p.id] = true
+		}
+		for _, p := range k.staged {
+			shown[p.id] = true
+		}
+		for kk, id := range k.ids {
+			if !shown[id] {
+				delete(k.ids, kk)
+				del := k.wrap(fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2;\x1b\\", id))
+				b.WriteString(del)
+				k.evicted.WriteString(del)
+			}
+		}
+	}
+	k.nextID++
+	if k.nextID >= 1<<24 {
+		k.nextID = 1
+	}
+	k.ids[key] = k.nextID
+	return k.nextID
+}
+
+func (k *kittyRenderer) drawPlaceholder(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellH > len(kittyDiacritics) {
+		cellH = len(kittyDiacritics)
+	}
+	key := kittyImageKey{path: path, w: cellW, h: cellH}
+	var b strings.Builder
+	k.mu.Lock()
+	id, ok := k.ids[key]
+	if !ok {
+		id = k.newID(&b, key)
+		if err := k.send(&b, fmt.Sprintf("a=T,U=1,i=%d,c=%d,r=%d", id, cellW, cellH), path); err != nil {
+			delete(k.ids, key)
+			k.mu.Unlock()
+			return err
+		}
+		k.fresh = append(k.fresh, key)
+	}
+	k.staged[[2]int{cellX, cellY}] = kittyPlacement{id: id, w: cellW}
+	k.mu.Unlock()
+
+	fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm", (id>>16)&0xff, (id>>8)&0xff, id&0xff)
+	for r := 0; r < cellH; r++ {
+		fmt.Fprintf(&b, "\x1b[%d;%dH", cellY+r, cellX)
+		b.WriteRune(kittyPlaceholder)
+		b.WriteRune(kittyDiacritics[r])
+		b.WriteRune(kittyDiacritics[0])
+		for c := 1; c < cellW; c++ {
+			b.WriteRune(kittyPlaceholder)
+		}
+	}
+	b.WriteString("\x1b[39m")
+	buf.WriteString(b.String())
+	return nil
+}
+
+// kittyTransmission picks how image data reaches the terminal: "file" hands
+// over the path (t=f), "shm" copies it into POSIX shared memory (t=s) and
+// "direct" sends it inline (t=d). A terminal on another host can't open our
+// files, so remote sessions default to direct.
+func kittyTransmission() string {
+	switch v := strings.ToLower(os.Getenv("THUMBGRID_KITTY_TRANSMIT")); v {
+	case "file", "shm", "direct":
+		return v
+	}
+	if Remote() {
+		return "direct"
+	}
+	return "file"
+}
+
+func remoteSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" || os.Getenv("SSH_CLIENT") != ""
+}
+
+const kittyChunk = 4096
+
+// send appends the commands transmitting the thumbnail at path with the
+// given control keys. PNG is already deflated, so direct mode sends it as is
+// (f=100) rather than raw pixels. Kitty reads no other format, so WebP and
+// AVIF thumbnails are converted and can't be handed over as files.
+func (k *kittyRenderer) send(b *strings.Builder, keys, path string) error {
+	transmit := k.transmit
+	if transmit == "file" && !imgfile.IsPNG(path) {
+		transmit = "direct"
+	}
+	switch transmit {
+	case "shm":
+		name, n, err := kittyShm(path)
+		if err == nil {
+			b.WriteString(k.wrap(fmt.Sprintf("\x1b_G%s,t=s,f=100,S=%d,q=2;%s\x1b\\", keys, n, base64.StdEncoding.EncodeToString([]byte(name)))))
+			return nil
+		}
+		fallthrough
+	case "direct":
+		data, err := imgfile.PNG(path)
+		if err != nil {
+			return err
+		}
+		enc := base64.StdEncoding.EncodeToString(data)
+		for first := true; first || enc != ""; first = false {
+			chunk := enc[:min(len(enc), kittyChunk)]
+			enc = enc[len(chunk):]
+			more := 0
+			if enc != "" {
+				more = 1
+			}
+			if first {
+				b.WriteString(k.wrap(fmt.Sprintf("\x1b_G%s,t=d,f=100,m=%d,q=2;%s\x1b\\", keys, more, chunk)))
+			} else {
+				b.WriteString(k.wrap(fmt.Sprintf("\x1b_Gm=%d,q=2;%s\x1b\\", more, chunk)))
+			}
+		}
+		return nil
+	}
+	b.WriteString(k.wrap(fmt.Sprintf("\x1b_G%s,t=f,f=100,q=2;%s\x1b\\", keys, base64.StdEncoding.EncodeToString([]byte(path)))))
+	return nil
+}
+
+var kittyShmSeq atomic.Uint64
+
+// kittyShm copies path into a new shared memory object and returns its
+// name; the terminal unlinks it once read.
+func kittyShm(path string) (string, int, error) {
+	data, err := imgfile.PNG(path)
+	if err != nil {
+		return "", 0, err
+	}
+	name := fmt.Sprintf("/thumbgrid-%d-%d", os.Getpid(), kittyShmSeq.Add(1))
+	if err := os.WriteFile("/dev/shm"+name, data, 0o600); err != nil {
+		return "", 0, err
+	}
+	return name, len(data), nil
+}
+
+func (k *kittyRenderer) wrap(seq string) string {
+	if !k.tmux {
+		return seq
+	}
+	return tmuxPassthrough(seq)
+}
+
+// Close frees every image this renderer transmitted.
+func (k *kittyRenderer) Close() error {
+	var b strings.Builder
+	k.mu.Lock()
+	for _, id := range k.ids {
+		b.WriteString(k.wrap(fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2;\x1b\\", id)))
+	}
+	k.ids = make(map[kittyImageKey]uint32)
+	k.mu.Unlock()
 	Lock()
 	defer Unlock()
-	_, err := fmt.Fprint(os.Stdout, cmd)
+	_, err := fmt.Fprint(ttyOut, b.String())
 	return err
 }
 
-func (k *kittyRenderer) Close() error { return nil }
+func inTmux() bool { return os.Getenv("TMUX") != "" }
+
+func tmuxPassthrough(seq string) string {
+	return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+}
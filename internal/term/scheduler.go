@@ -1,6 +1,9 @@
 package term
 
-import "sync/atomic"
+import (
+	"bytes"
+	"sync/atomic"
+)
 
 type DrawReq struct {
 	Path       string
@@ -14,6 +17,8 @@ type Scheduler struct {
 	queue chan DrawReq
 	quit  chan struct{}
 	gen   atomic.Uint64
+	// pending counts the draws queued and not yet made.
+	pending atomic.Int64
 }
 
 func NewScheduler(r Renderer, buf int) *Scheduler {
@@ -30,41 +35,103 @@ func NewScheduler(r Renderer, buf int) *Scheduler {
 	return s
 }
 
+// loop draws what is queued. Draws waiting together go out as one
+// synchronized update where the terminal takes them, so a screenful of
+// thumbnails appears at once rather than tile by tile.
 func (s *Scheduler) loop() {
 	for {
 		select {
 		case req := <-s.queue:
-			if req.done != nil {
-				close(req.done)
-				continue
-			}
-
-			if req.gen != s.gen.Load() {
-				continue
-			}
-			if s.r != nil {
-				_ = s.r.Draw(req.Path, req.X, req.Y, req.W, req.H)
+			batch := []DrawReq{req}
+			for len(batch) < cap(s.queue) && len(s.queue) > 0 {
+				batch = append(batch, <-s.queue)
 			}
+			s.draw(batch)
 		case <-s.quit:
 			return
 		}
 	}
 }
 
-func (s *Scheduler) Enqueue(path string, x, y, w, h int) {
+// draw encodes the whole batch before writing any of it, in one write under
+// Lock, so that the terminal isn't left holding a synchronized update open
+// while images are decoded and nothing else's output lands inside it. A
+// batch that a new frame overtook while it was encoded is dropped whole.
+// Drain returns once what was queued before it is written.
+func (s *Scheduler) draw(batch []DrawReq) {
+	var buf bytes.Buffer
+	var drained []chan struct{}
+	n := 0
+	gen := s.gen.Load()
+	for _, req := range batch {
+		if req.done != nil {
+			drained = append(drained, req.done)
+			continue
+		}
+		if req.gen == gen && s.r != nil {
+			_ = s.r.Draw(&buf, req.Path, req.X, req.Y, req.W, req.H)
+		}
+		n++
+	}
+	Lock()
+	keep := s.gen.Load() == gen
+	out := ""
+	if keep {
+		out = buf.String()
+	}
+	if st, ok := s.r.(stager); ok {
+		out += st.commit(keep)
+	}
+	if out != "" {
+		if n > 1 && SyncOutput() {
+			_ = emit(SyncBegin + out + SyncEnd)
+		} else {
+			_ = emit(out)
+		}
+	}
+	Unlock()
+	s.pending.Add(int64(-n))
+	for _, done := range drained {
+		close(done)
+	}
+}
+
+// A stager is a Renderer that keeps track of what its draws put on screen.
+// What Draw records only holds once its output is written, so the scheduler
+// settles it with commit, under Lock, and writes what commit returns too:
+// keep is false when the batch is dropped.
+type stager interface {
+	commit(keep bool) string
+}
+
+// Enqueue queues an image draw; it reports false when the queue is full and
+// the draw was dropped.
+func (s *Scheduler) Enqueue(path string, x, y, w, h int) bool {
 	g := s.gen.Load()
+	s.pending.Add(1)
 	select {
 	case s.queue <- DrawReq{Path: path, X: x, Y: y, W: w, H: h, gen: g}:
+		return true
 	default:
+		s.pending.Add(-1)
+		return false
 	}
 }
 
+// Idle reports whether every queued draw has been made, so that nothing
+// still on its way lands where the screen no longer expects it.
+func (s *Scheduler) Idle() bool {
+	return s.pending.Load() == 0
+}
+
 func (s *Scheduler) Drain() {
 	done := make(chan struct{})
 	s.queue <- DrawReq{done: done, gen: s.gen.Load()}
 	<-done
 }
 
+// NextFrame drops the draws queued so far, and those being encoded, since
+// the screen they were meant for is gone.
 func (s *Scheduler) NextFrame() {
 	s.gen.Add(1)
 }
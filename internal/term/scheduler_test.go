@@ -0,0 +1,132 @@
+package term
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// blockingRenderer holds each Draw until release is closed, so a test can
+// start a new frame while a batch is being encoded.
+type blockingRenderer struct {
+	started chan string
+	release chan struct{}
+
+	mu      sync.Mutex
+	commits []bool
+}
+
+func (r *blockingRenderer) Name() string               { return "test" }
+func (r *blockingRenderer) ClearAll() error            { return nil }
+func (r *blockingRenderer) Clear(x, y, w, h int) error { return nil }
+func (r *blockingRenderer) Close() error               { return nil }
+
+func (r *blockingRenderer) Draw(buf *bytes.Buffer, path string, x, y, w, h int) error {
+	r.started <- path
+	<-r.release
+	buf.WriteString("draw " + path + ";")
+	return nil
+}
+
+func (r *blockingRenderer) commit(keep bool) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commits = append(r.commits, keep)
+	if keep {
+		return ""
+	}
+	return "undo;"
+}
+
+// useTTY points the terminal at a file for the length of the test and
+// returns a function that reads what was written to it.
+func useTTY(t *testing.T) func() string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "tty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldIn, oldOut := ttyIn, ttyOut
+	SetTTY(f, f)
+	t.Cleanup(func() {
+		SetTTY(oldIn, oldOut)
+		f.Close()
+	})
+	return func() string {
+		b, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+func TestSchedulerDropsOvertakenBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		// nextFrame starts a new frame while the first draw is encoded.
+		nextFrame bool
+		want      string
+		wantKeep  bool
+	}{
+		{name: "current", want: "draw a;", wantKeep: true},
+		{name: "overtaken", nextFrame: true, want: "undo;", wantKeep: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := useTTY(t)
+			r := &blockingRenderer{started: make(chan string), release: make(chan struct{})}
+			s := NewScheduler(r, 8)
+			defer s.Close()
+
+			if !s.Enqueue("a", 0, 0, 1, 1) {
+				t.Fatal("Enqueue dropped the draw")
+			}
+			<-r.started
+			if tt.nextFrame {
+				s.NextFrame()
+			}
+			close(r.release)
+			s.Drain()
+
+			if got := written(); got != tt.want {
+				t.Errorf("wrote %q, want %q", got, tt.want)
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if len(r.commits) == 0 || r.commits[0] != tt.wantKeep {
+				t.Errorf("commits = %v, want the first to be %v", r.commits, tt.wantKeep)
+			}
+			if !s.Idle() {
+				t.Error("Idle is false after Drain")
+			}
+		})
+	}
+}
+
+// A draw queued before a new frame is never encoded at all.
+func TestSchedulerSkipsStaleQueued(t *testing.T) {
+	written := useTTY(t)
+	r := &blockingRenderer{started: make(chan string), release: make(chan struct{})}
+	s := NewScheduler(r, 8)
+	defer s.Close()
+
+	s.Enqueue("a", 0, 0, 1, 1)
+	<-r.started
+	s.Enqueue("b", 0, 0, 1, 1)
+	s.NextFrame()
+	close(r.release)
+	go func() {
+		for p := range r.started {
+			t.Errorf("stale draw %q was encoded", p)
+		}
+	}()
+	s.Drain()
+	close(r.started)
+
+	if got := written(); got != "undo;" {
+		t.Errorf("wrote %q, want %q", got, "undo;")
+	}
+}
@@ -0,0 +1,135 @@
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"strconv"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const sixelCacheMax = 512
+
+type sixelRenderer struct {
+	cache *lru[[]byte]
+}
+
+func newSixelRenderer() *sixelRenderer {
+	return &sixelRenderer{cache: newEncodedCache(sixelCacheMax)}
+}
+
+func (s *sixelRenderer) Name() string { return "sixel" }
+
+func (s *sixelRenderer) ClearAll() error { return nil }
+
+func (s *sixelRenderer) Clear(int, int, int, int) error { return nil }
+
+func (s *sixelRenderer) Draw(buf *bytes.Buffer, path string, cellX, cellY, cellW, cellH int) error {
+	if cellW <= 0 || cellH <= 0 || path == "" {
+		return nil
+	}
+	data, err := s.encoded(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "\x1b[%d;%dH", cellY, cellX)
+	buf.Write(data)
+	return nil
+}
+
+func (s *sixelRenderer) Close() error { return nil }
+
+func (s *sixelRenderer) encoded(path string) ([]byte, error) {
+	return s.cache.get(path, func() ([]byte, error) {
+		img, err := imgfile.Decode(path)
+		if err != nil {
+			return nil, err
+		}
+		return encodeSixel(img), nil
+	})
+}
+
+func encodeSixel(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	pal := image.NewPaletted(image.Rect(0, 0, w, h), palette.WebSafe)
+	draw.FloydSteinberg.Draw(pal, pal.Bounds(), img, b.Min)
+
+	opaque := make([]bool, w*h)
+	var used [256]bool
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			_, _, _, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			if a >= 0x8000 {
+				opaque[y*w+x] = true
+				used[pal.ColorIndexAt(x, y)] = true
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1bP0;1;0q")
+	fmt.Fprintf(&buf, "\"1;1;%d;%d", w, h)
+	for i, c := range palette.WebSafe {
+		if !used[i] {
+			continue
+		}
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(&buf, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	row := make([]byte, w)
+	for y0 := 0; y0 < h; y0 += 6 {
+		var band [256]bool
+		for dy := 0; dy < 6 && y0+dy < h; dy++ {
+			for x := 0; x < w; x++ {
+				if opaque[(y0+dy)*w+x] {
+					band[pal.ColorIndexAt(x, y0+dy)] = true
+				}
+			}
+		}
+		for idx := 0; idx < len(band); idx++ {
+			if !band[idx] {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && y0+dy < h; dy++ {
+					y := y0 + dy
+					if opaque[y*w+x] && int(pal.ColorIndexAt(x, y)) == idx {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = byte(63 + bits)
+			}
+			buf.WriteByte('#')
+			buf.WriteString(strconv.Itoa(idx))
+			writeSixelRLE(&buf, row)
+			buf.WriteByte('$')
+		}
+		buf.WriteByte('-')
+	}
+	buf.WriteString("\x1b\\")
+	return buf.Bytes()
+}
+
+func writeSixelRLE(buf *bytes.Buffer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i + 1
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		n := j - i
+		if n > 3 {
+			fmt.Fprintf(buf, "!%d%c", n, row[i])
+		} else {
+			for k := 0; k < n; k++ {
+				buf.WriteByte(row[i])
+			}
+		}
+		i = j
+	}
+}
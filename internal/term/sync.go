@@ -0,0 +1,42 @@
+package term
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// SyncBegin and SyncEnd bracket a synchronized update (DEC mode 2026): the
+// terminal holds the screen as it was until the update is complete.
+const (
+	SyncBegin = "\x1b[?2026h"
+	SyncEnd   = "\x1b[?2026l"
+)
+
+// syncQuery asks whether mode 2026 is known (DECRQM).
+const syncQuery = "\x1b[?2026$p"
+
+var (
+	syncOnce sync.Once
+	syncOK   bool
+)
+
+// SyncOutput reports whether the terminal takes synchronized updates,
+// asking it the first time unless Detect already did.
+func SyncOutput() bool {
+	syncOnce.Do(func() {
+		resp := queryTerminal(syncQuery+"\x1b[c", 75*time.Millisecond, func(b []byte) bool { return da1Params(b) != nil })
+		syncOK = syncReply(resp)
+	})
+	return syncOK
+}
+
+// syncReply reads the answer to syncQuery: 1 or 2 (set or reset) and 3
+// (always set) mean the terminal knows the mode, 0 and 4 that it doesn't.
+func syncReply(resp []byte) bool {
+	_, rest, ok := bytes.Cut(resp, []byte("\x1b[?2026;"))
+	if !ok || len(rest) < 3 || string(rest[1:3]) != "$y" {
+		return false
+	}
+	return rest[0] >= '1' && rest[0] <= '3'
+}
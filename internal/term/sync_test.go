@@ -0,0 +1,24 @@
+package term
+
+import "testing"
+
+func TestSyncReply(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"\x1b[?2026;1$y\x1b[?62c", true},
+		{"\x1b[?2026;2$y", true},
+		{"\x1b[?2026;3$y", true},
+		{"\x1b[?2026;0$y", false},
+		{"\x1b[?2026;4$y", false},
+		{"\x1b[?62;4c", false},
+		{"\x1b[?2026;2", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := syncReply([]byte(tt.in)); got != tt.want {
+			t.Errorf("syncReply(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
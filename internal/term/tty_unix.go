@@ -0,0 +1,87 @@
+//go:build !windows
+
+package term
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func OpenTTY() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// OpenTTYOut opens the terminal for drawing on when stdout goes elsewhere.
+func OpenTTYOut() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+}
+
+// EnableVT prepares out for escape sequences; terminals on unix already
+// understand them.
+func EnableVT(out *os.File) (restore func(), err error) {
+	return func() {}, nil
+}
+
+// ReadTimeout reads whatever input arrives on f within timeout. It returns
+// 0 and a nil error when nothing came.
+func ReadTimeout(f *os.File, buf []byte, timeout time.Duration) (int, error) {
+	fd := int(f.Fd())
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+	if err == unix.EINTR {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 || fds[0].Revents&(unix.POLLIN|unix.POLLHUP|unix.POLLERR) == 0 {
+		return 0, nil
+	}
+	m, err := unix.Read(fd, buf)
+	if m > 0 {
+		return m, nil
+	}
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return 0, nil
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return 0, err
+}
+
+// WatchResize signals on the returned channel whenever the terminal on out
+// changes size.
+func WatchResize(out *os.File) (<-chan struct{}, func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGWINCH)
+	ch := make(chan struct{}, 1)
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case <-sig:
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, func() { signal.Stop(sig); close(quit) }
+}
+
+func CellSizeFromWinsize() (int, int, bool) {
+	ws, err := unix.IoctlGetWinsize(int(ttyOut.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 || ws.Xpixel == 0 || ws.Ypixel == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Xpixel) / int(ws.Col), int(ws.Ypixel) / int(ws.Row), true
+}
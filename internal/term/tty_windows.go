@@ -0,0 +1,126 @@
+//go:build windows
+
+package term
+
+import (
+	"os"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	xt "golang.org/x/term"
+)
+
+var procReadConsoleInput = windows.NewLazySystemDLL("kernel32.dll").NewProc("ReadConsoleInputW")
+
+func OpenTTY() (*os.File, error) {
+	return os.OpenFile("CONIN$", os.O_RDWR, 0)
+}
+
+// OpenTTYOut opens the console for drawing on when stdout goes elsewhere.
+func OpenTTYOut() (*os.File, error) {
+	return os.OpenFile("CONOUT$", os.O_RDWR, 0)
+}
+
+// EnableVT turns on escape sequence processing for the console behind out.
+// Windows Terminal has it on already; conhost needs asking.
+func EnableVT(out *os.File) (restore func(), err error) {
+	h := windows.Handle(out.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING|windows.ENABLE_PROCESSED_OUTPUT); err != nil {
+		return nil, err
+	}
+	return func() { _ = windows.SetConsoleMode(h, mode) }, nil
+}
+
+type keyEventRecord struct {
+	eventType   uint16
+	_           uint16
+	keyDown     int32
+	repeatCount uint16
+	virtualKey  uint16
+	scanCode    uint16
+	unicodeChar uint16
+	controlKeys uint32
+}
+
+const keyEvent = 0x0001
+
+// ReadTimeout reads whatever input arrives on f within timeout. It returns
+// 0 and a nil error when nothing came.
+//
+// Console input is read as records rather than bytes: a plain read blocks
+// until a key arrives even when the wait was woken by a focus or mouse
+// record. With virtual terminal input on, keys come as the same escape
+// sequences a unix terminal would send.
+func ReadTimeout(f *os.File, buf []byte, timeout time.Duration) (int, error) {
+	h := windows.Handle(f.Fd())
+	ev, err := windows.WaitForSingleObject(h, uint32(timeout/time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if ev != windows.WAIT_OBJECT_0 {
+		return 0, nil
+	}
+	recs := make([]keyEventRecord, max(len(buf)/utf8.UTFMax, 1))
+	var got uint32
+	r, _, err := procReadConsoleInput.Call(uintptr(h), uintptr(unsafe.Pointer(&recs[0])), uintptr(len(recs)), uintptr(unsafe.Pointer(&got)))
+	if r == 0 {
+		return 0, err
+	}
+	var units []uint16
+	for _, rec := range recs[:got] {
+		if rec.eventType != keyEvent || rec.keyDown == 0 || rec.unicodeChar == 0 {
+			continue
+		}
+		for i := 0; i < max(int(rec.repeatCount), 1); i++ {
+			units = append(units, rec.unicodeChar)
+		}
+	}
+	n := 0
+	for _, c := range utf16.Decode(units) {
+		if n+utf8.RuneLen(c) > len(buf) {
+			break
+		}
+		n += utf8.EncodeRune(buf[n:], c)
+	}
+	return n, nil
+}
+
+// WatchResize signals on the returned channel whenever the console on out
+// changes size. Windows has no SIGWINCH, so the size is polled.
+func WatchResize(out *os.File) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	quit := make(chan struct{})
+	go func() {
+		t := time.NewTicker(250 * time.Millisecond)
+		defer t.Stop()
+		w, h, _ := xt.GetSize(int(out.Fd()))
+		for {
+			select {
+			case <-quit:
+				return
+			case <-t.C:
+			}
+			w2, h2, _ := xt.GetSize(int(out.Fd()))
+			if w2 == w && h2 == h {
+				continue
+			}
+			w, h = w2, h2
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, func() { close(quit) }
+}
+
+// CellSizeFromWinsize has no console equivalent; CellSize falls back to
+// asking the terminal.
+func CellSizeFromWinsize() (int, int, bool) { return 0, 0, false }
@@ -0,0 +1,108 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+var ErrUnsupported = errors.New("trash is not supported on " + runtime.GOOS)
+
+// Move puts path in the user's trash. On Linux and the BSDs it follows the
+// freedesktop.org trash spec for the home trash; files on another device
+// are handed to gio, which knows about per-volume trash directories.
+func Move(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(abs); err != nil {
+		return err
+	}
+	switch runtime.GOOS {
+	case "windows", "android", "ios", "plan9":
+		return ErrUnsupported
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		return os.Rename(abs, uniqueName(filepath.Join(home, ".Trash"), filepath.Base(abs)))
+	}
+	err = moveXDG(abs)
+	if err != nil && !os.IsNotExist(err) {
+		if gio, lerr := exec.LookPath("gio"); lerr == nil {
+			if out, gerr := exec.Command(gio, "trash", "--", abs).CombinedOutput(); gerr != nil {
+				return fmt.Errorf("gio trash: %v: %s", gerr, out)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+func homeTrash() string {
+	if x := os.Getenv("XDG_DATA_HOME"); x != "" {
+		return filepath.Join(x, "Trash")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "Trash")
+}
+
+// moveXDG reserves a name by creating its .trashinfo exclusively, as the
+// spec asks, then renames the file into place.
+func moveXDG(abs string) error {
+	dir := homeTrash()
+	files, infos := filepath.Join(dir, "files"), filepath.Join(dir, "info")
+	if err := os.MkdirAll(files, 0o700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infos, 0o700); err != nil {
+		return err
+	}
+	base := filepath.Base(abs)
+	info := "[Trash Info]\nPath=" + (&url.URL{Path: abs}).EscapedPath() +
+		"\nDeletionDate=" + time.Now().Format("2006-01-02T15:04:05") + "\n"
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			name = base + "." + strconv.Itoa(i)
+		}
+		infoPath := filepath.Join(infos, name+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(info)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(abs, filepath.Join(files, name))
+		}
+		if err != nil {
+			os.Remove(infoPath)
+		}
+		return err
+	}
+}
+
+func uniqueName(dir, base string) string {
+	p := filepath.Join(dir, base)
+	ext := filepath.Ext(base)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p
+		}
+		p = filepath.Join(dir, fmt.Sprintf("%s %d%s", base[:len(base)-len(ext)], i, ext))
+	}
+}
@@ -0,0 +1,335 @@
+package picker
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/label"
+)
+
+// inRange reports whether idx is in the visual range.
+func (p *picker) inRange(idx int) bool {
+	return p.visual && idx >= min(p.anchor, p.cur) && idx <= max(p.anchor, p.cur)
+}
+
+// isSelected reports whether idx is marked or in the visual range.
+func (p *picker) isSelected(idx int) bool {
+	return p.selected[p.cands[idx].Path] || p.inRange(idx)
+}
+
+// selectRange marks the candidates from a to b.
+func (p *picker) selectRange(a, b int) {
+	for i := min(a, b); i <= max(a, b) && i < len(p.cands); i++ {
+		p.selected[p.cands[i].Path] = true
+	}
+}
+
+// matchCount counts the highlighted matches, once per search and
+// candidate list.
+func (p *picker) matchCount() int {
+	if k := (matchKey{p.highlight, p.rowGen}); k != p.matchesFor {
+		p.matchesFor, p.nMatches = k, 0
+		for _, c := range p.cands {
+			if nameMatches(c, p.highlight) {
+				p.nMatches++
+			}
+		}
+	}
+	return p.nMatches
+}
+
+// selectRect selects the tiles in the rows and columns between a and b.
+func (p *picker) selectRect(a, b int) {
+	t := p.rowTable()
+	ra, rb := p.rowOf(a), p.rowOf(b)
+	ca, cb := a-t[ra], b-t[rb]
+	for r := min(ra, rb); r <= max(ra, rb); r++ {
+		for c := min(ca, cb); c <= max(ca, cb) && c < p.rowLen(r); c++ {
+			p.selected[p.cands[t[r]+c].Path] = true
+		}
+	}
+}
+
+// refilter rebuilds cands from all, and keeps the cursor on keep when it
+// is still listed.
+func (p *picker) refilter(keep string) {
+	p.visual = false
+	p.cands, p.nHidden = p.all, 0
+	if p.anyHidden && !p.showHidden {
+		p.cands = slices.DeleteFunc(slices.Clone(p.all), func(c Candidate) bool { return c.Hidden })
+		p.nHidden = len(p.all) - len(p.cands)
+	}
+	if p.onlyKind != "" {
+		p.cands = slices.DeleteFunc(slices.Clone(p.cands), func(c Candidate) bool { return c.Kind != p.onlyKind && c.Kind != "dir" })
+	}
+	if p.query != "" {
+		p.cands = matchCandidates(p.cands, p.query)
+	}
+	if len(p.collapsed) > 0 {
+		clear(p.folded)
+		var kept []Candidate
+		for _, c := range p.cands {
+			if p.collapsed[c.Group] {
+				p.folded[c.Group]++
+				if p.folded[c.Group] > 1 {
+					continue
+				}
+			}
+			kept = append(kept, c)
+		}
+		p.cands = kept
+	}
+	p.rowGen++
+	p.cur, p.topRow = 0, 0
+	if keep != "" {
+		if i := indexOfPath(p.cands, keep); i >= 0 {
+			p.cur = i
+		}
+	}
+	p.moveTo(p.cur)
+}
+
+// findMatch moves to the first highlighted match at or after the
+// candidate at path, or to that candidate when nothing matches.
+func (p *picker) findMatch(path string) {
+	i := max(0, indexOfPath(p.cands, path))
+	if p.highlight != "" && len(p.cands) > 0 {
+		if j := nextMatch(p.cands, p.highlight, i-1, 1); j >= 0 {
+			i = j
+		}
+	}
+	p.moveTo(i)
+}
+
+// resort applies the chosen ordering, keeping the cursor on its file.
+func (p *picker) resort() {
+	l := p.opts.Sorts[p.sortIdx].Less
+	p.less = p.ordered(l)
+	if p.reversed {
+		p.less = p.ordered(func(a, b Candidate) bool { return l(b, a) })
+	}
+	keep := ""
+	if len(p.cands) > 0 {
+		keep = p.cands[p.cur].Path
+	}
+	sort.SliceStable(p.all, func(i, j int) bool { return p.less(p.all[i], p.all[j]) })
+	p.refilter(keep)
+	p.flash("sort: " + p.opts.Sorts[p.sortIdx].Name + ternary(p.reversed, ", reversed", ""))
+}
+
+// toggleList switches between tiles and the detail list; the cursor
+// and selection carry over.
+func (p *picker) toggleList() {
+	p.stateMu.Lock()
+	p.listView = !p.listView
+	p.moveTo(p.cur)
+	p.stateMu.Unlock()
+	p.requestRepaint()
+}
+
+// toggleGroup folds group g into its first candidate, or unfolds it,
+// leaving the cursor in the group.
+func (p *picker) toggleGroup(g string) {
+	keep := ""
+	if len(p.cands) > 0 && p.cands[p.cur].Group != g {
+		keep = p.cands[p.cur].Path
+	}
+	if p.collapsed[g] {
+		delete(p.collapsed, g)
+	} else {
+		p.collapsed[g] = true
+	}
+	p.refilter(keep)
+	if keep == "" {
+		if i := slices.IndexFunc(p.cands, func(c Candidate) bool { return c.Group == g }); i >= 0 {
+			p.moveTo(i)
+		}
+	}
+}
+
+// selection lists the selected items in grid order, then those picked
+// in other directories. Called with stateMu held.
+func (p *picker) selection() []Selection {
+	var sel []Selection
+	for i, c := range p.all {
+		if p.selected[c.Path] {
+			sel = append(sel, Selection{Candidate: c, Index: i})
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(p.elsewhere)) {
+		if p.selected[path] && indexOfPath(p.all, path) < 0 {
+			sel = append(sel, Selection{Candidate: p.elsewhere[path], Index: -1})
+		}
+	}
+	return sel
+}
+
+// dropPaths takes the candidates whose paths are gone out of the grid,
+// leaving the cursor on the next one that stays.
+func (p *picker) dropPaths(gone func(path string) bool) {
+	keep := ""
+	for i := p.cur; i < len(p.cands) && keep == ""; i++ {
+		if !gone(p.cands[i].Path) {
+			keep = p.cands[i].Path
+		}
+	}
+	for i := p.cur - 1; i >= 0 && keep == ""; i-- {
+		if !gone(p.cands[i].Path) {
+			keep = p.cands[i].Path
+		}
+	}
+	p.all = slices.DeleteFunc(p.all, func(c Candidate) bool { return gone(c.Path) })
+	maps.DeleteFunc(p.selected, func(p string, _ bool) bool { return gone(p) })
+	p.refilter(keep)
+}
+
+// rate gives the selection, or the current item, stars; 0 clears the
+// rating. Called with stateMu held.
+func (p *picker) rate(stars int) {
+	var paths []string
+	for _, s := range p.selection() {
+		paths = append(paths, s.Path)
+	}
+	if len(paths) == 0 && len(p.cands) > 0 {
+		paths = []string{p.cands[p.cur].Path}
+	}
+	switch {
+	case stars > label.MaxRating:
+		p.flash(fmt.Sprintf("ratings go up to %d", label.MaxRating))
+	case len(paths) > 0:
+		rated := make(map[string]bool, len(paths))
+		var err error
+		for _, p := range paths {
+			if err = label.SetRating(p, stars); err != nil {
+				break
+			}
+			rated[p] = true
+		}
+		for _, list := range [][]Candidate{p.all, p.cands} {
+			for i := range list {
+				if rated[list[i].Path] {
+					list[i].Rating = stars
+				}
+			}
+		}
+		what := filepath.Base(paths[0])
+		if len(paths) > 1 {
+			what = fmt.Sprintf("%d files", len(paths))
+		}
+		switch {
+		case err != nil:
+			p.flash("rating: " + err.Error())
+		case stars == 0:
+			p.flash("cleared the rating of " + what)
+		default:
+			p.flash(fmt.Sprintf("rated %s %s", what, strings.Repeat("★", stars)))
+		}
+	}
+}
+
+// accept ends the pick with the selection, or the current item, as
+// Enter does; on a folder in browse mode it opens it instead. key is
+// the Expect key that asked for it.
+func (p *picker) accept(key string) ([]Selection, bool, error) {
+	p.stateMu.Lock()
+	if p.opts.Browse != nil && !p.visual && len(p.cands) > 0 && p.cands[p.cur].Kind == "dir" {
+		d := p.cands[p.cur].Path
+		p.query = ""
+		p.stateMu.Unlock()
+		p.browseTo(d, "")
+		return nil, false, nil
+	}
+	if p.visual {
+		p.selectRange(p.anchor, p.cur)
+		p.visual = false
+	}
+	if len(p.cands) == 0 && len(p.selected) == 0 {
+		p.stateMu.Unlock()
+		return nil, false, nil
+	}
+	var sel []Selection
+	if len(p.selected) > 0 {
+		sel = p.selection()
+	} else {
+		sel = []Selection{{Candidate: p.cands[p.cur], Index: indexOfPath(p.all, p.cands[p.cur].Path)}}
+	}
+	for i, s := range sel {
+		if s.MTime.IsZero() && s.Kind != "dir" {
+			if info, err := os.Stat(s.Path); err == nil {
+				sel[i].Size, sel[i].MTime = info.Size(), info.ModTime()
+			}
+		}
+	}
+	p.stateMu.Unlock()
+	if p.opts.Accept != nil {
+		err := p.opts.Accept(sel, func(msg string) {
+			p.stateMu.Lock()
+			p.flash(msg)
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		})
+		if err != nil {
+			p.wipe()
+			return nil, true, err
+		}
+	}
+	p.wipe()
+	if p.opts.AcceptedBy != nil {
+		p.opts.AcceptedBy(key)
+	}
+	return sel, true, nil
+}
+
+// command carries out c, or returns the key it presses.
+func (p *picker) command(c Command) (byte, string, error) {
+	if key, ok := actionKeys[c.Name]; ok {
+		return key, "", nil
+	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.startAt, p.seekMatch, p.typed = "", false, true
+	keep := ""
+	if len(p.cands) > 0 {
+		keep = p.cands[p.cur].Path
+	}
+	switch c.Name {
+	case "filter":
+		switch strings.ToLower(c.Arg) {
+		case "images", "image":
+			p.onlyKind = "image"
+		case "videos", "video":
+			p.onlyKind = "video"
+		case "both", "all", "":
+			p.onlyKind = ""
+		default:
+			return 0, "", fmt.Errorf("unknown filter %q (expected images, videos or both)", c.Arg)
+		}
+		p.refilter(keep)
+	case "search":
+		p.searching = false
+		if p.opts.HighlightSearch {
+			p.highlight = c.Arg
+			p.findMatch(keep)
+		} else {
+			p.query = c.Arg
+			p.refilter(keep)
+		}
+	case "select":
+		i := slices.IndexFunc(p.cands, func(cd Candidate) bool { return p.abs(cd.Path) == p.abs(c.Arg) })
+		if i < 0 {
+			return 0, "", fmt.Errorf("not shown: %s", c.Arg)
+		}
+		p.moveTo(i)
+	case "current":
+		return 0, keep, nil
+	default:
+		return 0, "", fmt.Errorf("unknown command %q", c.Name)
+	}
+	p.requestRepaint()
+	return 0, "", nil
+}
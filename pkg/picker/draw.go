@@ -0,0 +1,684 @@
+package picker
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/internal/term"
+	runewidth "github.com/mattn/go-runewidth"
+	xt "golang.org/x/term"
+)
+
+// enter takes over the terminal and returns the inline area's top row.
+func (p *picker) enter() int {
+	if !p.inline {
+		fmt.Fprint(p.out, enterScreen)
+		return 1
+	}
+	_, termH, _ := xt.GetSize(int(p.out.Fd()))
+	termH = max(termH, p.inlineH)
+	row, col, ok := term.CursorPos()
+	if !ok {
+		row, col = termH, 1
+	}
+	// Start below a half-typed command line; newlines scroll the
+	// screen up when there is no room left.
+	start := row + ternary(col > 1, 1, 0)
+	scrolled := max(0, start+p.inlineH-1-termH)
+	fmt.Fprint(p.out, "\r"+strings.Repeat("\n", start-row+p.inlineH-1)+inlineEnter)
+	p.restoreRow, p.restoreCol = row-scrolled, col
+	return start - scrolled
+}
+
+// clearScreen blanks the grid's lines, and only those when inline.
+func (p *picker) clearScreen() string {
+	if !p.inline {
+		return "\x1b[2J\x1b[H"
+	}
+	var b strings.Builder
+	for y := 1; y <= p.h; y++ {
+		fmt.Fprintf(&b, "\x1b[%d;1H\x1b[2K", y)
+	}
+	return b.String() + "\x1b[H"
+}
+
+// leave gives the screen back, or the lines an inline grid took.
+func (p *picker) leave() {
+	if !p.inline {
+		fmt.Fprint(p.out, leaveScreen)
+		return
+	}
+	fmt.Fprintf(p.out, "%s%s\x1b[%d;%dH", p.clearScreen(), inlineLeave, p.restoreRow, p.restoreCol)
+}
+
+// restoreTerm puts the terminal back the way Run found it, once.
+func (p *picker) restoreTerm() {
+	p.restoreOnce.Do(func() {
+		p.leave()
+		p.restoreVT()
+		_ = xt.Restore(p.fdIn, p.old)
+	})
+}
+
+// A panic in Run unwinds through restoreTerm, one in a goroutine would
+// not: guard puts the terminal back before the process dies.
+func (p *picker) guard() {
+	if r := recover(); r != nil {
+		p.restoreTerm()
+		panic(r)
+	}
+}
+
+// wipe takes the images and text off the screen, on the way out.
+func (p *picker) wipe() {
+	term.Lock()
+	defer term.Unlock()
+	if p.sched != nil {
+		p.sched.NextFrame()
+	}
+	if p.renderer != nil {
+		_ = p.renderer.ClearAll()
+	}
+	fmt.Fprint(p.out, p.clearScreen())
+}
+
+// flash shows msg in the status line.
+func (p *picker) flash(msg string) {
+	p.notice, p.noticeUntil = sanitizePrintable(msg), time.Now().Add(noticeTimeout)
+}
+
+// drawTile draws candidate idx into grid slot slot at px, py, or blanks
+// the slot when idx is -1. It skips slots that still show the same thing.
+func (p *picker) drawTile(buf *bytes.Buffer, slot, idx, px, py, tileW, tileH int, renderImages bool) {
+	if p.listView {
+		ts := tileState{idx: -1}
+		line := ""
+		if idx >= 0 && idx < len(p.cands) {
+			c := p.cands[idx]
+			p.lookUp(c, true)
+			ts = tileState{idx: idx, path: c.Path, cursor: idx == p.cur, selected: p.isSelected(idx), rating: c.Rating}
+			ts.match = p.highlight != "" && nameMatches(c, p.highlight)
+			name := c.Name + ternary(c.Kind == "dir", "/", "")
+			if p.collapsed[c.Group] {
+				ts.more = p.folded[c.Group] - 1
+				name = fmt.Sprintf("%s +%d", name, ts.more)
+			}
+			if c.Rating > 0 {
+				name += " " + strings.Repeat("★", c.Rating)
+			}
+			res := ""
+			if c.Width > 0 && c.Height > 0 {
+				res = fmt.Sprintf("%dx%d", c.Width, c.Height)
+			}
+			size := ternary(c.Kind == "dir", "", meta.HumanSize(c.Size))
+			line = fmt.Sprintf("%c%c %s", ternary(ts.cursor, '>', ' '), ternary(ts.selected, '*', ' '),
+				listRow(name, size, c.MTime.Format("2006-01-02 15:04"), c.Kind, res, tileW-3))
+			switch {
+			case ts.cursor:
+				line = p.th.cursor.wrap(line)
+			case ts.selected:
+				line = p.th.selected.wrap(line)
+			case ts.match:
+				line = p.th.match.wrap(line)
+			}
+		}
+		ts.row = line
+		if p.drawnTiles[slot] != ts {
+			p.drawnTiles[slot] = ts
+			fmt.Fprintf(buf, "\x1b[%d;%dH%s\x1b[K", py, px, line)
+			p.drawnInfo = ""
+		}
+		return
+	}
+	innerW := tileW - 2
+	if innerW < 2 {
+		innerW = 2
+	}
+	imgH := max(1, tileH-3)
+	ts := tileState{idx: -1}
+	isImg := false
+	if idx >= 0 && idx < len(p.cands) {
+		c := p.cands[idx]
+		p.lookUp(c, false)
+		ts = tileState{idx: idx, path: c.Path, cursor: idx == p.cur, selected: p.isSelected(idx), rating: c.Rating}
+		ts.match = p.highlight != "" && nameMatches(c, p.highlight)
+		if p.collapsed[c.Group] {
+			ts.more = p.folded[c.Group] - 1
+		}
+		if p.opts.Justified {
+			ts.x, ts.w = px, tileW
+		}
+		isImg = hasThumb(c)
+		if renderImages && isImg && p.sched != nil {
+			wpx, hpx := p.tileThumbSize(tileW, tileH)
+			if tp, ok := p.ensureThumb(c.Path, wpx, hpx, ternary(idx == p.cur, prioCursor, prioVisible)); ok {
+				if idx == p.cur && len(p.hoverPaths) > 0 && p.hoverKey == (thumbKey{c.Path, wpx, hpx}) {
+					tp = p.hoverPaths[p.hoverFrame]
+				}
+				ts.thumb = tp
+			} else if p.thumbErr(c.Path, wpx, hpx) != nil {
+				ts.icon = ternary(c.Kind == "dir", "", "! ") + otherIcon(c)
+			} else if ts.fill = p.placeholder(c.Path, ternary(idx == p.cur, prioCursor, prioVisible)); ts.fill == "" {
+				ts.icon = "…"
+			}
+		} else {
+			ts.icon = otherIcon(c)
+		}
+	}
+	prev := p.drawnTiles[slot]
+	if prev == ts {
+		return
+	}
+	p.drawnTiles[slot] = ts
+	if prev.thumb != "" && prev.thumb != ts.thumb && p.renderer != nil {
+		_ = p.renderer.Clear(px+1, py+1, innerW, imgH)
+	}
+
+	bx, st := p.plainBox, p.th.border
+	if ts.selected {
+		bx, st = p.selectedBox, p.th.selected
+	}
+	if ts.cursor {
+		// A selected cursor keeps the double lines.
+		bx, st = ternary(ts.selected, p.selectedBox, p.cursorBox), p.th.cursor
+	}
+	bar := st.wrap(bx.v)
+	hline := strings.Repeat(bx.h, max(0, tileW-2))
+	top := st.wrap(bx.tl + hline + bx.tr)
+	bot := st.wrap(bx.bl + hline + bx.br)
+	fmt.Fprintf(buf, "\x1b[%d;%dH%s", py, px, top)
+	fmt.Fprintf(buf, "\x1b[%d;%dH%s", py+tileH-1, px, bot)
+	for r := 1; r < tileH-1; r++ {
+		blank := strings.Repeat(" ", innerW)
+		if ts.fill != "" && r <= imgH {
+			blank = ts.fill + blank + "\x1b[49m"
+		}
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s%s%s", py+r, px, bar, blank, bar)
+	}
+	if ts.idx < 0 {
+		return
+	}
+
+	c := p.cands[idx]
+	if ts.thumb != "" {
+		if !p.sched.Enqueue(ts.thumb, px+1, py+1, innerW, imgH) {
+			// Scheduler backlog is full; try this slot again next frame.
+			p.drawnTiles[slot] = tileState{idx: -2}
+			select {
+			case p.repaintCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if icon := ts.icon; icon != "" {
+		if dispWidth(icon) > innerW {
+			icon = runewidth.Truncate(icon, innerW, "")
+		}
+		ix := px + 1 + max(0, (innerW-dispWidth(icon))/2)
+		iy := py + 1 + max(0, (imgH-1)/2)
+		if ts.icon == "…" {
+			// Still generating: keep it faint.
+			icon = "\x1b[2m" + icon + "\x1b[22m"
+		}
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s", iy, ix, icon)
+	}
+	name := c.Name + ternary(c.Kind == "dir", "/", "")
+	if ts.more > 0 {
+		name = fmt.Sprintf("%s +%d", name, ts.more)
+	}
+	// Stars go at the right end of the name line, or as "3★" in
+	// narrow tiles.
+	stars := ""
+	if ts.rating > 0 {
+		stars = " " + strings.Repeat("★", ts.rating)
+		if dispWidth(stars) > innerW/2 {
+			stars = fmt.Sprintf(" %d★", ts.rating)
+		}
+	}
+	name = truncateMiddleDisp(name, innerW-4-dispWidth(stars))
+	line := fmt.Sprintf("%c%c %s", ternary(idx == p.cur, '>', ' '), ternary(ts.selected, '*', ' '), name)
+	line = padRightToWidth(line, innerW-dispWidth(stars)) + stars
+	if ts.match {
+		line = line[:3] + p.th.match.wrap(name) + line[3+len(name):]
+	}
+	if tileH >= 3 {
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s%s%s", py+tileH-2, px, bar, line, bar)
+	}
+}
+
+// drawPreview draws the cursor's file over the whole screen.
+func (p *picker) drawPreview(buf *bytes.Buffer) {
+	c := p.cands[p.cur]
+	p.lookUp(c, true)
+	areaH := max(1, p.h-2)
+	isImg := p.showImages && hasThumb(c)
+	tp := ""
+	if isImg {
+		wpx, hpx := p.thumbSize(max(8, p.w*p.ppcX), max(8, areaH*p.ppcY))
+		tp, _ = p.ensureThumb(c.Path, wpx, hpx, prioCursor)
+	}
+	key := fmt.Sprint(c.Path, "|", tp, "|", c.Size, "|", c.MTime.UnixNano(), "|", p.previewInfo)
+	if key == p.drawnPreview {
+		return
+	}
+	p.drawnPreview = key
+	title := truncateMiddleDisp(c.Name, p.w)
+	fmt.Fprintf(buf, "\x1b[1;%dH%s", 1+max(0, (p.w-dispWidth(title))/2), p.th.header.wrap(title))
+	if isImg {
+		if tp != "" && p.sched != nil && !p.sched.Enqueue(tp, 1, 2, p.w, areaH) {
+			p.drawnPreview = ""
+		}
+	} else {
+		icon := otherIcon(c)
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s", 2+areaH/2, 1+max(0, (p.w-dispWidth(icon))/2), icon)
+	}
+	parts := []string{c.Kind}
+	if p.previewInfo.Width > 0 && p.previewInfo.Height > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", p.previewInfo.Width, p.previewInfo.Height))
+	}
+	if p.previewInfo.Duration > 0 {
+		d := time.Duration(p.previewInfo.Duration * float64(time.Second)).Round(time.Second)
+		parts = append(parts, d.String())
+	}
+	parts = append(parts, meta.HumanSize(c.Size), c.MTime.Format("2006-01-02 15:04"), "any key returns")
+	s := sanitizePrintable(strings.Join(parts, " • "))
+	if dispWidth(s) > p.w {
+		s = runewidth.Truncate(s, p.w, "")
+	}
+	fmt.Fprintf(buf, "\x1b[%d;1H%s", p.h, p.th.status.wrap(s))
+}
+
+// drawInfo fills the info panel from column x to the right edge, and
+// starts reading the current item's details when they aren't known yet.
+func (p *picker) drawInfo(buf *bytes.Buffer, x int) {
+	var lines []string
+	if len(p.cands) > 0 {
+		c := p.cands[p.cur]
+		p.lookUp(c, true)
+		fields, ok := p.infoFields[c.Path]
+		if !ok && !p.infoLoading[c.Path] {
+			p.infoLoading[c.Path] = true
+			p.thumbWG.Add(1)
+			go func() {
+				defer p.thumbWG.Done()
+				defer p.guard()
+				var f []meta.Field
+				if info, err := meta.Probe(c.Path, c.Kind); err == nil {
+					if info.Width > 0 && info.Height > 0 {
+						f = append(f, meta.Field{Name: "Dimensions", Value: fmt.Sprintf("%dx%d", info.Width, info.Height)})
+					}
+					if info.Duration > 0 {
+						d := time.Duration(info.Duration * float64(time.Second)).Round(time.Second)
+						f = append(f, meta.Field{Name: "Duration", Value: d.String()})
+					}
+				}
+				f = append(f, meta.Details(c.Path, c.Kind)...)
+				p.stateMu.Lock()
+				p.infoFields[c.Path] = f
+				delete(p.infoLoading, c.Path)
+				p.stateMu.Unlock()
+				select {
+				case p.repaintCh <- struct{}{}:
+				default:
+				}
+			}()
+		}
+		head := []meta.Field{
+			{Name: "Type", Value: c.Kind},
+			{Name: "Size", Value: meta.HumanSize(c.Size)},
+			{Name: "Modified", Value: c.MTime.Format("2006-01-02 15:04")},
+		}
+		if c.Rating > 0 {
+			head = append(head, meta.Field{Name: "Rating", Value: strings.Repeat("★", c.Rating)})
+		}
+		if len(c.Tags) > 0 {
+			head = append(head, meta.Field{Name: "Tags", Value: strings.Join(c.Tags, ", ")})
+		}
+		fields = append(head, fields...)
+		if !ok {
+			fields = append(fields, meta.Field{Name: "", Value: "reading…"})
+		}
+		fields = append(fields, meta.Field{Name: "Folder", Value: filepath.Dir(c.Path)})
+		width := p.w - x - 2
+		lines = append(lines, p.th.header.wrap(truncateMiddleDisp(sanitizePrintable(c.Name), width)), "")
+		for _, f := range fields {
+			v := truncateMiddleDisp(sanitizePrintable(f.Value), width-12)
+			lines = append(lines, padRightToWidth(f.Name, 12)+v)
+		}
+	}
+	key := strings.Join(lines, "\n")
+	if key == p.drawnInfo {
+		return
+	}
+	p.drawnInfo = key
+	bar := p.th.border.wrap(p.plainBox.v)
+	for i := 0; i < p.contentH; i++ {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		fmt.Fprintf(buf, "\x1b[%d;%dH%s \x1b[K%s", p.contentY+i, x, bar, line)
+	}
+}
+
+// draw builds the frame in frameBuf, with the renderer's output, and
+// sends it in one write, bracketed as a synchronized update where the
+// terminal takes them so that it shows the frame whole. An unchanged
+// frame writes nothing.
+func (p *picker) draw() {
+	term.Lock()
+	defer term.Unlock()
+	p.frameBuf.Reset()
+	p.frameBuf.WriteString(p.syncBegin)
+	term.BeginFrame(&p.frameBuf)
+	defer func() {
+		term.EndFrame()
+		if p.frameBuf.Len() > len(p.syncBegin) {
+			p.frameBuf.WriteString(p.syncEnd)
+			_, _ = p.out.Write(p.frameBuf.Bytes())
+		}
+	}()
+	p.thumbQ.begin()
+	defer p.thumbQ.sweep()
+	p.colorQ.begin()
+	defer p.colorQ.sweep()
+	defer p.readShown()
+	inPreview := p.previewing && len(p.cands) > 0
+	gridX, gridY, gridW, _, tileW, tileH, cols, rows, gutter := p.computeLayout()
+	fs := frameState{p.w, p.h, gridW, tileW, tileH, cols, rows, p.showImages, inPreview, p.anyGroups, p.listView, p.truncated}
+	if p.firstDraw || fs != p.drawnFrame {
+		if p.sched != nil {
+			p.sched.NextFrame()
+		}
+		if !p.firstDraw && p.renderer != nil {
+			_ = p.renderer.ClearAll()
+		}
+		if p.inline {
+			fmt.Fprintf(&p.frameBuf, "\x1b[%d;%dr\x1b[?6h", p.originY, p.originY+p.h-1)
+		}
+		fmt.Fprint(&p.frameBuf, p.clearScreen())
+		p.firstDraw = false
+		p.drawnFrame = fs
+		p.drawnTiles = make([]tileState, cols*rows)
+		p.drawnGroups = make([]string, rows)
+		p.drawnHeader, p.drawnStatus, p.drawnPreview, p.drawnInfo, p.drawnMore = "", "", "", "", ""
+		if p.listView && !inPreview {
+			titles := "   " + listRow("Name", "Size", "Modified", "Type", "Resolution", gridW-3)
+			fmt.Fprintf(&p.frameBuf, "\x1b[%d;1H%s", gridY-1, p.th.header.wrap(titles))
+		}
+	} else if d := p.topRow - p.drawnTop; d != 0 && max(d, -d) < rows && !p.inline && !inPreview && (p.sched == nil || p.sched.Idle()) {
+		// Scrolled by less than a screenful: have the terminal move the
+		// rows still in view, images and all, so that only the rows
+		// coming into view are drawn. An image draw still queued would
+		// land where its tile no longer is, so that case redraws.
+		stepH := tileH + gutter
+		top := gridY - ternary(p.anyGroups && !p.listView, 1, 0)
+		_ = term.Scroll(p.renderer, top, gridY+rows*stepH-gutter-1, d*stepH)
+		shiftSlots(p.drawnTiles, d*cols)
+		shiftSlots(p.drawnGroups, d)
+		// The scroll region spans whole lines, so the info panel beside
+		// the grid moved too; drawing it again puts it back.
+		p.drawnInfo = ""
+	}
+	p.drawnTop = p.topRow
+	if inPreview {
+		p.drawPreview(&p.frameBuf)
+		return
+	}
+	header := fmt.Sprintf("[%s] Arrows/hjkl move • Space select • Enter accept • q/Esc cancel", ternary(p.useGraphics, p.renderer.Name(), "none"))
+	if p.opts.Browse != nil {
+		header = fmt.Sprintf("[%s] %s • Enter open • Backspace up", ternary(p.useGraphics, p.renderer.Name(), "none"), breadcrumb(p.dir))
+	}
+	if dispWidth(header) > p.w {
+		header = runewidth.Truncate(header, p.w, "")
+	}
+	if header != p.drawnHeader {
+		fmt.Fprintf(&p.frameBuf, "\x1b[1;1H%s\x1b[K", p.th.header.wrap(header))
+		p.drawnHeader = header
+	}
+
+	prefetchRows := ternary(p.remote, 0, 1)
+	if p.showImages && !p.listView && rows > 0 && cols > 0 {
+		for r := -prefetchRows; r < rows+prefetchRows; r++ {
+			rr := p.topRow + r
+			if rr < 0 {
+				continue
+			}
+			for ccol := 0; rr < p.dataRows() && ccol < p.rowLen(rr); ccol++ {
+				idx := p.rowTable()[rr] + ccol
+				c := p.cands[idx]
+				if !hasThumb(c) {
+					continue
+				}
+				_, tw := p.tileAt(idx, ccol)
+				wpx, hpx := p.tileThumbSize(tw, tileH)
+				prio := prioPrefetch
+				switch {
+				case idx == p.cur:
+					prio = prioCursor
+				case r >= 0 && r < rows:
+					prio = prioVisible
+				}
+				_, _ = p.ensureThumb(c.Path, wpx, hpx, prio)
+			}
+		}
+	}
+	renderImages := p.showImages && !p.listView
+	if rows > 0 && cols > 0 {
+		for r := 0; r < rows; r++ {
+			rr := p.topRow + r
+			py := gridY + r*(tileH+gutter)
+			slotIdx := func(ccol int) int {
+				if rr < p.dataRows() && ccol < p.rowLen(rr) {
+					return p.rowTable()[rr] + ccol
+				}
+				return -1
+			}
+			if p.opts.Justified && !p.listView {
+				// Justified tiles move whenever the row is packed
+				// differently; start the row over when that happens.
+				moved := false
+				for ccol := 0; ccol < cols; ccol++ {
+					want := tileState{}
+					if idx := slotIdx(ccol); idx >= 0 {
+						want.x, want.w = p.tileAt(idx, ccol)
+						want.x += gridX
+					}
+					if prev := p.drawnTiles[r*cols+ccol]; prev.x != want.x || prev.w != want.w {
+						moved = true
+					}
+				}
+				if moved {
+					if p.renderer != nil {
+						_ = p.renderer.Clear(gridX, py, gridW, tileH)
+					}
+					for y := py; y < py+tileH; y++ {
+						fmt.Fprintf(&p.frameBuf, "\x1b[%d;1H\x1b[2K", y)
+					}
+					p.drawnInfo = ""
+					for ccol := 0; ccol < cols; ccol++ {
+						p.drawnTiles[r*cols+ccol] = tileState{idx: -1}
+					}
+				}
+			}
+			for ccol := 0; ccol < cols; ccol++ {
+				idx := slotIdx(ccol)
+				if idx < 0 && p.opts.Justified && !p.listView {
+					continue
+				}
+				tx, tw := p.tileAt(idx, ccol)
+				p.drawTile(&p.frameBuf, r*cols+ccol, idx, gridX+tx, py, tw, tileH, renderImages)
+			}
+			// Group headers sit in the gutter line above their row.
+			title := ""
+			if g, ok := p.groupAt(rr); ok && p.anyGroups && !p.listView {
+				n := p.folded[g]
+				if !p.collapsed[g] {
+					for q := rr; q < p.dataRows(); q++ {
+						if gq := p.cands[p.rowTable()[q]].Group; gq != g {
+							break
+						}
+						n += p.rowLen(q)
+					}
+				}
+				name := g
+				if p.opts.GroupTitle != nil {
+					name = p.opts.GroupTitle(g)
+				}
+				title = fmt.Sprintf("%s %s (%d)", ternary(p.collapsed[g], "▸", "▾"), name, n)
+				if dispWidth(title) > gridW {
+					title = runewidth.Truncate(title, gridW, "")
+				}
+			}
+			if p.anyGroups && !p.listView && title != p.drawnGroups[r] {
+				fmt.Fprintf(&p.frameBuf, "\x1b[%d;1H%s\x1b[K", gridY+r*(tileH+gutter)-1, p.th.header.wrap(title))
+				p.drawnGroups[r] = title
+				p.drawnInfo = ""
+			}
+		}
+	}
+	var status string
+	if len(p.cands) > 0 {
+		c := p.cands[p.cur]
+		p.lookUp(c, true)
+		idx := p.cur + 1
+		_, _, _, _, _, tileH, cols, rows, _ = p.computeLayout()
+		_, tileW = p.tileAt(p.cur, 0)
+		status = fmt.Sprintf("%d/%d • Name: %s • Type: %s • Size: %s",
+			idx, len(p.cands), truncateMiddleDisp(c.Name, max(10, p.w/3)), c.Kind, meta.HumanSize(c.Size))
+		if len(c.Tags) > 0 {
+			status += " • Tags: " + strings.Join(c.Tags, ", ")
+		}
+		if !p.listView {
+			status += fmt.Sprintf(" • Grid: %dx%d • Tile: %dx%d", cols, rows, tileW, tileH)
+		}
+		if p.showImages && !p.listView {
+			wpx, hpx := p.tileThumbSize(tileW, tileH)
+			if err := p.thumbErr(c.Path, wpx, hpx); err != nil {
+				msg, _, _ := strings.Cut(err.Error(), "\n")
+				status = "no thumbnail: " + msg + " • " + status
+			}
+		}
+	} else {
+		status = "(no items)"
+	}
+	if p.query != "" {
+		status = fmt.Sprintf("filter: %s (%d/%d) • %s", p.query, len(p.cands), len(p.all), status)
+	} else if p.highlight != "" && !p.searching {
+		n := p.matchCount()
+		status = fmt.Sprintf("search: %s (%d %s) • %s", p.highlight, n, ternary(n == 1, "match", "matches"), status)
+	} else if p.nHidden > 0 {
+		status = fmt.Sprintf("%d hidden • %s", p.nHidden, status)
+	}
+	if p.onlyKind != "" {
+		status = fmt.Sprintf("%ss only • %s", p.onlyKind, status)
+	}
+	if n := len(p.selected); n > 0 || p.visual {
+		for i := min(p.anchor, p.cur); p.visual && i <= max(p.anchor, p.cur) && i < len(p.cands); i++ {
+			if !p.selected[p.cands[i].Path] {
+				n++
+			}
+		}
+		status = fmt.Sprintf("%s%d selected • %s", ternary(p.visual, "-- VISUAL -- ", ""), n, status)
+	}
+	if p.notice != "" {
+		status = p.notice + " • " + status
+	}
+	if p.truncated {
+		status = fmt.Sprintf("first %d loaded, more not read • %s", p.loaded, status)
+	} else if p.scanning {
+		status = fmt.Sprintf("scanning… %d found • %s", len(p.all), status)
+	} else if p.scanErr != nil {
+		status = fmt.Sprintf("scan error: %v • %s", p.scanErr, status)
+	}
+	if p.searching && p.opts.HighlightSearch {
+		n := p.matchCount()
+		status = fmt.Sprintf("/%s█ (%d %s)", p.highlight, n, ternary(n == 1, "match", "matches"))
+	} else if p.searching {
+		status = fmt.Sprintf("/%s█ (%d/%d)", p.query, len(p.cands), len(p.all))
+	}
+	if n := len(p.confirmTrash); n > 0 {
+		status = fmt.Sprintf("Move %d %s to the trash? (y/n)", n, ternary(n == 1, "file", "files"))
+	}
+	if p.tagPaths != nil {
+		if p.tagAdd {
+			n := len(p.tagPaths)
+			status = fmt.Sprintf("add tags to %d %s: %s█", n, ternary(n == 1, "file", "files"), p.tagText)
+		} else {
+			status = fmt.Sprintf("tags for %s (comma-separated): %s█", filepath.Base(p.tagPaths[0]), p.tagText)
+		}
+	}
+	if gridW < p.w {
+		p.drawInfo(&p.frameBuf, gridW+1)
+	}
+	if p.truncated && p.contentH > 0 {
+		more := runewidth.FillRight(runewidth.Truncate(
+			fmt.Sprintf("  ⋯ more files not loaded: click here or press M for the next %d", p.opts.Limit), gridW, ""), gridW)
+		if more != p.drawnMore {
+			fmt.Fprintf(&p.frameBuf, "\x1b[%d;1H%s", p.contentY+p.contentH-1, p.th.header.wrap(more))
+			p.drawnMore = more
+		}
+	}
+	if p.h >= 2 {
+		s := sanitizePrintable(status)
+		if dispWidth(s) > p.w {
+			s = runewidth.Truncate(s, p.w, "")
+		}
+		if s != p.drawnStatus {
+			fmt.Fprintf(&p.frameBuf, "\x1b[%d;1H%s\x1b[K", p.h, p.th.status.wrap(s))
+			p.drawnStatus = s
+		}
+	}
+}
+
+// requestRepaint asks renderLoop for a frame.
+func (p *picker) requestRepaint() {
+	select {
+	case p.repaintCh <- struct{}{}:
+	default:
+	}
+}
+
+// renderLoop draws a frame when one was asked for, at most every 16ms,
+// until quitRender is closed.
+func (p *picker) renderLoop() {
+	defer p.renderWG.Done()
+	defer p.guard()
+	ticker := time.NewTicker(16 * time.Millisecond)
+	defer ticker.Stop()
+	dirty := true
+	for {
+		select {
+		case <-p.quitRender:
+			return
+		case <-p.repaintCh:
+			dirty = true
+		case now := <-ticker.C:
+			p.stateMu.Lock()
+			if p.hoverTick(now) {
+				dirty = true
+			}
+			if p.notice != "" && now.After(p.noticeUntil) {
+				p.notice, p.failedRecently = "", 0
+				dirty = true
+			}
+			p.stateMu.Unlock()
+			if !dirty {
+				continue
+			}
+			if p.sched != nil {
+				// Slots are only redrawn when they change, so every
+				// queued image must land before the next frame.
+				p.sched.Drain()
+			}
+			p.stateMu.Lock()
+			p.draw()
+			p.stateMu.Unlock()
+			dirty = false
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package picker
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// drawn draws a frame and returns what it wrote.
+func drawn(t *testing.T, p *picker) string {
+	t.Helper()
+	f := p.out
+	off, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.stateMu.Lock()
+	p.draw()
+	p.stateMu.Unlock()
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[off:])
+}
+
+func TestDrawWritesOnlyChanges(t *testing.T) {
+	p := testPicker(t, Options{}, numbered(30, "", 0, 0))
+	const clear = "\x1b[2J"
+	steps := []struct {
+		name      string
+		change    func()
+		wantClear bool
+		// wantTiles is how many tile slots are drawn again, counted by
+		// their top-left corner.
+		wantTiles int
+	}{
+		{"first", func() {}, true, 16},
+		{"unchanged", func() {}, false, 0},
+		{"cursor moved", func() { p.moveTo(1) }, false, 2},
+		{"selected", func() { p.selected[p.cands[2].Path] = true }, false, 1},
+		// The terminal moves the rows still in view; only the row coming
+		// into view is drawn.
+		{"scrolled", func() { p.topRow = 1 }, false, 4},
+		{"resized", func() { p.w = 100 }, true, 20},
+		{"list view", func() { p.toggleList() }, true, 0},
+	}
+	for _, s := range steps {
+		s.change()
+		out := drawn(t, p)
+		if got := strings.Contains(out, clear); got != s.wantClear {
+			t.Errorf("%s: cleared the screen %v, want %v", s.name, got, s.wantClear)
+		}
+		corners := strings.Count(out, p.plainBox.tl) + strings.Count(out, p.cursorBox.tl) + strings.Count(out, p.selectedBox.tl)
+		if corners != s.wantTiles {
+			t.Errorf("%s: drew %d tiles, want %d", s.name, corners, s.wantTiles)
+		}
+		if s.name == "unchanged" && out != "" {
+			t.Errorf("unchanged: wrote %q", out)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package picker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// inlineEnter and inlineLeave switch the cursor and mouse reporting for the
+// inline grid, which stays on the main screen.
+const (
+	inlineEnter = "\x1b[?25l\x1b[?1000h\x1b[?1002h\x1b[?1006h"
+	inlineLeave = "\x1b[?6l\x1b[r\x1b[?1006l\x1b[?1002l\x1b[?1000l\x1b[?25h"
+)
+
+// minInlineHeight fits the header, one row of the smallest tiles and the
+// status line.
+const minInlineHeight = 6
+
+// parseHeight turns an Options.Height of "15" or "40%" into a number of
+// lines on a termH-line terminal; "" is the whole terminal.
+func parseHeight(s string, termH int) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return termH, nil
+	}
+	pct, isPct := strings.CutSuffix(s, "%")
+	n, err := strconv.Atoi(pct)
+	if err != nil || n <= 0 || isPct && n > 100 {
+		return 0, fmt.Errorf("invalid height %q (expected lines, e.g. 15, or a percentage, e.g. 40%%)", s)
+	}
+	if isPct {
+		n = termH * n / 100
+	}
+	return min(termH, max(n, minInlineHeight)), nil
+}
@@ -0,0 +1,1156 @@
+package picker
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ck-zhang/thumbgrid/internal/label"
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/internal/term"
+	xt "golang.org/x/term"
+)
+
+type inputReader struct {
+	ch      chan []byte
+	quit    chan struct{}
+	pending []byte
+}
+
+func startInput(f *os.File) *inputReader {
+	r := &inputReader{
+		ch:   make(chan []byte, 16),
+		quit: make(chan struct{}),
+	}
+	go r.loop(f)
+	return r
+}
+
+func (r *inputReader) loop(f *os.File) {
+	defer close(r.ch)
+	buf := make([]byte, 1024)
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+		m, err := term.ReadTimeout(f, buf, 100*time.Millisecond)
+		if m > 0 {
+			chunk := append([]byte(nil), buf[:m]...)
+			select {
+			case r.ch <- chunk:
+			case <-r.quit:
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *inputReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		chunk, ok := <-r.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		r.pending = chunk
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *inputReader) Close() { close(r.quit) }
+
+// loop reads keys and commands until the pick is over.
+func (p *picker) loop() ([]Selection, error) {
+	for {
+		if p.br.Buffered() == 0 && len(p.input.pending) == 0 {
+			select {
+			case chunk, ok := <-p.input.ch:
+				if ok {
+					p.input.pending = chunk
+				}
+			case c := <-p.opts.Control:
+				key, out, err := p.command(c)
+				if c.Reply != nil {
+					c.Reply(out, err)
+				}
+				if key == 0 {
+					continue
+				}
+				p.pressed = key
+			case <-p.scanDone:
+				p.scanDone = nil
+				p.stateMu.Lock()
+				n, serr := len(p.all), p.scanErr
+				one := p.opts.SelectOne && !p.typed && p.opts.Browse == nil && len(p.cands) == 1
+				p.stateMu.Unlock()
+				if one {
+					if sel, ok, err := p.accept(""); ok {
+						return sel, err
+					}
+				}
+				if n > 0 || p.opts.Browse != nil || p.opts.Updates != nil {
+					continue
+				}
+				p.wipe()
+				if serr != nil {
+					return nil, fmt.Errorf("scan error: %w", serr)
+				}
+				return nil, ErrNoCandidates
+			case <-p.ctx.Done():
+				p.wipe()
+				return nil, p.ctx.Err()
+			}
+		}
+		b, fromControl := p.pressed, p.pressed != 0
+		p.pressed = 0
+		if !fromControl {
+			var err error
+			if b, err = p.br.ReadByte(); err != nil {
+				return nil, fmt.Errorf("read: %w", err)
+			}
+		}
+		if sel, done, err := p.handleKey(b, fromControl); done {
+			return sel, err
+		}
+	}
+}
+
+// handleKey acts on key b, typed or, with fromControl, sent by a command.
+// done is set when the pick is over, with its result.
+func (p *picker) handleKey(b byte, fromControl bool) (sel []Selection, done bool, err error) {
+	p.stateMu.Lock()
+	p.startAt, p.seekMatch, p.typed = "", false, true
+	if p.notice != "" {
+		p.notice, p.failedRecently = "", 0
+		p.requestRepaint()
+	}
+	p.stateMu.Unlock()
+	if p.confirmTrash != nil {
+		if b == 0x1b {
+			_, _ = p.br.Discard(p.br.Buffered())
+		}
+		p.stateMu.Lock()
+		paths := p.confirmTrash
+		p.confirmTrash = nil
+		p.stateMu.Unlock()
+		if b == 'y' || b == 'Y' {
+			p.trashPaths(paths)
+		}
+		p.requestRepaint()
+		p.awaitGG = false
+		return nil, false, nil
+	}
+	if p.previewing {
+		if b == 0x1b {
+			_, _ = p.br.Discard(p.br.Buffered())
+		}
+		p.stateMu.Lock()
+		p.previewing = false
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		return nil, false, nil
+	}
+	if p.searching {
+		handled := true
+		// Filtering shows the best match first; highlighting moves
+		// to the first match from where the search started.
+		sq, update := &p.query, func() { p.refilter("") }
+		if p.opts.HighlightSearch {
+			sq, update = &p.highlight, func() { p.findMatch(p.searchFrom) }
+		}
+		p.stateMu.Lock()
+		switch {
+		case b == 0x1b && p.br.Buffered() == 0:
+			p.searching = false
+			*sq = ""
+			if p.opts.HighlightSearch {
+				p.findMatch(p.searchFrom)
+			} else {
+				p.refilter(p.searchFrom)
+			}
+		case b == '\r' || b == '\n':
+			p.searching = false
+		case b == 0x7f || b == 0x08:
+			if *sq != "" {
+				_, n := utf8.DecodeLastRuneInString(*sq)
+				*sq = (*sq)[:len(*sq)-n]
+				update()
+			}
+		case b == 0x15:
+			*sq = ""
+			update()
+		case b >= 0x20 && b != 0x7f:
+			*sq += string([]byte{b})
+			update()
+		default:
+			handled = false
+		}
+		p.stateMu.Unlock()
+		if handled {
+			p.requestRepaint()
+			p.awaitGG = false
+			return nil, false, nil
+		}
+	}
+	if p.tagPaths != nil {
+		p.stateMu.Lock()
+		switch {
+		case b == 0x1b:
+			_, _ = p.br.Discard(p.br.Buffered())
+			p.tagPaths = nil
+		case b == '\r' || b == '\n':
+			tags := label.ParseTags(p.tagText)
+			saved := make(map[string][]string, len(p.tagPaths))
+			var err error
+			for _, path := range p.tagPaths {
+				t := tags
+				if p.tagAdd {
+					t = append(label.Tags(path), tags...)
+				}
+				if err = label.SetTags(path, t); err != nil {
+					break
+				}
+				saved[path] = label.Tags(path)
+			}
+			for _, list := range [][]Candidate{p.all, p.cands} {
+				for i := range list {
+					if t, ok := saved[list[i].Path]; ok {
+						list[i].Tags = t
+					}
+				}
+			}
+			what := filepath.Base(p.tagPaths[0])
+			if n := len(p.tagPaths); p.tagAdd {
+				what = fmt.Sprintf("%d %s", n, ternary(n == 1, "file", "files"))
+			}
+			switch {
+			case err != nil:
+				p.flash("tags: " + err.Error())
+			case len(tags) == 0 && !p.tagAdd:
+				p.flash("removed the tags from " + what)
+			case len(tags) > 0:
+				p.flash(fmt.Sprintf("tagged %s: %s", what, strings.Join(tags, ", ")))
+			}
+			p.tagPaths = nil
+		case b == 0x7f || b == 0x08:
+			if p.tagText != "" {
+				_, n := utf8.DecodeLastRuneInString(p.tagText)
+				p.tagText = p.tagText[:len(p.tagText)-n]
+			}
+		case b == 0x15:
+			p.tagText = ""
+		case b >= 0x20:
+			p.tagText += string([]byte{b})
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+		return nil, false, nil
+	}
+	if p.markOp != 0 {
+		op := p.markOp
+		p.markOp = 0
+		p.awaitGG, p.count = false, 0
+		if b == 0x1b {
+			_, _ = p.br.Discard(p.br.Buffered())
+		}
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || op == '\'' && b == '\'') {
+			return nil, false, nil
+		}
+		p.stateMu.Lock()
+		if op == 'm' {
+			if len(p.cands) > 0 {
+				p.marks[b] = p.cands[p.cur].Path
+				p.flash(fmt.Sprintf("mark %c: %s", b, filepath.Base(p.cands[p.cur].Path)))
+			}
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			return nil, false, nil
+		}
+		path, ok := p.marks[b]
+		if !ok {
+			p.flash(fmt.Sprintf("mark %c is not set", b))
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			return nil, false, nil
+		}
+		i := indexOfPath(p.cands, path)
+		if j := indexOfPath(p.all, path); i < 0 && j >= 0 && p.collapsed[p.all[j].Group] {
+			delete(p.collapsed, p.all[j].Group)
+			p.refilter(path)
+			i = indexOfPath(p.cands, path)
+		}
+		from := ""
+		if len(p.cands) > 0 {
+			from = p.cands[p.cur].Path
+		}
+		switch {
+		case i >= 0:
+			p.marks['\''] = from
+			p.moveTo(i)
+		case p.opts.Browse != nil && indexOfPath(p.all, path) < 0 && filepath.Dir(path) != p.dir:
+			p.marks['\''] = from
+			p.query = ""
+			p.stateMu.Unlock()
+			p.browseTo(filepath.Dir(path), path)
+			return nil, false, nil
+		default:
+			p.flash(fmt.Sprintf("mark %c: %s is filtered out", b, filepath.Base(path)))
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		return nil, false, nil
+	}
+	if name, ok := p.expectKeys[b]; ok && b != 0x1b && !fromControl {
+		p.awaitGG, p.count = false, 0
+		if sel, ok, err := p.accept(name); ok {
+			return sel, true, err
+		}
+		return nil, false, nil
+	}
+	if k, ok := p.keymap[b]; ok && !fromControl {
+		b = k
+	}
+	if p.opts.Rate && b >= '0' && b <= '9' {
+		if b <= '0'+label.MaxRating {
+			p.stateMu.Lock()
+			p.rate(int(b - '0'))
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		}
+		p.awaitGG = false
+		return nil, false, nil
+	}
+	if b >= '1' && b <= '9' || b == '0' && p.count > 0 {
+		p.count = min(p.count*10+int(b-'0'), 1<<24)
+		return nil, false, nil
+	}
+	n, counted := max(p.count, 1), p.count > 0
+	if b != 'g' || p.awaitGG {
+		p.count = 0
+	}
+	switch b {
+	case 'q':
+		p.wipe()
+		return nil, true, ErrCanceled
+	case 0x03:
+		p.wipe()
+		return nil, true, ErrCanceled
+	case 0x1b:
+		if p.br.Buffered() == 0 && counted {
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		if p.br.Buffered() == 0 && p.visual {
+			p.stateMu.Lock()
+			p.visual = false
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		if p.br.Buffered() == 0 && p.highlight != "" {
+			p.stateMu.Lock()
+			p.highlight = ""
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		if p.br.Buffered() == 0 && p.query != "" {
+			p.stateMu.Lock()
+			keep := ""
+			if len(p.cands) > 0 {
+				keep = p.cands[p.cur].Path
+			}
+			p.query = ""
+			p.refilter(keep)
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		if p.br.Buffered() == 0 {
+			p.wipe()
+			return nil, true, ErrCanceled
+		}
+		next, _ := p.br.ReadByte()
+		if next == 'O' {
+			// SS3 function keys: F2 toggles the list; Home and End.
+			b3, _ := p.br.ReadByte()
+			if name, ok := p.expectFns[fkeys["O"+string(b3)]]; ok {
+				if sel, ok, err := p.accept(name); ok {
+					return sel, true, err
+				}
+				return nil, false, nil
+			}
+			switch b3 {
+			case 'Q':
+				p.toggleList()
+			case 'H', 'F':
+				p.stateMu.Lock()
+				p.rowEdge(b3 == 'F')
+				p.stateMu.Unlock()
+				p.requestRepaint()
+			}
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		if next == '[' {
+			b3, _ := p.br.ReadByte()
+			if b3 == '<' {
+				buf := make([]byte, 0, 32)
+				for {
+					x, err := p.br.ReadByte()
+					if err != nil {
+						break
+					}
+					buf = append(buf, x)
+					if x == 'M' || x == 'm' {
+						break
+					}
+				}
+				s := string(buf)
+				parts := strings.Split(strings.TrimRight(s, "Mm"), ";")
+				if len(parts) == 3 && parts[0] != "" {
+					btn, _ := strconv.Atoi(parts[0])
+					cx, _ := strconv.Atoi(parts[1])
+					cy, _ := strconv.Atoi(parts[2])
+					p.stateMu.Lock()
+					cy -= p.originY - 1
+					if strings.HasSuffix(s, "m") {
+						p.dragFrom, p.dragBase = -1, nil
+					}
+					gridX, gridY, _, _, _, tileH, _, _, gutter := p.computeLayout()
+					grouped := p.anyGroups && !p.listView
+					onMore := p.truncated && cy == p.contentY+p.contentH-1
+					p.stateMu.Unlock()
+					if onMore && btn == 0 {
+						if strings.HasSuffix(s, "M") {
+							p.loadMore()
+						}
+						p.awaitGG = false
+						return nil, false, nil
+					}
+					// A click on a group header folds or unfolds it.
+					if stepH := tileH + gutter; grouped && btn == 0 && strings.HasSuffix(s, "M") && cy >= gridY-1 && (cy-gridY+1)%stepH == 0 {
+						p.stateMu.Lock()
+						if g, ok := p.groupAt(p.topRow + (cy-gridY+1)/stepH); ok {
+							p.toggleGroup(g)
+						}
+						p.stateMu.Unlock()
+						p.requestRepaint()
+						p.awaitGG = false
+						return nil, false, nil
+					}
+					if cx >= gridX && cy >= gridY {
+						offX := cx - gridX
+						offY := cy - gridY
+						stepH := tileH + gutter
+						rrow := offY / stepH
+
+						if btn >= 64 && btn <= 67 && (p.opts.WheelMovesCursor || btn >= 66) {
+							p.stateMu.Lock()
+							switch btn {
+							case 64:
+								p.moveRows(-1)
+							case 65:
+								p.moveRows(1)
+							case 66:
+								p.moveCols(-1)
+							case 67:
+								p.moveCols(1)
+							}
+							p.stateMu.Unlock()
+							p.requestRepaint()
+							p.awaitGG = false
+							return nil, false, nil
+						}
+						if btn == 64 {
+							p.stateMu.Lock()
+							if p.topRow > 0 {
+								p.topRow--
+							}
+							p.stateMu.Unlock()
+							p.requestRepaint()
+							p.awaitGG = false
+							return nil, false, nil
+						}
+						if btn == 65 {
+							p.stateMu.Lock()
+							_, _, _, _, _, _, _, r, _ := p.computeLayout()
+							maxTop := max(0, p.dataRows()-r)
+							if p.topRow < maxTop {
+								p.topRow++
+							}
+							p.stateMu.Unlock()
+							p.requestRepaint()
+							p.awaitGG = false
+							return nil, false, nil
+						}
+						if rrow >= 0 {
+							py := gridY + rrow*stepH
+							if cy <= py+tileH-1 {
+								p.stateMu.Lock()
+								idx := -1
+								if rr := p.topRow + rrow; rr < p.dataRows() {
+									for k := 0; k < p.rowLen(rr); k++ {
+										if x, tw := p.tileAt(p.rowTable()[rr]+k, k); offX >= x && offX < x+tw {
+											idx = p.rowTable()[rr] + k
+											break
+										}
+									}
+								}
+								p.stateMu.Unlock()
+								if idx >= 0 {
+									switch {
+									case btn == 4 && strings.HasSuffix(s, "M"):
+										// Shift-click extends from the last clicked tile.
+										p.stateMu.Lock()
+										if i := indexOfPath(p.cands, p.lastClick); i >= 0 {
+											p.selectRange(i, idx)
+										}
+										p.lastClick = p.cands[idx].Path
+										p.moveTo(idx)
+										p.stateMu.Unlock()
+										p.requestRepaint()
+									case btn == 0 && strings.HasSuffix(s, "M"):
+										p.stateMu.Lock()
+										double := p.lastClick == p.cands[idx].Path && time.Since(p.lastPress) < doubleClick
+										p.lastClick, p.lastPress = p.cands[idx].Path, time.Now()
+										p.dragFrom, p.dragBase = idx, nil
+										p.moveTo(idx)
+										if double {
+											p.lastPress, p.dragFrom = time.Time{}, -1
+										}
+										p.stateMu.Unlock()
+										if double {
+											if sel, ok, err := p.accept(""); ok {
+												return sel, true, err
+											}
+										}
+										p.requestRepaint()
+									case btn == 1 && strings.HasSuffix(s, "M"):
+										p.stateMu.Lock()
+										if path := p.cands[idx].Path; p.selected[path] {
+											delete(p.selected, path)
+										} else {
+											p.selected[path] = true
+										}
+										p.moveTo(idx)
+										p.stateMu.Unlock()
+										p.requestRepaint()
+									case btn == 32:
+										p.stateMu.Lock()
+										if p.dragFrom >= 0 && p.dragFrom < len(p.cands) && (idx != p.dragFrom || p.dragBase != nil) {
+											if p.dragBase == nil {
+												p.dragBase = maps.Clone(p.selected)
+											}
+											clear(p.selected)
+											maps.Copy(p.selected, p.dragBase)
+											p.selectRect(p.dragFrom, idx)
+										}
+										p.moveTo(idx)
+										p.stateMu.Unlock()
+										p.requestRepaint()
+									case btn < 64:
+										p.stateMu.Lock()
+										p.moveTo(idx)
+										p.stateMu.Unlock()
+										p.requestRepaint()
+									}
+								}
+							}
+						}
+					}
+				}
+				p.awaitGG = false
+				return nil, false, nil
+			}
+			switch b3 {
+			case '1', '2', '4', '7', '8':
+				// F2 as CSI 12~, Home and End as 1~/7~ and 4~/8~,
+				// function keys listed in Expect; anything else (e.g.
+				// modified arrows) is read to its end and dropped.
+				seq := []byte{b3}
+				for {
+					x, err := p.br.ReadByte()
+					if err != nil {
+						break
+					}
+					seq = append(seq, x)
+					if x >= 0x40 && x <= 0x7e {
+						break
+					}
+				}
+				if name, ok := p.expectFns[fkeys["["+string(seq)]]; ok {
+					if sel, ok, err := p.accept(name); ok {
+						return sel, true, err
+					}
+					return nil, false, nil
+				}
+				switch string(seq) {
+				case "12~":
+					p.toggleList()
+				case "1~", "7~", "4~", "8~":
+					p.stateMu.Lock()
+					p.rowEdge(seq[0] == '4' || seq[0] == '8')
+					p.stateMu.Unlock()
+				}
+			case 'H', 'F':
+				p.stateMu.Lock()
+				p.rowEdge(b3 == 'F')
+				p.stateMu.Unlock()
+			case 'A':
+				p.stateMu.Lock()
+				p.moveRows(-n)
+				p.stateMu.Unlock()
+			case 'B':
+				p.stateMu.Lock()
+				p.moveRows(n)
+				p.stateMu.Unlock()
+			case 'C':
+				p.stateMu.Lock()
+				p.moveCols(n)
+				p.stateMu.Unlock()
+			case 'D':
+				p.stateMu.Lock()
+				p.moveCols(-n)
+				p.stateMu.Unlock()
+			case '5':
+				p.stateMu.Lock()
+				_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+				p.moveRows(-rows * n)
+				p.stateMu.Unlock()
+				_, _ = p.br.ReadByte()
+			case '6':
+				p.stateMu.Lock()
+				_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+				p.moveRows(rows * n)
+				p.stateMu.Unlock()
+				_, _ = p.br.ReadByte()
+			}
+			p.requestRepaint()
+			p.awaitGG = false
+			return nil, false, nil
+		}
+		p.wipe()
+		return nil, true, ErrCanceled
+	case 0x0c:
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x05:
+		p.stateMu.Lock()
+		_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+		p.topRow = max(p.topRow, min(p.topRow+n, p.dataRows()-rows))
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x19:
+		p.stateMu.Lock()
+		p.topRow = max(0, p.topRow-n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x04:
+		p.stateMu.Lock()
+		_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+		delta := max(1, rows/2)
+		maxTop := max(0, p.dataRows()-rows)
+		p.topRow += delta
+		if p.topRow > maxTop {
+			p.topRow = maxTop
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x15:
+		p.stateMu.Lock()
+		_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+		delta := max(1, rows/2)
+		p.topRow -= delta
+		if p.topRow < 0 {
+			p.topRow = 0
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x06:
+		p.stateMu.Lock()
+		_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+		p.moveRows(rows * n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x02:
+		p.stateMu.Lock()
+		_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+		p.moveRows(-rows * n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'G':
+		p.stateMu.Lock()
+		p.moveTo(ternary(counted, n-1, len(p.cands)-1))
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'g':
+		if p.awaitGG {
+			p.stateMu.Lock()
+			p.moveTo(ternary(counted, n-1, 0))
+			if !counted {
+				p.topRow = 0
+			}
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			p.awaitGG = false
+		} else {
+			p.awaitGG = true
+		}
+	case 'k':
+		p.stateMu.Lock()
+		p.moveRows(-n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'j':
+		p.stateMu.Lock()
+		p.moveRows(n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'h':
+		p.stateMu.Lock()
+		p.moveCols(-n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'l':
+		p.stateMu.Lock()
+		p.moveCols(n)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case '+', '=', '-', '_':
+		p.stateMu.Lock()
+		if b == '+' || b == '=' {
+			p.zoom++
+		} else {
+			p.zoom = max(0, p.zoom-1)
+		}
+		z := p.zoom
+		p.stateMu.Unlock()
+		if p.opts.OnZoom != nil {
+			p.opts.OnZoom(z)
+		}
+		p.requestRepaint()
+		p.awaitGG = false
+	case '/':
+		p.stateMu.Lock()
+		p.searching = true
+		p.searchFrom, p.highlight = "", ""
+		if len(p.cands) > 0 {
+			p.searchFrom = p.cands[p.cur].Path
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'i':
+		p.toggleList()
+		p.awaitGG = false
+	case 'I':
+		p.stateMu.Lock()
+		p.showInfo = !p.showInfo
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'M':
+		p.loadMore()
+		p.awaitGG = false
+	case 'p':
+		p.stateMu.Lock()
+		p.showImages = !p.showImages
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'o', '\t':
+		// The preview opens at once; its dimensions and length follow
+		// when the file is probed.
+		p.stateMu.Lock()
+		if len(p.cands) > 0 {
+			c := p.cands[p.cur]
+			p.previewing, p.previewInfo = true, meta.Info{}
+			p.thumbWG.Add(1)
+			go func() {
+				defer p.thumbWG.Done()
+				defer p.guard()
+				info, _ := meta.Probe(c.Path, c.Kind)
+				p.stateMu.Lock()
+				if p.previewing && len(p.cands) > 0 && p.cands[p.cur].Path == c.Path {
+					p.previewInfo = info
+				}
+				p.stateMu.Unlock()
+				p.requestRepaint()
+			}()
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case ' ':
+		p.stateMu.Lock()
+		if len(p.cands) > 0 {
+			path := p.cands[p.cur].Path
+			if p.selected[path] {
+				delete(p.selected, path)
+			} else {
+				p.selected[path] = true
+			}
+			if p.cur+1 < len(p.cands) {
+				p.moveTo(p.cur + 1)
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'a':
+		p.stateMu.Lock()
+		for _, c := range p.cands {
+			p.selected[c.Path] = true
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'A', '*':
+		p.stateMu.Lock()
+		for _, c := range p.cands {
+			if p.selected[c.Path] {
+				delete(p.selected, c.Path)
+			} else {
+				p.selected[c.Path] = true
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'u':
+		p.stateMu.Lock()
+		clear(p.selected)
+		p.visual = false
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'd':
+		p.stateMu.Lock()
+		if p.opts.AllowDelete {
+			// As with Enter, a visual range joins the selection.
+			if p.visual {
+				p.selectRange(p.anchor, p.cur)
+				p.visual = false
+			}
+			var paths []string
+			for _, s := range p.selection() {
+				paths = append(paths, s.Path)
+			}
+			if len(p.selected) == 0 && len(p.cands) > 0 {
+				paths = []string{p.cands[p.cur].Path}
+			}
+			// Nothing to trash asks nothing.
+			if len(paths) > 0 {
+				p.confirmTrash = paths
+			}
+		} else {
+			p.flash("d is off; run with -allow-delete to trash files")
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'r':
+		// A count of 1 to 5 before r rates the selection, or the
+		// current item; r alone clears the rating.
+		p.stateMu.Lock()
+		p.rate(ternary(counted, n, 0))
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 't':
+		p.stateMu.Lock()
+		p.tagPaths, p.tagText, p.tagAdd = nil, "", false
+		for _, s := range p.selection() {
+			if s.Kind != "dir" {
+				p.tagPaths = append(p.tagPaths, s.Path)
+			}
+		}
+		if len(p.tagPaths) > 0 {
+			p.tagAdd = true
+		} else if len(p.cands) > 0 && p.cands[p.cur].Kind != "dir" {
+			p.tagPaths = []string{p.cands[p.cur].Path}
+			// Read afresh: under LazyLabels they may not be in yet.
+			p.tagText = strings.Join(label.Tags(p.cands[p.cur].Path), ", ")
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'y':
+		p.stateMu.Lock()
+		var paths []string
+		for _, s := range p.selection() {
+			paths = append(paths, s.Path)
+		}
+		if len(paths) == 0 && len(p.cands) > 0 {
+			paths = []string{p.cands[p.cur].Path}
+		}
+		p.stateMu.Unlock()
+		p.awaitGG = false
+		if len(paths) == 0 {
+			return nil, false, nil
+		}
+		for i, p := range paths {
+			if abs, err := filepath.Abs(p); err == nil {
+				paths[i] = abs
+			}
+		}
+		msg := fmt.Sprintf("copied %d %s", len(paths), ternary(len(paths) == 1, "path", "paths"))
+		if err := term.SetClipboard(strings.Join(paths, "\n")); err != nil {
+			msg = "clipboard: " + err.Error()
+		}
+		p.stateMu.Lock()
+		p.flash(msg)
+		p.stateMu.Unlock()
+		p.requestRepaint()
+	case 's', 'S':
+		p.stateMu.Lock()
+		if len(p.opts.Sorts) > 0 {
+			if b == 's' {
+				p.sortIdx, p.reversed = (p.sortIdx+1)%len(p.opts.Sorts), false
+			} else {
+				p.reversed = !p.reversed
+			}
+			p.resort()
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 0x7f, 0x08:
+		p.awaitGG = false
+		if p.opts.Browse == nil {
+			return nil, false, nil
+		}
+		p.stateMu.Lock()
+		from, up := p.dir, filepath.Dir(p.dir)
+		p.query = ""
+		p.stateMu.Unlock()
+		if up != from {
+			p.browseTo(up, from)
+		}
+	case 'n', 'N':
+		p.stateMu.Lock()
+		switch {
+		case p.highlight == "":
+			p.flash("no search; / starts one")
+		case len(p.cands) > 0:
+			i := p.cur
+			for k := 0; k < n && i >= 0; k++ {
+				i = nextMatch(p.cands, p.highlight, i, ternary(b == 'n', 1, -1))
+			}
+			if i < 0 {
+				p.flash("no matches for " + p.highlight)
+			} else {
+				p.moveTo(i)
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case '0', '$':
+		p.stateMu.Lock()
+		p.rowEdge(b == '$')
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'H', 'L':
+		p.stateMu.Lock()
+		if len(p.cands) > 0 {
+			_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+			if b == 'H' {
+				p.moveTo(p.rowTable()[p.topRow])
+			} else {
+				r := min(p.topRow+rows, p.dataRows()) - 1
+				p.moveTo(p.rowTable()[r] + p.rowLen(r) - 1)
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'm', '\'':
+		p.markOp = b
+		p.awaitGG = false
+	case 'z':
+		p.stateMu.Lock()
+		if len(p.cands) > 0 && p.cands[p.cur].Group != "" {
+			p.toggleGroup(p.cands[p.cur].Group)
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case '[', ']':
+		// Jump to the start of the next group, or of this one and then
+		// the one before.
+		p.stateMu.Lock()
+		if p.anyGroups && len(p.cands) > 0 {
+			start := func(r int) int {
+				for r > 0 {
+					if _, ok := p.groupAt(r); ok {
+						break
+					}
+					r--
+				}
+				return r
+			}
+			r := p.curRow()
+			if b == ']' {
+				for r++; r < p.dataRows(); r++ {
+					if _, ok := p.groupAt(r); ok {
+						p.moveTo(p.rowTable()[r])
+						break
+					}
+				}
+			} else {
+				s := start(r)
+				if p.rowTable()[s] == p.cur && s > 0 {
+					s = start(s - 1)
+				}
+				p.moveTo(p.rowTable()[s])
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'Z':
+		// Fold every group, or unfold them all if any is folded.
+		p.stateMu.Lock()
+		if p.anyGroups {
+			keep := ""
+			if len(p.cands) > 0 {
+				keep = p.cands[p.cur].Path
+			}
+			if len(p.collapsed) > 0 {
+				clear(p.collapsed)
+			} else {
+				for _, c := range p.all {
+					if c.Group != "" {
+						p.collapsed[c.Group] = true
+					}
+				}
+			}
+			g := ""
+			if len(p.cands) > 0 {
+				g = p.cands[p.cur].Group
+			}
+			p.refilter(keep)
+			if indexOfPath(p.cands, keep) < 0 {
+				if i := slices.IndexFunc(p.cands, func(c Candidate) bool { return c.Group == g }); i >= 0 {
+					p.moveTo(i)
+				}
+			}
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case '.':
+		p.stateMu.Lock()
+		p.showHidden = !p.showHidden
+		keep := ""
+		if len(p.cands) > 0 {
+			keep = p.cands[p.cur].Path
+		}
+		rescan := p.opts.Rescan != nil && p.opts.Browse == nil
+		if !rescan {
+			p.refilter(keep)
+		}
+		p.flash(ternary(p.showHidden, "showing hidden files", "hiding hidden files"))
+		d := p.dir
+		p.stateMu.Unlock()
+		if rescan {
+			p.browseTo(d, keep)
+		}
+		p.requestRepaint()
+		p.awaitGG = false
+	case 'x':
+		p.stateMu.Lock()
+		path := ""
+		if len(p.cands) > 0 {
+			path = p.cands[p.cur].Path
+		}
+		p.stateMu.Unlock()
+		p.awaitGG = false
+		if path == "" {
+			return nil, false, nil
+		}
+		// Hand the terminal over: the input reader would steal the
+		// viewer's keys, and the render loop waits on term.Lock.
+		p.input.Close()
+		for range p.input.ch {
+		}
+		term.Lock()
+		if p.sched != nil {
+			p.sched.NextFrame()
+		}
+		if p.renderer != nil {
+			_ = p.renderer.ClearAll()
+		}
+		fmt.Fprint(p.out, p.clearScreen())
+		p.leave()
+		_ = xt.Restore(p.fdIn, p.old)
+		cmd := openCommand(p.opts.OpenCmd, path)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = p.in, p.out, os.Stderr
+		err := cmd.Run()
+		_, _ = xt.MakeRaw(p.fdIn)
+		top := p.enter()
+		p.drawnFrame = frameState{}
+		term.Unlock()
+		p.stateMu.Lock()
+		p.originY = top
+		p.stateMu.Unlock()
+		p.input = startInput(p.in)
+		p.br.Reset(p.input)
+		if err != nil {
+			p.stateMu.Lock()
+			p.flash("open: " + err.Error())
+			p.stateMu.Unlock()
+		}
+		p.requestRepaint()
+	case 'V':
+		p.stateMu.Lock()
+		if p.visual {
+			p.selectRange(p.anchor, p.cur)
+			p.visual = false
+		} else if len(p.cands) > 0 {
+			p.visual, p.anchor = true, p.cur
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+		p.awaitGG = false
+	case '\r', '\n':
+		if sel, ok, err := p.accept(""); ok {
+			return sel, true, err
+		}
+	default:
+		p.awaitGG = false
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,123 @@
+package picker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var actionKeys = map[string]byte{
+	"up":              'k',
+	"down":            'j',
+	"left":            'h',
+	"right":           'l',
+	"row_start":       '0',
+	"row_end":         '$',
+	"first_visible":   'H',
+	"last_visible":    'L',
+	"page_up":         0x02,
+	"page_down":       0x06,
+	"half_page_up":    0x15,
+	"half_page_down":  0x04,
+	"scroll_up":       0x19,
+	"scroll_down":     0x05,
+	"bottom":          'G',
+	"zoom_in":         '+',
+	"zoom_out":        '-',
+	"toggle_previews": 'p',
+	"toggle_list":     'i',
+	"toggle_info":     'I',
+	"load_more":       'M',
+	"toggle_hidden":   '.',
+	"toggle_group":    'z',
+	"toggle_groups":   'Z',
+	"prev_group":      '[',
+	"next_group":      ']',
+	"mark":            'm',
+	"jump_mark":       '\'',
+	"preview":         'o',
+	"open":            'x',
+	"sort":            's',
+	"reverse_sort":    'S',
+	"copy_path":       'y',
+	"rate":            'r',
+	"tag":             't',
+	"delete":          'd',
+	"search":          '/',
+	"next_match":      'n',
+	"prev_match":      'N',
+	"toggle_select":   ' ',
+	"select_all":      'a',
+	"invert_select":   'A',
+	"clear_select":    'u',
+	"visual":          'V',
+	"accept":          '\r',
+	"parent":          0x7f,
+	"cancel":          'q',
+	"redraw":          0x0c,
+}
+
+func parseKeymap(m map[string]string) (map[byte]byte, error) {
+	out := make(map[byte]byte, len(m))
+	for action, key := range m {
+		def, ok := actionKeys[action]
+		if !ok {
+			return nil, fmt.Errorf("keys: unknown action %q", action)
+		}
+		b, err := parseKeyName(key)
+		if err != nil {
+			return nil, fmt.Errorf("keys.%s: %w", action, err)
+		}
+		out[b] = def
+	}
+	return out, nil
+}
+
+func parseKeyName(s string) (byte, error) {
+	l := strings.ToLower(s)
+	switch l {
+	case "enter", "return":
+		return '\r', nil
+	case "space":
+		return ' ', nil
+	case "tab":
+		return '\t', nil
+	case "backspace":
+		return 0x7f, nil
+	}
+	if c, ok := strings.CutPrefix(l, "ctrl-"); ok && len(c) == 1 && c[0] >= 'a' && c[0] <= 'z' {
+		return c[0] & 0x1f, nil
+	}
+	if len(s) == 1 && s[0] >= 0x20 && s[0] < 0x7f {
+		return s[0], nil
+	}
+	return 0, fmt.Errorf("unsupported key %q", s)
+}
+
+// fkeys names function keys by what follows ESC: SS3 for F1-F4 as xterm
+// sends them, CSI ~ sequences otherwise.
+var fkeys = map[string]string{
+	"OP": "f1", "OQ": "f2", "OR": "f3", "OS": "f4",
+	"[11~": "f1", "[12~": "f2", "[13~": "f3", "[14~": "f4",
+	"[15~": "f5", "[17~": "f6", "[18~": "f7", "[19~": "f8",
+	"[20~": "f9", "[21~": "f10", "[23~": "f11", "[24~": "f12",
+}
+
+// parseExpect splits Options.Expect into plain keys and function keys,
+// both mapped to the name the caller gave.
+func parseExpect(names []string) (map[byte]string, map[string]string, error) {
+	keys, fns := make(map[byte]string), make(map[string]string)
+	for _, name := range names {
+		l := strings.ToLower(strings.TrimSpace(name))
+		if n, err := strconv.Atoi(strings.TrimPrefix(l, "f")); err == nil && l[0] == 'f' && n >= 1 && n <= 12 {
+			fns[l] = name
+			continue
+		}
+		b, err := parseKeyName(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expect: %w", err)
+		}
+		keys[b] = name
+	}
+	return keys, fns, nil
+}
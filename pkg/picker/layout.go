@@ -0,0 +1,274 @@
+package picker
+
+import (
+	"sort"
+
+	"github.com/ck-zhang/thumbgrid/internal/term"
+	xt "golang.org/x/term"
+)
+
+// clampTile keeps a tile big enough to hold a name and a border.
+func (p *picker) clampTile(wd, ht int) (int, int) {
+	if wd < 8 {
+		wd = 8
+	}
+	if ht < 3 {
+		ht = 3
+	}
+	return wd, ht
+}
+
+// computeLayout places the grid; gutter is the space between tiles.
+func (p *picker) computeLayout() (gridX, gridY, gridW, gridH, tileW, tileH, cols, rows, gutter int) {
+	gridX, gridY = 1, p.contentY
+	gridW, gridH = p.w, p.contentH
+	if p.truncated {
+		// The last line is the row that loads more.
+		gridH = max(0, gridH-1)
+	}
+	if p.showInfo && p.w >= infoPanelW+minGridW {
+		gridW = p.w - infoPanelW
+	}
+	if p.listView {
+		// One line per row under the column titles, with no gutter.
+		gridY, gridH = gridY+1, max(0, gridH-1)
+		return gridX, gridY, gridW, gridH, gridW, 1, 1, gridH, 0
+	}
+	gutter = 2
+	if p.anyGroups {
+		gridY, gridH = gridY+1, gridH-1
+	}
+
+	tileW = p.baseTileW + p.zoom*4
+	tileH = p.baseTileH + p.zoom*2
+	if p.opts.Columns > 0 {
+		tileW = (gridW+gutter)/p.opts.Columns - gutter
+	}
+	tileW, tileH = p.clampTile(tileW, tileH)
+
+	stepW := tileW + gutter
+	if p.opts.Justified {
+		// The most of the narrowest tiles a row can hold.
+		stepW = 8 + gutter
+	}
+	if gridW < tileW {
+		cols = 1
+	} else {
+		cols = (gridW + gutter) / stepW
+	}
+	if p.opts.Columns > 0 {
+		cols = min(cols, p.opts.Columns)
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	stepH := tileH + gutter
+
+	if gridH < tileH {
+		rows = 0
+	} else {
+		rows = 1 + (gridH-tileH)/stepH
+	}
+	return
+}
+
+// rowTable returns rowStart, rebuilding it when the layout changed.
+func (p *picker) rowTable() []int {
+	_, _, gridW, _, tileW, tileH, cols, _, gutter := p.computeLayout()
+	l := rowLayout{p.rowGen, gridW, tileW, tileH, cols}
+	if l == p.built {
+		return p.rowStart
+	}
+	p.built = l
+	p.rowStart = p.rowStart[:0]
+	if !p.opts.Justified || p.listView {
+		for i := 0; i < len(p.cands); {
+			p.rowStart = append(p.rowStart, i)
+			n := 1
+			for i+n < len(p.cands) && n < cols && p.cands[i+n].Group == p.cands[i].Group {
+				n++
+			}
+			i += n
+		}
+		return p.rowStart
+	}
+	// A tile is as wide as its image at the tile's height, plus borders.
+	natural := func(c Candidate) int {
+		if c.Width <= 0 || c.Height <= 0 {
+			return tileW
+		}
+		imgW := float64(max(1, tileH-3)*p.ppcY) * float64(c.Width) / float64(c.Height) / float64(p.ppcX)
+		return min(max(8, int(imgW+0.5)+2), gridW)
+	}
+	p.tileX, p.tileWs = make([]int, len(p.cands)), make([]int, len(p.cands))
+	for i := 0; i < len(p.cands); {
+		p.rowStart = append(p.rowStart, i)
+		used := natural(p.cands[i])
+		p.tileWs[i] = used
+		n := 1
+		for i+n < len(p.cands) && n < cols && p.cands[i+n].Group == p.cands[i].Group {
+			nw := natural(p.cands[i+n])
+			if used+gutter+nw > gridW {
+				break
+			}
+			p.tileWs[i+n] = nw
+			used += gutter + nw
+			n++
+		}
+		// Stretch rows that ended for lack of room; a group's last row
+		// keeps its natural widths.
+		full := i+n < len(p.cands) && p.cands[i+n].Group == p.cands[i].Group
+		spare := ternary(full, gridW-used, 0)
+		x := 0
+		for k := 0; k < n; k++ {
+			extra := spare / (n - k)
+			spare -= extra
+			p.tileWs[i+k] += extra
+			p.tileX[i+k] = x
+			x += p.tileWs[i+k] + gutter
+		}
+		i += n
+	}
+	return p.rowStart
+}
+
+// tileAt gives the column offset and width of candidate idx's tile.
+func (p *picker) tileAt(idx, col int) (int, int) {
+	_, _, _, _, tileW, _, _, _, gutter := p.computeLayout()
+	p.rowTable()
+	if !p.opts.Justified || p.listView || idx < 0 || idx >= len(p.tileWs) {
+		return col * (tileW + gutter), tileW
+	}
+	return p.tileX[idx], p.tileWs[idx]
+}
+
+// rowOf is the grid row idx is on.
+func (p *picker) rowOf(idx int) int {
+	t := p.rowTable()
+	return sort.Search(len(t), func(r int) bool { return t[r] > idx }) - 1
+}
+
+// rowLen is the number of candidates on row r.
+func (p *picker) rowLen(r int) int {
+	t := p.rowTable()
+	if r+1 < len(t) {
+		return t[r+1] - t[r]
+	}
+	return len(p.cands) - t[r]
+}
+
+// cell is the candidate in column col of row r, or the row's last one
+// when the row is shorter.
+func (p *picker) cell(r, col int) int {
+	return p.rowTable()[r] + min(col, p.rowLen(r)-1)
+}
+
+// groupAt returns the group row r starts, if it starts one.
+func (p *picker) groupAt(r int) (string, bool) {
+	t := p.rowTable()
+	if r < 0 || r >= len(t) || p.cands[t[r]].Group == "" {
+		return "", false
+	}
+	g := p.cands[t[r]].Group
+	return g, r == 0 || p.cands[t[r-1]].Group != g
+}
+
+// dataRows is the number of grid rows.
+func (p *picker) dataRows() int { return len(p.rowTable()) }
+
+// curRow is the cursor's row, and curCol its column in it.
+func (p *picker) curRow() int { return p.rowOf(p.cur) }
+func (p *picker) curCol() int { return p.cur - p.rowTable()[p.curRow()] }
+
+// moveTo puts the cursor on ncur, scrolling to keep it on screen.
+func (p *picker) moveTo(ncur int) {
+	if len(p.cands) == 0 {
+		p.cur, p.topRow = 0, 0
+		return
+	}
+	if ncur < 0 {
+		ncur = 0
+	}
+	if ncur >= len(p.cands) {
+		ncur = len(p.cands) - 1
+	}
+	p.cur = ncur
+	r := p.curRow()
+	if r < p.topRow {
+		p.topRow = r
+	}
+	_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+	if r >= p.topRow+rows {
+		p.topRow = r - rows + 1
+	}
+	if p.topRow < 0 {
+		p.topRow = 0
+	}
+	maxTop := max(0, p.dataRows()-rows)
+	if p.topRow > maxTop {
+		p.topRow = maxTop
+	}
+}
+
+// moveRows moves n rows down (up when negative), keeping the column
+// where the row is long enough; moveCols stays within the row.
+func (p *picker) moveRows(n int) {
+	if len(p.cands) > 0 {
+		p.moveTo(p.cell(min(max(p.curRow()+n, 0), p.dataRows()-1), p.curCol()))
+	}
+}
+
+// moveCols moves the cursor n tiles along its row.
+func (p *picker) moveCols(n int) {
+	if len(p.cands) > 0 {
+		r := p.curRow()
+		p.moveTo(p.rowTable()[r] + min(max(p.curCol()+n, 0), p.rowLen(r)-1))
+	}
+}
+
+// rowEdge moves to the first or last tile of the cursor's row.
+func (p *picker) rowEdge(end bool) {
+	if len(p.cands) > 0 {
+		r := p.curRow()
+		p.moveTo(p.rowTable()[r] + ternary(end, p.rowLen(r)-1, 0))
+	}
+}
+
+// followResize takes up the terminal's new size whenever it changes.
+func (p *picker) followResize() {
+	defer p.guard()
+	for {
+		select {
+		case <-p.quitRender:
+			return
+		case <-p.winch:
+		}
+		w2, h2, _ := xt.GetSize(int(p.out.Fd()))
+		p.stateMu.Lock()
+		if h2 > 0 {
+			p.h = h2
+		} else {
+			p.h = 24
+		}
+		if p.inline {
+			// Keep the inline area on screen at its new height.
+			p.inlineH, _ = parseHeight(p.opts.Height, p.h)
+			p.originY = max(1, min(p.originY, p.h-p.inlineH+1))
+			p.h = p.inlineH
+		}
+		if w2 > 0 {
+			p.w = w2
+		} else {
+			p.w = 80
+		}
+		p.contentH = p.h - p.headerH - p.footerH
+		if p.contentH < 0 {
+			p.contentH = 0
+		}
+		if cw, ch, ok := term.CellSizeFromWinsize(); ok {
+			p.ppcX, p.ppcY = cw, ch
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+	}
+}
@@ -0,0 +1,192 @@
+package picker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ck-zhang/thumbgrid/internal/term"
+)
+
+// testPicker returns a picker on an 80×32 screen holding cands, in the
+// state Run leaves it in, with what it draws going to a file.
+func testPicker(t *testing.T, opts Options, cands []Candidate) *picker {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+	opts.Out = out
+	p := newPicker(ctx, cands, opts)
+	p.w, p.h = 80, 32
+	p.contentH = p.h - p.headerH - p.footerH
+	p.renderer, _ = term.New("none")
+	p.plainBox, p.cursorBox, p.selectedBox = tileBoxes()
+	p.thumbCtx = ctx
+	p.thumbQ, p.colorQ = newThumbQueue(ctx), newThumbQueue(ctx)
+	p.addBatch(cands)
+	return p
+}
+
+// numbered returns n candidates in group g, w×h pixels each.
+func numbered(n int, g string, w, h int) []Candidate {
+	var out []Candidate
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s%02d.png", g, i)
+		out = append(out, Candidate{Path: "/p/" + name, Name: name, Kind: "image", Group: g, Width: w, Height: h})
+	}
+	return out
+}
+
+func TestComputeLayout(t *testing.T) {
+	type layout struct{ gridY, gridW, gridH, tileW, tileH, cols, rows int }
+	tests := []struct {
+		name  string
+		opts  Options
+		setup func(p *picker)
+		want  layout
+	}{
+		{"default", Options{}, nil, layout{2, 80, 30, 18, 6, 4, 4}},
+		{"zoomed", Options{Zoom: 1}, nil, layout{2, 80, 30, 22, 8, 3, 3}},
+		{"columns", Options{Columns: 3}, nil, layout{2, 80, 30, 25, 6, 3, 4}},
+		{"tile size", Options{TileWidth: 30, TileHeight: 10}, nil, layout{2, 80, 30, 30, 10, 2, 2}},
+		{"too small", Options{TileWidth: 2, TileHeight: 1}, nil, layout{2, 80, 30, 8, 3, 8, 6}},
+		{"justified", Options{Justified: true}, nil, layout{2, 80, 30, 18, 6, 8, 4}},
+		{"info panel", Options{}, func(p *picker) { p.showInfo = true }, layout{2, 44, 30, 18, 6, 2, 4}},
+		{"no room for info", Options{}, func(p *picker) { p.showInfo, p.w = true, 50 }, layout{2, 50, 30, 18, 6, 2, 4}},
+		{"truncated", Options{}, func(p *picker) { p.truncated = true }, layout{2, 80, 29, 18, 6, 4, 3}},
+		{"groups", Options{}, func(p *picker) { p.anyGroups = true }, layout{3, 80, 29, 18, 6, 4, 3}},
+		{"list", Options{}, func(p *picker) { p.listView = true }, layout{3, 80, 29, 80, 1, 1, 29}},
+		{"narrow", Options{}, func(p *picker) { p.w = 10 }, layout{2, 10, 30, 18, 6, 1, 4}},
+		{"short", Options{}, func(p *picker) { p.contentH = 4 }, layout{2, 80, 4, 18, 6, 4, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPicker(t, tt.opts, nil)
+			if tt.setup != nil {
+				tt.setup(p)
+			}
+			_, gridY, gridW, gridH, tileW, tileH, cols, rows, _ := p.computeLayout()
+			if got := (layout{gridY, gridW, gridH, tileW, tileH, cols, rows}); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		cands []Candidate
+		want  []int
+	}{
+		{"empty", nil, []int{}},
+		{"full rows", numbered(8, "", 0, 0), []int{0, 4}},
+		{"last row short", numbered(9, "", 0, 0), []int{0, 4, 8}},
+		{"groups start rows", append(numbered(5, "a", 0, 0), numbered(2, "b", 0, 0)...), []int{0, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPicker(t, Options{}, tt.cands)
+			if got := p.rowTable(); !slices.Equal(got, tt.want) {
+				t.Errorf("rowTable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	// Rows of 4, 1 and 3: the grid has a short row in the middle.
+	p := testPicker(t, Options{}, append(numbered(5, "a", 0, 0), numbered(3, "b", 0, 0)...))
+	tests := []struct {
+		name string
+		move func()
+		want int
+	}{
+		{"down into a short row", func() { p.moveTo(2); p.moveRows(1) }, 4},
+		{"down again", func() { p.moveTo(2); p.moveRows(2) }, 7},
+		{"right past the row", func() { p.moveTo(5); p.moveCols(5) }, 7},
+		{"left past the row", func() { p.moveTo(6); p.moveCols(-5) }, 5},
+		{"row end", func() { p.moveTo(1); p.rowEdge(true) }, 3},
+		{"row start", func() { p.moveTo(7); p.rowEdge(false) }, 5},
+		{"before the first", func() { p.moveTo(-3) }, 0},
+		{"past the last", func() { p.moveTo(99) }, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.move()
+			if p.cur != tt.want {
+				t.Errorf("cursor on %d, want %d", p.cur, tt.want)
+			}
+		})
+	}
+	if got := p.cell(1, 3); got != 4 {
+		t.Errorf("cell(1, 3) = %d, want the short row's only tile 4", got)
+	}
+}
+
+func TestJustifiedRows(t *testing.T) {
+	// Tiles are 6 cells high; with 10×20 pixel cells a 2:1 image is 12
+	// cells wide at 3 lines of image, 14 with its border.
+	wide := numbered(6, "", 200, 100)
+	tests := []struct {
+		name   string
+		cands  []Candidate
+		starts []int
+		x, w   []int
+	}{
+		{
+			// Five 14-wide tiles fill 78 of 80 cells; the 2 left over
+			// go to the last tiles. The last row keeps its widths.
+			name:   "stretched",
+			cands:  wide,
+			starts: []int{0, 5},
+			x:      []int{0, 16, 32, 48, 65, 0},
+			w:      []int{14, 14, 14, 15, 15, 14},
+		},
+		{
+			name:   "no size",
+			cands:  numbered(2, "", 0, 0),
+			starts: []int{0},
+			x:      []int{0, 20},
+			w:      []int{18, 18},
+		},
+		{
+			name:   "wider than the grid",
+			cands:  append(numbered(1, "", 10000, 100), wide[0]),
+			starts: []int{0, 1},
+			x:      []int{0, 0},
+			w:      []int{80, 14},
+		},
+		{
+			name:   "groups",
+			cands:  append(numbered(2, "a", 200, 100), numbered(1, "b", 200, 100)...),
+			starts: []int{0, 2},
+			x:      []int{0, 16, 0},
+			w:      []int{14, 14, 14},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPicker(t, Options{Justified: true}, tt.cands)
+			p.ppcX, p.ppcY = 10, 20
+			if got := p.rowTable(); !slices.Equal(got, tt.starts) {
+				t.Errorf("rowTable() = %v, want %v", got, tt.starts)
+			}
+			var x, w []int
+			for i := range p.cands {
+				tx, tw := p.tileAt(i, 0)
+				x, w = append(x, tx), append(w, tw)
+			}
+			if !slices.Equal(x, tt.x) || !slices.Equal(w, tt.w) {
+				t.Errorf("tiles at %v wide %v, want %v wide %v", x, w, tt.x, tt.w)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package picker
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+func defaultOpenCmd() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open"
+	case "windows":
+		return "explorer"
+	}
+	return "xdg-open"
+}
+
+// openCommand builds the viewer command for path. tmpl is split on spaces
+// and every "%s" in it is replaced by path; without one, path is appended.
+func openCommand(tmpl, path string) *exec.Cmd {
+	args := strings.Fields(tmpl)
+	if len(args) == 0 {
+		args = []string{defaultOpenCmd()}
+	}
+	found := false
+	for i, a := range args {
+		if strings.Contains(a, "%s") {
+			args[i] = strings.ReplaceAll(a, "%s", path)
+			found = true
+		}
+	}
+	if !found {
+		args = append(args, path)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
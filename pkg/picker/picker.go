@@ -0,0 +1,846 @@
+// Package picker is the thumbgrid grid UI as a library: it shows candidates
+// as thumbnail tiles on the terminal and returns what the user picked.
+package picker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/meta"
+	"github.com/ck-zhang/thumbgrid/internal/term"
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+	xt "golang.org/x/term"
+)
+
+// Candidate is one file shown in the grid. Kind is "image", "video" or
+// anything else, which is drawn as an extension icon.
+type Candidate struct {
+	Path string
+	Name string
+	// Size and MTime may be left zero for a file, e.g. when nothing sorts
+	// by them; the picker then looks them up for the items it shows.
+	Size  int64
+	MTime time.Time
+	Kind  string
+	// Width, Height and Duration (seconds) are zero unless the caller
+	// probed them, e.g. to sort by resolution.
+	Width, Height int
+	Duration      float64
+	// Rating is the stars (1-5) the file was given, 0 for none; r changes it.
+	Rating int
+	// Tags are the file's tags, which t edits.
+	Tags []string
+	// Hidden keeps the candidate out of the grid until . shows hidden files.
+	Hidden bool
+	// Group puts the candidate in a section under a header row; a run of
+	// candidates with the same Group forms one section, so Less should keep
+	// groups together.
+	Group string
+}
+
+// Selection is an accepted candidate; Index is its position in the picker's
+// full, sorted candidate list, or -1 for one selected in another directory
+// while browsing.
+type Selection struct {
+	Candidate
+	Index int
+}
+
+// State is where the picker was: the path under the cursor, the first grid
+// row on screen, the zoom level and the ordering, by its Sort name.
+type State struct {
+	Cursor   string
+	TopRow   int
+	Zoom     int
+	Sort     string
+	Reversed bool
+}
+
+// Sort is a named ordering the user can switch to with s.
+type Sort struct {
+	Name string
+	Less func(a, b Candidate) bool
+}
+
+// Command drives the picker from outside the terminal, e.g. from a control
+// socket. Name is an action from actionKeys, which presses its default key,
+// or one of filter (Arg images, videos or both), search (Arg the query),
+// select (Arg a path to move the cursor to) and current. Reply, when set,
+// hears how it went and, for current, the path under the cursor.
+type Command struct {
+	Name, Arg string
+	Reply     func(out string, err error)
+}
+
+// Batch is a chunk of candidates, or an error, delivered on Options.Source.
+// On Options.Updates a candidate replaces any with its path, and Removed
+// drops paths along with everything under them.
+type Batch struct {
+	Candidates []Candidate
+	Removed    []string
+	Err        error
+}
+
+type Options struct {
+	// In and Out default to os.Stdin and os.Stdout and must be a terminal.
+	In, Out *os.File
+	// Source streams more candidates while the picker is open; the picker
+	// stops scanning when it is closed.
+	Source <-chan Batch
+	// Limit, when above zero, stops reading Source after that many
+	// candidates, so a huge scan waits in the channel instead of in memory.
+	// A row under the grid then says the list is cut short and loads Limit
+	// more when clicked, as M does.
+	Limit int
+	// Jobs is how many thumbnails are made at once; 0 means half the CPUs,
+	// at least 2 and at most 8, leaving the rest to the terminal.
+	Jobs int
+	// Updates brings changes while the picker is open, e.g. from watching
+	// the scanned directory. The picker then stays open with nothing to
+	// show.
+	Updates <-chan Batch
+	// Less orders the grid; nil keeps arrival order.
+	Less func(a, b Candidate) bool
+	// Sorts, when set, are the orderings s cycles through, starting with
+	// the first, which replaces Less. S reverses the current one.
+	Sorts []Sort
+	// Backend is auto (or empty), kitty, sixel, iterm2, blocks or none.
+	Backend string
+	// Thumbnails renders tile images; nil uses a generator on the user
+	// cache directory.
+	Thumbnails *thumb.Generator
+
+	TileWidth  int
+	TileHeight int
+	// Columns fixes the number of tiles per row, sizing their width to fit.
+	Columns int
+	// Zoom is the starting +/- level; OnZoom hears about every change, e.g.
+	// to start there next time.
+	Zoom   int
+	OnZoom func(zoom int)
+	// Keys binds extra keys to actions (e.g. "down": "n"), Colors styles the
+	// border, cursor, header and status line.
+	Keys   map[string]string
+	Colors map[string]string
+	// OpenCmd shows the current item in an external viewer; "%s" stands for
+	// its path. Empty uses xdg-open, open or explorer.
+	OpenCmd string
+	// AllowDelete enables d, which moves files to the trash after asking.
+	AllowDelete bool
+	// Rate makes 1 to 5 rate the selection, or the current item, and 0
+	// clear the rating, in place of count prefixes.
+	Rate bool
+	// LazyLabels says Candidates arrive without their Rating and Tags; the
+	// picker then reads them for the files it shows.
+	LazyLabels bool
+	// Justified sizes each tile to its candidate's aspect ratio (Width and
+	// Height, when known) and stretches full rows edge to edge.
+	Justified bool
+	// Resume starts where an earlier run was, e.g. as heard by OnExit,
+	// taking the place of Zoom and Select. OnExit hears where the picker is
+	// when Run returns.
+	Resume *State
+	OnExit func(State)
+	// Expect lists more keys that accept, like fzf's --expect, e.g.
+	// "ctrl-o" or "f1". AcceptedBy hears which one did, as given, or ""
+	// for Enter, before Run returns the selection.
+	Expect     []string
+	AcceptedBy func(key string)
+	// SelectOne accepts the only candidate without asking when the scan
+	// ends with just one and no key has been pressed.
+	SelectOne bool
+	// Query starts the picker with this search already typed. Select puts
+	// the cursor on the candidate with this path once it arrives, unless a
+	// key was pressed first; paths are compared as absolute paths.
+	Query  string
+	Select string
+	// HighlightSearch makes / mark the matching tiles instead of hiding the
+	// rest, with n and N moving between them.
+	HighlightSearch bool
+	// WheelMovesCursor makes the mouse wheel move the cursor a row at a
+	// time instead of scrolling the view. The horizontal wheel always
+	// moves it sideways.
+	WheelMovesCursor bool
+	// ShowHidden starts with hidden candidates shown.
+	ShowHidden bool
+	// Rescan, when set, is how . shows or hides hidden files outside
+	// Browse: it starts a scan with them or without, whose candidates
+	// replace the grid's, and is abandoned by cancelling ctx. Without it
+	// . filters the Hidden candidates already read.
+	Rescan func(ctx context.Context, showHidden bool) <-chan Batch
+	// Browse lists a directory for a file-manager style grid: candidates of
+	// Kind "dir" are folder tiles that Enter opens, and Backspace goes up
+	// from the current directory, starting at Dir. With no Source the
+	// picker lists Dir itself. Listings are abandoned by cancelling ctx.
+	Browse func(ctx context.Context, dir string) <-chan Batch
+	Dir    string
+	// GroupTitle, when set, turns a Candidate's Group into its header.
+	GroupTitle func(group string) string
+	// Height, e.g. "15" or "40%", draws the grid inline in that many lines
+	// below the cursor instead of taking over the screen, and puts the
+	// cursor back when done. Empty is full screen.
+	Height string
+	// Messages shows each string it delivers in the status line for a few
+	// seconds, e.g. news from work the caller does in the background.
+	Messages <-chan string
+	// Control delivers commands to carry out as they arrive.
+	Control <-chan Command
+	// Accept, when set, runs on the accepted items before Run returns, e.g.
+	// to copy them somewhere; status shows its progress in the footer.
+	Accept func(sel []Selection, status func(string)) error
+}
+
+// enterScreen switches to the alternate screen with the cursor hidden and
+// mouse reporting on; leaveScreen undoes it.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l\x1b[?1000h\x1b[?1002h\x1b[?1006h"
+	leaveScreen = "\x1b[?1006l\x1b[?1002l\x1b[?1000l\x1b[?25h\x1b[?1049l"
+)
+
+// A video tile under a resting cursor cycles through hoverFrames frames.
+// Status line messages fade after noticeTimeout. Two clicks on a tile within
+// doubleClick accept it.
+const (
+	noticeTimeout = 4 * time.Second
+	doubleClick   = 400 * time.Millisecond
+	hoverDelay    = 500 * time.Millisecond
+	hoverInterval = 400 * time.Millisecond
+	hoverFrames   = 8
+)
+
+// The info panel takes infoPanelW columns on the right, when that leaves
+// the grid at least minGridW.
+const (
+	infoPanelW = 36
+	minGridW   = 24
+)
+
+var (
+	ErrCanceled     = errors.New("canceled")
+	ErrNoCandidates = errors.New("no candidates")
+)
+
+// Validate reports a bad backend, key bindings or colors without touching
+// the terminal.
+func (o Options) Validate() error {
+	switch strings.ToLower(strings.TrimSpace(o.Backend)) {
+	case "", "auto", "kitty", "sixel", "iterm2", "chafa", "blocks", "braille", "none":
+	default:
+		return fmt.Errorf("unknown backend %q (expected kitty, sixel, iterm2, chafa, blocks, braille, none or auto)", o.Backend)
+	}
+	if _, err := parseKeymap(o.Keys); err != nil {
+		return err
+	}
+	if _, err := parseHeight(o.Height, 24); err != nil {
+		return err
+	}
+	if _, _, err := parseExpect(o.Expect); err != nil {
+		return err
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("limit must not be negative")
+	}
+	if o.Jobs < 0 {
+		return fmt.Errorf("jobs must not be negative")
+	}
+	_, err := parseTheme(o.Colors)
+	return err
+}
+
+func defaultCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil && dir != "" {
+		return filepath.Join(dir, "thumbgrid")
+	}
+	return filepath.Join(os.TempDir(), "thumbgrid")
+}
+
+// picker is the state of one Run. It is guarded by stateMu, except where
+// a field says otherwise.
+type picker struct {
+	ctx        context.Context
+	initial    []Candidate
+	opts       Options
+	keymap     map[byte]byte
+	expectKeys map[byte]string
+	expectFns  map[string]string
+	th         theme
+	in         *os.File
+	out        *os.File
+	gen        *thumb.Generator
+	fdIn       int
+	old        *xt.State
+	restoreVT  func()
+	w          int
+	h          int
+
+	// An inline grid takes h lines from screen row originY down. A scroll
+	// region in origin mode makes every cursor move relative to it, so the
+	// rest of the code draws as if it had the screen to itself.
+	inlineH     int
+	inline      bool
+	originY     int
+	restoreRow  int
+	restoreCol  int
+	restoreOnce sync.Once
+	renderer    term.Renderer
+	plainBox    box
+	cursorBox   box
+	selectedBox box
+	syncBegin   string
+	syncEnd     string
+	useGraphics bool
+
+	// Over SSH the host is often shared and every image crosses the network;
+	// only render what is on screen.
+	remote     bool
+	sched      *term.Scheduler
+	all        []Candidate
+	cands      []Candidate
+	query      string
+	showHidden bool
+
+	// collapsed groups show only their first candidate; folded counts how
+	// many each one holds.
+	collapsed map[string]bool
+	folded    map[string]int
+	anyGroups bool
+
+	// listView shows one line of details per candidate instead of tiles.
+	listView bool
+
+	// anyHidden stays false until a hidden candidate arrives, so the common
+	// case keeps cands and all the same slice.
+	anyHidden bool
+	nHidden   int
+
+	// onlyKind, set by a filter command, hides the other kind of file.
+	onlyKind   string
+	searching  bool
+	searchFrom string
+
+	// highlight is the search that marks matches when opts.HighlightSearch
+	// is set; query then stays empty.
+	highlight string
+
+	// startAt is the path of opts.Select until the cursor gets there;
+	// seekMatch, likewise, is set until it reaches the first match of a
+	// highlighted opts.Query.
+	cwd       string
+	startAt   string
+	seekMatch bool
+	typed     bool
+	less      func(a Candidate, b Candidate) bool
+	sortIdx   int
+	reversed  bool
+	dir       string
+
+	// elsewhere keeps selected candidates of directories left behind.
+	elsewhere map[string]Candidate
+	scanning  bool
+	scanErr   error
+
+	// arrived holds the paths Updates brought while the scan still runs,
+	// so that the scan reaching those files later doesn't list them twice.
+	arrived map[string]bool
+
+	// truncated is set while Options.Limit holds back part of the scan;
+	// loaded counts the candidates read so far, and moreCh asks for more.
+	truncated bool
+	loaded    int
+	moreCh    chan struct{}
+	stateMu   sync.Mutex
+	cur       int
+	topRow    int
+
+	// selected holds marked paths; marks survive filtering. In visual mode
+	// everything between anchor and the cursor counts as selected too, and
+	// leaving with V keeps it.
+	selected  map[string]bool
+	visual    bool
+	anchor    int
+	lastClick string
+	lastPress time.Time
+
+	// dragFrom is the tile a left-button drag started on, and dragBase the
+	// selection from before it; the drag adds the rectangle it sweeps.
+	dragFrom int
+	dragBase map[string]bool
+	awaitGG  bool
+
+	// count is a pending numeric prefix, as in vim's 5j or 3G.
+	count int
+
+	// marks maps a letter to the path m recorded under it; ' jumps back
+	// and records where it came from under '. markOp is m or ' while the
+	// letter is awaited.
+	marks       map[byte]string
+	markOp      byte
+	showImages  bool
+	previewing  bool
+	previewInfo meta.Info
+
+	// showInfo puts the info panel beside the grid. infoFields holds what
+	// it shows about each item, filled in the background as the cursor
+	// reaches it; infoLoading marks the items being read.
+	showInfo    bool
+	infoFields  map[string][]meta.Field
+	infoLoading map[string]bool
+	toRead      []readReq
+	statAsked   map[string]bool
+	labelsAsked map[string]bool
+
+	// notice is a message in the status line, shown until the next key or
+	// for noticeTimeout. flash sets it and is called with stateMu held.
+	notice      string
+	noticeUntil time.Time
+
+	// failedRecently counts thumbnails that failed since the last notice
+	// about them, so a directory of broken files makes one message.
+	failedRecently int
+
+	// confirmTrash holds the paths d is about to trash while y/n is asked.
+	confirmTrash []string
+
+	// tagPaths holds the files t is tagging while their tags are typed into
+	// tagText. For a selection tagAdd is set, and the tags are added to
+	// what each file has rather than replacing it.
+	tagPaths  []string
+	tagText   string
+	tagAdd    bool
+	winch     <-chan struct{}
+	headerH   int
+	footerH   int
+	contentY  int
+	contentH  int
+	zoom      int
+	baseTileW int
+	baseTileH int
+	ppcX      int
+	ppcY      int
+
+	// rowStart holds the index of the first candidate on every grid row.
+	// Rows are cols apart, except that each group starts a fresh row under
+	// its header. It is rebuilt when cands (rowGen) or the layout change.
+	// Justified rows hold as many tiles as fit; tileX and tileWs give each
+	// candidate's column offset and width.
+	rowStart   []int
+	tileX      []int
+	tileWs     []int
+	built      rowLayout
+	rowGen     int
+	matchesFor matchKey
+	nMatches   int
+	repaintCh  chan struct{}
+	thumbReady map[thumbKey]string
+
+	// thumbFailed remembers thumbnails that could not be made, so their
+	// tiles say so instead of being asked for again on every frame.
+	thumbFailed map[thumbKey]thumbFailure
+
+	// tileColor holds an instant placeholder per path, the image's average
+	// color as an SGR background ("" when there is no cheap way to get one).
+	tileColor    map[string]string
+	thumbMu      sync.Mutex
+	thumbCtx     context.Context
+	cancelThumbs context.CancelFunc
+	thumbQ       *thumbQueue
+	colorQ       *thumbQueue
+
+	// thumbWG counts the workers and background reads stopThumbs waits for.
+	thumbWG    sync.WaitGroup
+	hoverKey   thumbKey
+	hoverSince time.Time
+	hoverLast  time.Time
+	hoverPaths []string
+	hoverFrame int
+	hoverReq   bool
+
+	// Where the renderer fits images to their tiles, thumbnails are made
+	// square at a few sizes rather than to each tile's shape, so zooming by
+	// a step or two reuses them instead of generating the screen again.
+	bucketed    bool
+	drawnTiles  []tileState
+	drawnGroups []string
+
+	// drawnInfo is the info panel on screen; lines the grid clears to the
+	// end reset it so the panel is drawn again.
+	drawnInfo    string
+	firstDraw    bool
+	frameBuf     bytes.Buffer
+	drawnPreview string
+	drawnFrame   frameState
+
+	// drawnTop is the first grid row on screen.
+	drawnTop    int
+	drawnHeader string
+	drawnStatus string
+	drawnMore   string
+	quitRender  chan struct{}
+	renderWG    sync.WaitGroup
+	navCh       chan listing
+
+	// stopList abandons the latest listing, or rescan. The scan goroutine
+	// starts the first and the input loop the rest, so it is guarded by
+	// stateMu.
+	stopList context.CancelFunc
+
+	// scanDone is closed when the first listing is complete.
+	scanDone chan struct{}
+
+	// pressed is a key from a command, taken as typed but never remapped.
+	pressed byte
+	input   *inputReader
+	br      *bufio.Reader
+}
+
+// readReq asks for what a shown file lacks: its size and mtime, or its
+// rating and tags.
+type readReq struct {
+	path         string
+	stat, labels bool
+}
+
+type rowLayout struct{ gen, gridW, tileW, tileH, cols int }
+
+// matchKey is the search and candidate list nMatches was counted for.
+type matchKey struct {
+	query string
+	gen   int
+}
+
+// tileState is what a grid slot last showed; a slot is only repainted
+// when it changes.
+type tileState struct {
+	idx      int
+	path     string
+	cursor   bool
+	selected bool
+	match    bool
+	thumb    string
+	icon     string
+	fill     string
+	// more counts the candidates folded under a collapsed group's tile.
+	more   int
+	rating int
+	// x and w place a justified tile.
+	x, w int
+	// row is the line drawn in the list view.
+	row string
+}
+
+// The screen is only cleared when the layout changes; otherwise draw
+// writes just the slots, header and status line that differ from what is
+// already on screen.
+type frameState struct {
+	w, h, gridW, tileW, tileH, cols, rows int
+	images, preview, groups, list, more   bool
+}
+
+// A listing replaces the grid with another directory; keep is the
+// entry the cursor lands on once it arrives.
+type listing struct {
+	dir, keep string
+	src       <-chan Batch
+}
+
+// Run shows the grid on the terminal and blocks until the user accepts or
+// cancels, ctx is done, or no candidates turn up. Candidates received on
+// opts.Source are merged in while the picker is open.
+func Run(ctx context.Context, initial []Candidate, opts Options) ([]Selection, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	p := newPicker(ctx, initial, opts)
+
+	term.SetTTY(p.in, p.out)
+	p.fdIn = int(p.in.Fd())
+	var err error
+	p.old, err = xt.MakeRaw(p.fdIn)
+	if err != nil {
+		return nil, fmt.Errorf("raw mode: %w", err)
+	}
+	p.restoreVT, err = term.EnableVT(p.out)
+	if err != nil {
+		p.restoreVT = func() {}
+	}
+
+	p.w, p.h, _ = xt.GetSize(int(p.out.Fd()))
+	if p.h <= 0 {
+		p.h = 24
+	}
+	if p.w <= 0 {
+		p.w = 80
+	}
+	p.inlineH, _ = parseHeight(opts.Height, p.h)
+	p.inline = p.inlineH < p.h
+	defer p.restoreTerm()
+
+	p.originY = p.enter()
+	if p.inline {
+		p.h = p.inlineH
+	}
+	p.contentH = max(0, p.h-p.headerH-p.footerH)
+	if cw, ch, ok := term.CellSize(); ok {
+		p.ppcX, p.ppcY = cw, ch
+	}
+	bname, err := term.Detect(opts.Backend)
+	if err != nil {
+		bname = "none"
+	}
+	p.renderer, _ = term.New(bname)
+	if os.Getenv("NO_COLOR") == "" {
+		p.th = p.th.withDefaults(term.LightBackground())
+	}
+	p.plainBox, p.cursorBox, p.selectedBox = tileBoxes()
+	if term.SyncOutput() {
+		p.syncBegin, p.syncEnd = term.SyncBegin, term.SyncEnd
+	}
+	p.useGraphics = p.renderer != nil && p.renderer.Name() != "none"
+	p.showImages = p.useGraphics
+	p.bucketed = p.renderer != nil && term.Scales(p.renderer)
+	p.remote = term.Remote()
+	if p.useGraphics {
+		p.sched = term.NewScheduler(p.renderer, 128)
+
+		defer func() {
+			term.Lock()
+			defer term.Unlock()
+			_ = p.renderer.ClearAll()
+		}()
+		defer func() { p.sched.Close() }()
+	}
+
+	var stopWinch func()
+	p.winch, stopWinch = term.WatchResize(p.out)
+	defer stopWinch()
+
+	p.startThumbs()
+	defer p.stopThumbs()
+
+	if opts.Messages != nil {
+		go p.relayMessages()
+	}
+	p.renderWG.Add(1)
+	go p.renderLoop()
+	defer func() { close(p.quitRender); p.renderWG.Wait() }()
+	go p.followResize()
+
+	defer func() {
+		p.stateMu.Lock()
+		p.stopList()
+		p.stateMu.Unlock()
+	}()
+	go p.readSource()
+
+	if opts.OnExit != nil {
+		defer func() {
+			p.stateMu.Lock()
+			st := State{TopRow: p.topRow, Zoom: p.zoom, Reversed: p.reversed}
+			if len(p.cands) > 0 {
+				st.Cursor = p.cands[p.cur].Path
+			}
+			if len(opts.Sorts) > 0 {
+				st.Sort = opts.Sorts[p.sortIdx].Name
+			}
+			p.stateMu.Unlock()
+			opts.OnExit(st)
+		}()
+	}
+
+	p.input = startInput(p.in)
+	defer func() { p.input.Close() }()
+
+	p.requestRepaint()
+	p.br = bufio.NewReader(p.input)
+	return p.loop()
+}
+
+// newPicker sets up what Run needs before it takes over the terminal.
+func newPicker(ctx context.Context, initial []Candidate, opts Options) *picker {
+	p := &picker{
+		ctx:         ctx,
+		initial:     initial,
+		opts:        opts,
+		in:          opts.In,
+		out:         opts.Out,
+		gen:         opts.Thumbnails,
+		originY:     1,
+		restoreRow:  1,
+		restoreCol:  1,
+		query:       opts.Query,
+		showHidden:  opts.ShowHidden,
+		collapsed:   make(map[string]bool),
+		folded:      make(map[string]int),
+		dir:         opts.Dir,
+		elsewhere:   make(map[string]Candidate),
+		scanning:    true,
+		arrived:     make(map[string]bool),
+		moreCh:      make(chan struct{}, 1),
+		selected:    make(map[string]bool),
+		dragFrom:    -1,
+		marks:       make(map[byte]string),
+		infoFields:  make(map[string][]meta.Field),
+		infoLoading: make(map[string]bool),
+		statAsked:   make(map[string]bool),
+		labelsAsked: make(map[string]bool),
+		headerH:     1,
+		footerH:     1,
+		zoom:        max(0, opts.Zoom),
+		baseTileW:   18,
+		baseTileH:   6,
+		ppcX:        10,
+		ppcY:        20,
+		built:       rowLayout{gen: -1},
+		repaintCh:   make(chan struct{}, 1),
+		thumbReady:  make(map[thumbKey]string),
+		thumbFailed: make(map[thumbKey]thumbFailure),
+		tileColor:   make(map[string]string),
+		firstDraw:   true,
+		quitRender:  make(chan struct{}),
+		navCh:       make(chan listing),
+		stopList:    func() {},
+		scanDone:    make(chan struct{}),
+	}
+	p.keymap, _ = parseKeymap(opts.Keys)
+	p.expectKeys, p.expectFns, _ = parseExpect(opts.Expect)
+	p.th, _ = parseTheme(opts.Colors)
+	if p.th.match == "" {
+		p.th.match = "\x1b[7m"
+	}
+	if p.in == nil {
+		p.in = os.Stdin
+	}
+	if p.out == nil {
+		p.out = os.Stdout
+	}
+	if p.gen == nil {
+		p.gen = thumb.NewGenerator(defaultCacheDir())
+		p.gen.Durations = meta.Durations{}
+	}
+	if opts.HighlightSearch {
+		p.query, p.highlight = "", opts.Query
+	}
+	p.cwd, _ = os.Getwd()
+	p.seekMatch = p.highlight != ""
+	if opts.Select != "" {
+		p.startAt, p.seekMatch = p.abs(opts.Select), false
+	}
+	if r := opts.Resume; r != nil && r.Cursor != "" {
+		p.startAt, p.seekMatch = p.abs(r.Cursor), false
+	}
+	p.less = p.ordered(opts.Less)
+	if r := opts.Resume; r != nil && r.Sort != "" {
+		for i, s := range opts.Sorts {
+			if s.Name == r.Sort {
+				p.sortIdx, p.reversed = i, r.Reversed
+			}
+		}
+	}
+	if len(opts.Sorts) > 0 {
+		l := opts.Sorts[p.sortIdx].Less
+		p.less = p.ordered(l)
+		if p.reversed {
+			p.less = p.ordered(func(a, b Candidate) bool { return l(b, a) })
+		}
+	}
+	if opts.Resume != nil {
+		p.zoom = max(0, opts.Resume.Zoom)
+	}
+	p.contentY = p.headerH + 1
+	if opts.TileWidth > 0 {
+		p.baseTileW = opts.TileWidth
+	}
+	if opts.TileHeight > 0 {
+		p.baseTileH = opts.TileHeight
+	}
+	return p
+}
+
+// breadcrumb shows dir as a path trail, with the home directory as ~.
+func breadcrumb(dir string) string {
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		if rel, err := filepath.Rel(home, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			dir = filepath.Join("~", rel)
+		}
+	}
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	if parts[0] == "" {
+		parts[0] = "/"
+	}
+	return strings.Join(slices.DeleteFunc(parts, func(p string) bool { return p == "" || p == "." }), " › ")
+}
+
+// hasThumb reports whether c gets a thumbnail: directories show a mosaic.
+func hasThumb(c Candidate) bool {
+	return c.Kind == "image" || c.Kind == "video" || c.Kind == "dir"
+}
+
+func otherIcon(c Candidate) string {
+	if c.Kind == "dir" {
+		return "[DIR]"
+	}
+	ext := strings.ToUpper(strings.TrimPrefix(filepath.Ext(c.Path), "."))
+	if ext == "" {
+		return "FILE"
+	}
+	if len(ext) > 4 {
+		ext = ext[:4]
+	}
+	return "[" + ext + "]"
+}
+
+// TileThumbSize is the pixel size of the thumbnail the grid asks for to
+// fill a tileW×tileH tile of cellW×cellH pixel cells. For a renderer that
+// scales, as term.Scales says, it is the square bucket that tiles of about
+// that size share.
+func TileThumbSize(tileW, tileH, cellW, cellH int, scaled bool) (int, int) {
+	w, h := max(8, max(2, tileW-2)*cellW), max(8, max(1, tileH-3)*cellH)
+	if scaled {
+		s := thumbBucket(max(w, h))
+		return s, s
+	}
+	return w, h
+}
+
+// thumbBucket rounds a thumbnail side of n pixels up to the next of 64, 90,
+// 128, 181, 256 and so on, each about √2 times the last.
+func thumbBucket(n int) int {
+	for k := 0; ; k++ {
+		s := 64 << (k / 2)
+		if k%2 == 1 {
+			s = s * 181 / 128
+		}
+		if s >= n {
+			return s
+		}
+	}
+}
+
+// shiftSlots moves s's elements n places toward the front, or toward the
+// back for negative n, zeroing the places they leave.
+func shiftSlots[T any](s []T, n int) {
+	if n > 0 {
+		copy(s, s[n:])
+		clear(s[len(s)-n:])
+	} else {
+		copy(s[-n:], s)
+		clear(s[:-n])
+	}
+}
+
+// abs makes path absolute against the directory thumbgrid started in.
+func (p *picker) abs(path string) string {
+	if filepath.IsAbs(path) || p.cwd == "" {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(p.cwd, path)
+}
@@ -0,0 +1,90 @@
+package picker
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestThumbBucket(t *testing.T) {
+	tests := []struct{ n, want int }{
+		{1, 64},
+		{64, 64},
+		{65, 90},
+		{90, 90},
+		{91, 128},
+		{129, 181},
+		{200, 256},
+		{256, 256},
+		{300, 362},
+		{1000, 1024},
+	}
+	for _, tt := range tests {
+		if got := thumbBucket(tt.n); got != tt.want {
+			t.Errorf("thumbBucket(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTileThumbSize(t *testing.T) {
+	tests := []struct {
+		tileW, tileH, cellW, cellH int
+		scaled                     bool
+		w, h                       int
+	}{
+		{18, 6, 10, 20, false, 160, 60},
+		{18, 6, 10, 20, true, 181, 181},
+		{3, 2, 10, 20, false, 20, 20},
+		{1, 1, 1, 1, false, 8, 8},
+	}
+	for _, tt := range tests {
+		w, h := TileThumbSize(tt.tileW, tt.tileH, tt.cellW, tt.cellH, tt.scaled)
+		if w != tt.w || h != tt.h {
+			t.Errorf("TileThumbSize(%d, %d, %d, %d, %v) = %d, %d, want %d, %d", tt.tileW, tt.tileH, tt.cellW, tt.cellH, tt.scaled, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+func TestShiftSlots(t *testing.T) {
+	tests := []struct {
+		n    int
+		want []int
+	}{
+		{0, []int{1, 2, 3, 4, 5}},
+		{2, []int{3, 4, 5, 0, 0}},
+		{-2, []int{0, 0, 1, 2, 3}},
+		{5, []int{0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		s := []int{1, 2, 3, 4, 5}
+		shiftSlots(s, tt.n)
+		if !slices.Equal(s, tt.want) {
+			t.Errorf("shiftSlots by %d = %v, want %v", tt.n, s, tt.want)
+		}
+	}
+}
+
+func TestParseHeight(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"", 40, false},
+		{"15", 15, false},
+		{" 15 ", 15, false},
+		{"50%", 20, false},
+		{"100%", 40, false},
+		{"2", minInlineHeight, false},
+		{"99", 40, false},
+		{"0", 0, true},
+		{"101%", 0, true},
+		{"-3", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHeight(tt.in, 40)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseHeight(%q, 40) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
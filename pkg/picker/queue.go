@@ -0,0 +1,343 @@
+package picker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+type thumbKey struct {
+	path     string
+	wpx, hpx int
+}
+
+// A thumbnail that could not be made is asked for again after thumbRetry,
+// in case what stood in the way, a busy tool or a full disk, has passed.
+const thumbRetry = 30 * time.Second
+
+type thumbFailure struct {
+	err error
+	at  time.Time
+}
+
+const (
+	prioCursor = iota
+	prioVisible
+	prioPrefetch
+)
+
+type thumbJob struct {
+	key  thumbKey
+	prio int
+	seq  uint64
+	pass uint64
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// thumbQueue hands thumbnail requests to workers, most urgent first. Every
+// draw is a pass: requests that the latest pass no longer asked for belong to
+// tiles that scrolled away, so they are dropped, or cancelled if running.
+type thumbQueue struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pass    uint64
+	seq     uint64
+	pending map[thumbKey]*thumbJob
+	running map[thumbKey]*thumbJob
+	closed  bool
+}
+
+func newThumbQueue(ctx context.Context) *thumbQueue {
+	q := &thumbQueue{
+		ctx:     ctx,
+		pending: make(map[thumbKey]*thumbJob),
+		running: make(map[thumbKey]*thumbJob),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *thumbQueue) begin() {
+	q.mu.Lock()
+	q.pass++
+	q.mu.Unlock()
+}
+
+// want requests k for the current pass; a repeated request keeps the most
+// urgent priority.
+func (q *thumbQueue) want(k thumbKey, prio int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if j, ok := q.running[k]; ok {
+		j.pass = q.pass
+		return
+	}
+	if j, ok := q.pending[k]; ok {
+		if j.pass != q.pass || prio < j.prio {
+			j.prio = prio
+		}
+		j.pass = q.pass
+		return
+	}
+	q.seq++
+	q.pending[k] = &thumbJob{key: k, prio: prio, seq: q.seq, pass: q.pass}
+	q.cond.Signal()
+}
+
+// sweep ends a pass, forgetting requests it did not renew.
+func (q *thumbQueue) sweep() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k, j := range q.pending {
+		if j.pass != q.pass {
+			delete(q.pending, k)
+		}
+	}
+	for _, j := range q.running {
+		if j.pass != q.pass {
+			j.stop()
+		}
+	}
+}
+
+// next blocks until a request is available and marks it running.
+func (q *thumbQueue) next() (*thumbJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	var best *thumbJob
+	for _, j := range q.pending {
+		if best == nil || j.prio < best.prio || j.prio == best.prio && j.seq < best.seq {
+			best = j
+		}
+	}
+	delete(q.pending, best.key)
+	best.ctx, best.stop = context.WithCancel(q.ctx)
+	q.running[best.key] = best
+	return best, true
+}
+
+func (q *thumbQueue) done(j *thumbJob) {
+	j.stop()
+	q.mu.Lock()
+	delete(q.running, j.key)
+	q.mu.Unlock()
+}
+
+func (q *thumbQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	for _, j := range q.running {
+		j.stop()
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// thumbSize is the size to make a thumbnail for a wpx by hpx area.
+func (p *picker) thumbSize(wpx, hpx int) (int, int) {
+	if p.bucketed {
+		s := thumbBucket(max(wpx, hpx))
+		return s, s
+	}
+	return wpx, hpx
+}
+
+// tileThumbSize is the pixel size of the thumbnail for a tile.
+func (p *picker) tileThumbSize(tileW, tileH int) (int, int) {
+	return TileThumbSize(tileW, tileH, p.ppcX, p.ppcY, p.bucketed)
+}
+
+// hoverTick advances the hover animation; it reports whether the current
+// tile needs a repaint. Called with stateMu held.
+func (p *picker) hoverTick(now time.Time) bool {
+	if !p.showImages || p.listView || len(p.cands) == 0 || p.cands[p.cur].Kind != "video" {
+		p.hoverKey, p.hoverPaths = thumbKey{}, nil
+		return false
+	}
+	_, _, _, _, _, tileH, _, _, _ := p.computeLayout()
+	_, tileW := p.tileAt(p.cur, 0)
+	wpx, hpx := p.tileThumbSize(tileW, tileH)
+	k := thumbKey{path: p.cands[p.cur].Path, wpx: wpx, hpx: hpx}
+	if k != p.hoverKey {
+		p.hoverKey, p.hoverSince, p.hoverPaths, p.hoverFrame, p.hoverReq = k, now, nil, 0, false
+		return false
+	}
+	if now.Sub(p.hoverSince) < hoverDelay {
+		return false
+	}
+	if !p.hoverReq {
+		p.hoverReq = true
+		p.thumbWG.Add(1)
+		go func() {
+			defer p.thumbWG.Done()
+			defer p.guard()
+			frames, err := p.gen.GenerateFrames(p.thumbCtx, k.path, k.wpx, k.hpx, hoverFrames)
+			p.stateMu.Lock()
+			if err == nil && k == p.hoverKey {
+				p.hoverPaths, p.hoverLast = frames, time.Now()
+			}
+			p.stateMu.Unlock()
+			select {
+			case p.repaintCh <- struct{}{}:
+			default:
+			}
+		}()
+		return false
+	}
+	if len(p.hoverPaths) > 0 && now.Sub(p.hoverLast) >= hoverInterval {
+		p.hoverFrame = (p.hoverFrame + 1) % len(p.hoverPaths)
+		p.hoverLast = now
+		return true
+	}
+	return false
+}
+
+// ensureThumb returns the thumbnail of path at wpx by hpx, and queues it
+// at prio when it is not made yet and did not fail lately.
+func (p *picker) ensureThumb(path string, wpx, hpx, prio int) (string, bool) {
+	k := thumbKey{path: path, wpx: wpx, hpx: hpx}
+	p.thumbMu.Lock()
+	tp, ok := p.thumbReady[k]
+	f, failed := p.thumbFailed[k]
+	if failed && time.Since(f.at) >= thumbRetry {
+		delete(p.thumbFailed, k)
+		failed = false
+	}
+	p.thumbMu.Unlock()
+	if !ok && !failed {
+		p.thumbQ.want(k, prio)
+	}
+	return tp, ok
+}
+
+// thumbErr is why path's thumbnail could not be made, or nil.
+func (p *picker) thumbErr(path string, wpx, hpx int) error {
+	p.thumbMu.Lock()
+	defer p.thumbMu.Unlock()
+	return p.thumbFailed[thumbKey{path: path, wpx: wpx, hpx: hpx}].err
+}
+
+// placeholder returns path's tile color, and queues it when not known.
+func (p *picker) placeholder(path string, prio int) string {
+	p.thumbMu.Lock()
+	bg, ok := p.tileColor[path]
+	p.thumbMu.Unlock()
+	if !ok {
+		p.colorQ.want(thumbKey{path: path}, prio)
+	}
+	return bg
+}
+
+// colorWorker finds the average colors colorQ asks for, which fill tiles
+// while their thumbnails are made.
+func (p *picker) colorWorker() {
+	defer p.thumbWG.Done()
+	defer p.guard()
+	for {
+		j, ok := p.colorQ.next()
+		if !ok {
+			return
+		}
+		bg := ""
+		if c, err := p.gen.AverageColor(j.ctx, j.key.path); err == nil {
+			bg = fmt.Sprintf("\x1b[48;2;%d;%d;%dm", c.R, c.G, c.B)
+		}
+		p.thumbMu.Lock()
+		_, ready := p.tileColor[j.key.path]
+		if !ready && j.ctx.Err() == nil {
+			p.tileColor[j.key.path] = bg
+		}
+		p.thumbMu.Unlock()
+		p.colorQ.done(j)
+		if bg != "" {
+			select {
+			case p.repaintCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// thumbWorker makes the thumbnails thumbQ asks for, until it is closed.
+func (p *picker) thumbWorker() {
+	defer p.thumbWG.Done()
+	defer p.guard()
+	for {
+		j, ok := p.thumbQ.next()
+		if !ok {
+			return
+		}
+		tp, err := p.gen.GenerateRect(j.ctx, j.key.path, j.key.wpx, j.key.hpx)
+		p.thumbMu.Lock()
+		// A run cut short is asked for again when the tile is next
+		// drawn.
+		failed := err != nil && j.ctx.Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		if err == nil {
+			p.thumbReady[j.key] = tp
+		} else if failed {
+			p.thumbFailed[j.key] = thumbFailure{err, time.Now()}
+		}
+		p.thumbMu.Unlock()
+		p.thumbQ.done(j)
+		// An empty folder is not worth a message, nor is a file
+		// known to be broken from earlier runs.
+		if failed && !errors.Is(err, thumb.ErrEmptyDir) && !errors.Is(err, thumb.ErrBroken) {
+			msg, _, _ := strings.Cut(err.Error(), "\n")
+			p.stateMu.Lock()
+			p.failedRecently++
+			if p.failedRecently == 1 {
+				p.flash(fmt.Sprintf("thumbnail failed: %s: %s", filepath.Base(j.key.path), msg))
+			} else {
+				p.flash(fmt.Sprintf("%d thumbnails failed, last %s: %s", p.failedRecently, filepath.Base(j.key.path), msg))
+			}
+			p.stateMu.Unlock()
+		}
+		select {
+		case p.repaintCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startThumbs starts the workers that make thumbnails and tile colors.
+func (p *picker) startThumbs() {
+	p.thumbCtx, p.cancelThumbs = context.WithCancel(p.ctx)
+	p.thumbQ = newThumbQueue(p.thumbCtx)
+	p.colorQ = newThumbQueue(p.thumbCtx)
+	workers := p.opts.Jobs
+	if workers == 0 {
+		workers = min(max(2, runtime.NumCPU()/2), 8)
+	}
+	for i := 0; i < workers; i++ {
+		p.thumbWG.Add(1)
+		go p.thumbWorker()
+	}
+	for i := 0; i < 2; i++ {
+		p.thumbWG.Add(1)
+		go p.colorWorker()
+	}
+}
+
+// stopThumbs kills the tool processes through thumbCtx and waits for them,
+// so that none outlive the picker.
+func (p *picker) stopThumbs() {
+	p.thumbQ.close()
+	p.colorQ.close()
+	p.cancelThumbs()
+	p.thumbWG.Wait()
+}
@@ -0,0 +1,130 @@
+package picker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/pkg/thumb"
+)
+
+func TestThumbQueueOrder(t *testing.T) {
+	q := newThumbQueue(context.Background())
+	pass := func(reqs map[string]int) {
+		q.begin()
+		for _, k := range []string{"prefetch", "visible1", "visible2", "dropped", "cursor"} {
+			if prio, ok := reqs[k]; ok {
+				q.want(thumbKey{path: k}, prio)
+			}
+		}
+		q.sweep()
+	}
+	pass(map[string]int{"prefetch": prioPrefetch, "visible1": prioVisible, "visible2": prioVisible, "dropped": prioCursor, "cursor": prioPrefetch})
+	// The next pass no longer asks for "dropped", and the cursor moved.
+	pass(map[string]int{"prefetch": prioPrefetch, "visible1": prioVisible, "visible2": prioVisible, "cursor": prioCursor})
+	// A repeated request keeps the most urgent priority.
+	q.want(thumbKey{path: "cursor"}, prioPrefetch)
+
+	var got []string
+	for len(q.pending) > 0 {
+		j, ok := q.next()
+		if !ok {
+			t.Fatal("queue closed")
+		}
+		got = append(got, j.key.path)
+		q.done(j)
+	}
+	if want := []string{"cursor", "visible1", "visible2", "prefetch"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// A running job the latest pass did not ask for is cancelled.
+func TestThumbQueueCancelsUnwanted(t *testing.T) {
+	q := newThumbQueue(context.Background())
+	k := thumbKey{path: "a"}
+	q.begin()
+	q.want(k, prioVisible)
+	q.sweep()
+	j, _ := q.next()
+	q.begin()
+	q.sweep()
+	if j.ctx.Err() == nil {
+		t.Error("job scrolled off screen still runs")
+	}
+	q.done(j)
+}
+
+func TestEnsureThumbRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failedAgo time.Duration
+		wantAsked bool
+	}{
+		{"never failed", -1, true},
+		{"failed lately", time.Second, false},
+		{"failed long ago", thumbRetry + time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPicker(t, Options{}, nil)
+			k := thumbKey{path: "/p/a.png", wpx: 64, hpx: 64}
+			if tt.failedAgo >= 0 {
+				p.thumbFailed[k] = thumbFailure{os.ErrInvalid, time.Now().Add(-tt.failedAgo)}
+			}
+			p.thumbQ.begin()
+			if _, ok := p.ensureThumb(k.path, k.wpx, k.hpx, prioVisible); ok {
+				t.Fatal("ensureThumb has a thumbnail it never made")
+			}
+			if _, asked := p.thumbQ.pending[k]; asked != tt.wantAsked {
+				t.Errorf("queued %v, want %v", asked, tt.wantAsked)
+			}
+			if got := p.thumbErr(k.path, k.wpx, k.hpx) != nil; got == tt.wantAsked {
+				t.Errorf("thumbErr reports a failure %v", got)
+			}
+		})
+	}
+}
+
+// The worker remembers a file that failed, but not a run that was cut short.
+func TestThumbWorkerFailures(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantFailed bool
+	}{
+		{"broken file", context.Background(), true},
+		{"cancelled", canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "a.png")
+			if err := os.WriteFile(path, []byte("not a png"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			p := testPicker(t, Options{}, nil)
+			p.gen = thumb.NewGenerator(filepath.Join(dir, "cache"))
+			p.gen.ImageTools = []string{"go"}
+			p.thumbQ = newThumbQueue(tt.ctx)
+			p.thumbWG.Add(1)
+			go p.thumbWorker()
+
+			k := thumbKey{path: path, wpx: 64, hpx: 64}
+			p.thumbQ.begin()
+			p.thumbQ.want(k, prioVisible)
+			<-p.repaintCh
+			p.thumbQ.close()
+			p.thumbWG.Wait()
+
+			if _, got := p.thumbFailed[k]; got != tt.wantFailed {
+				t.Errorf("failure remembered %v, want %v", got, tt.wantFailed)
+			}
+		})
+	}
+}
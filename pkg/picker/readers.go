@@ -0,0 +1,396 @@
+package picker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/label"
+	"github.com/ck-zhang/thumbgrid/internal/trash"
+)
+
+// Files that arrive without a size and mtime, or without their rating
+// and tags under opts.LazyLabels, have them read in the background as
+// they are shown. lookUp queues what c lacks in toRead, stat asking
+// for the size too, and the frame being drawn reads it when it is done
+// and fills it into the lists. statAsked and labelsAsked mark what was
+// queued; they are cleared with the lists.
+func (p *picker) lookUp(c Candidate, stat bool) {
+	if c.Kind == "dir" {
+		return
+	}
+	r := readReq{c.Path, stat && c.MTime.IsZero() && !p.statAsked[c.Path], p.opts.LazyLabels && !p.labelsAsked[c.Path]}
+	if r.stat || r.labels {
+		p.statAsked[c.Path] = p.statAsked[c.Path] || r.stat
+		p.labelsAsked[c.Path] = p.labelsAsked[c.Path] || r.labels
+		p.toRead = append(p.toRead, r)
+	}
+}
+
+// readShown reads what lookUp queued in the background, and copies it
+// into the lists.
+func (p *picker) readShown() {
+	if len(p.toRead) == 0 {
+		return
+	}
+	reqs := p.toRead
+	p.toRead = nil
+	p.thumbWG.Add(1)
+	go func() {
+		defer p.thumbWG.Done()
+		defer p.guard()
+		type found struct {
+			info   os.FileInfo
+			labels bool
+			rating int
+			tags   []string
+		}
+		got := make(map[string]found, len(reqs))
+		for _, r := range reqs {
+			f := got[r.path]
+			if r.stat {
+				f.info, _ = os.Stat(r.path)
+			}
+			if r.labels {
+				f.labels, f.rating, f.tags = true, label.Rating(r.path), label.Tags(r.path)
+			}
+			got[r.path] = f
+		}
+		p.stateMu.Lock()
+		for _, list := range [][]Candidate{p.all, p.cands} {
+			for i := range list {
+				f, ok := got[list[i].Path]
+				if !ok {
+					continue
+				}
+				if f.info != nil {
+					list[i].Size, list[i].MTime = f.info.Size(), f.info.ModTime()
+				}
+				if f.labels {
+					list[i].Rating, list[i].Tags = f.rating, f.tags
+				}
+			}
+		}
+		p.stateMu.Unlock()
+		select {
+		case p.repaintCh <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+// loadMore lets another Limit candidates in from the scan.
+func (p *picker) loadMore() {
+	select {
+	case p.moreCh <- struct{}{}:
+	default:
+	}
+}
+
+// trashPaths moves paths to the trash in the background, since the
+// trash can be slow, e.g. on a network file system, and takes them out
+// of the grid when done. Called without stateMu.
+func (p *picker) trashPaths(paths []string) {
+	p.thumbWG.Add(1)
+	go func() {
+		defer p.thumbWG.Done()
+		defer p.guard()
+		gone := make(map[string]bool, len(paths))
+		var firstErr error
+		for _, p := range paths {
+			if err := trash.Move(p); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			gone[p] = true
+		}
+		p.stateMu.Lock()
+		p.dropPaths(func(p string) bool { return gone[p] })
+		if firstErr != nil {
+			p.flash(fmt.Sprintf("trash: %v (%d of %d failed)", firstErr, len(paths)-len(gone), len(paths)))
+		} else {
+			p.flash(fmt.Sprintf("moved %d %s to the trash", len(gone), ternary(len(gone) == 1, "file", "files")))
+		}
+		p.stateMu.Unlock()
+		p.requestRepaint()
+	}()
+}
+
+// seekStart moves to opts.Select, or the first match, if it has
+// arrived.
+func (p *picker) seekStart() {
+	if p.seekMatch {
+		if i := nextMatch(p.cands, p.highlight, -1, 1); i >= 0 {
+			p.moveTo(i)
+			p.seekMatch = false
+		}
+	}
+	if p.startAt == "" {
+		return
+	}
+	for i, c := range p.cands {
+		if p.abs(c.Path) == p.startAt {
+			p.moveTo(i)
+			p.startAt = ""
+			// Scroll back to where the view was if the cursor
+			// stays on screen.
+			if r := p.opts.Resume; r != nil {
+				_, _, _, _, _, _, _, rows, _ := p.computeLayout()
+				if row := p.curRow(); r.TopRow <= row && row < r.TopRow+rows {
+					p.topRow = r.TopRow
+				}
+			}
+			return
+		}
+	}
+}
+
+// addBatch merges candidates from the scan into the lists.
+func (p *picker) addBatch(batch []Candidate) {
+	defer p.seekStart()
+	if len(p.arrived) > 0 {
+		batch = slices.DeleteFunc(batch, func(c Candidate) bool { return p.arrived[c.Path] })
+	}
+	if p.less != nil {
+		sort.SliceStable(batch, func(i, j int) bool { return p.less(batch[i], batch[j]) })
+	}
+	if !p.anyHidden && slices.ContainsFunc(batch, func(c Candidate) bool { return c.Hidden }) {
+		p.anyHidden = true
+	}
+	if !p.anyGroups && slices.ContainsFunc(batch, func(c Candidate) bool { return c.Group != "" }) {
+		p.anyGroups = true
+	}
+	anchorPath := ""
+	if p.visual {
+		anchorPath = p.cands[p.anchor].Path
+	}
+	if p.query == "" && (p.showHidden || !p.anyHidden) && len(p.collapsed) == 0 {
+		p.all, p.cur = mergeCandidates(p.all, batch, p.less, p.cur)
+		p.cands = p.all
+		p.rowGen++
+		if p.visual {
+			p.anchor = indexOfPath(p.cands, anchorPath)
+		}
+		p.moveTo(p.cur)
+		return
+	}
+	p.all, _ = mergeCandidates(p.all, batch, p.less, -1)
+	keep := ""
+	if len(p.cands) > 0 {
+		keep = p.cands[p.cur].Path
+	}
+	// Arrivals don't end a visual selection.
+	p.refilter(keep)
+	if i := indexOfPath(p.cands, anchorPath); anchorPath != "" && i >= 0 {
+		p.visual, p.anchor = true, i
+	}
+}
+
+// update applies a Batch from opts.Updates. Changed files come back
+// with new thumbnails.
+func (p *picker) update(res Batch) {
+	changed := make(map[string]bool, len(res.Candidates))
+	for _, c := range res.Candidates {
+		changed[c.Path] = true
+	}
+	p.thumbMu.Lock()
+	maps.DeleteFunc(p.thumbReady, func(k thumbKey, _ string) bool { return changed[k.path] })
+	maps.DeleteFunc(p.thumbFailed, func(k thumbKey, _ thumbFailure) bool { return changed[k.path] })
+	maps.DeleteFunc(p.tileColor, func(p, _ string) bool { return changed[p] })
+	p.thumbMu.Unlock()
+	for path := range changed {
+		delete(p.statAsked, path)
+		delete(p.labelsAsked, path)
+	}
+	if len(res.Removed) > 0 || slices.ContainsFunc(p.all, func(c Candidate) bool { return changed[c.Path] }) {
+		sel := maps.Clone(p.selected)
+		p.dropPaths(func(p string) bool {
+			if changed[p] {
+				return true
+			}
+			for _, r := range res.Removed {
+				if p == r || strings.HasPrefix(p, r+string(filepath.Separator)) {
+					return true
+				}
+			}
+			return false
+		})
+		for path := range changed {
+			if sel[path] {
+				p.selected[path] = true
+			}
+		}
+	}
+	if len(res.Candidates) > 0 {
+		maps.DeleteFunc(p.arrived, func(p string, _ bool) bool { return changed[p] })
+		p.addBatch(res.Candidates)
+		if p.scanning || p.truncated {
+			for path := range changed {
+				p.arrived[path] = true
+			}
+		}
+	}
+}
+
+// list starts listing d, or the rescan without Browse, abandoning the
+// previous one.
+func (p *picker) list(d string) <-chan Batch {
+	p.stateMu.Lock()
+	p.stopList()
+	lctx, cancel := context.WithCancel(p.thumbCtx)
+	p.stopList = cancel
+	hidden := p.showHidden
+	p.stateMu.Unlock()
+	if p.opts.Browse == nil {
+		return p.opts.Rescan(lctx, hidden)
+	}
+	return p.opts.Browse(lctx, d)
+}
+
+// browseTo is called from the input loop, without stateMu.
+func (p *picker) browseTo(d, keep string) {
+	p.navCh <- listing{d, keep, p.list(d)}
+}
+
+// readSource adds what opts.Source, the listings browsing starts and
+// opts.Updates bring to the grid, until the picker closes. It closes
+// scanDone once the first listing is complete.
+func (p *picker) readSource() {
+	defer p.guard()
+	done := p.scanDone
+	if len(p.initial) > 0 {
+		p.stateMu.Lock()
+		p.addBatch(append([]Candidate(nil), p.initial...))
+		p.stateMu.Unlock()
+		p.requestRepaint()
+	}
+	src := p.opts.Source
+	if src == nil && p.opts.Browse != nil {
+		src = p.list(p.dir)
+	}
+	updates := p.opts.Updates
+	keep := ""
+	// With a Limit, held keeps what arrived beyond it, at most a batch
+	// read to learn that there is more; src isn't read while it's held.
+	limit := p.opts.Limit
+	var held []Candidate
+	// take adds the held candidates the limit allows. Called with
+	// stateMu held.
+	take := func() {
+		n := len(held)
+		if limit > 0 {
+			n = min(n, limit-p.loaded)
+		}
+		if n > 0 {
+			p.addBatch(slices.Clone(held[:n]))
+			p.loaded += n
+			held = held[n:]
+			if i := indexOfPath(p.cands, keep); keep != "" && i >= 0 {
+				p.moveTo(i)
+				keep = ""
+			}
+		}
+		p.truncated = len(held) > 0
+	}
+	for {
+		if src == nil {
+			p.stateMu.Lock()
+			p.scanning = false
+			if len(held) == 0 {
+				clear(p.arrived)
+			}
+			p.stateMu.Unlock()
+			p.requestRepaint()
+			if done != nil {
+				close(done)
+				done = nil
+			}
+			if p.opts.Browse == nil && p.opts.Rescan == nil && updates == nil && len(held) == 0 {
+				return
+			}
+		}
+		in := src
+		if len(held) > 0 {
+			in = nil
+		}
+		select {
+		case res, ok := <-in:
+			if !ok {
+				src = nil
+				continue
+			}
+			p.stateMu.Lock()
+			if res.Err != nil {
+				p.scanErr = res.Err
+			} else if len(res.Candidates) > 0 {
+				held = append(held, res.Candidates...)
+				take()
+			}
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		case <-p.moreCh:
+			p.stateMu.Lock()
+			limit += p.opts.Limit
+			take()
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		case l := <-p.navCh:
+			p.stateMu.Lock()
+			for _, c := range p.all {
+				if p.selected[c.Path] {
+					p.elsewhere[c.Path] = c
+				}
+			}
+			p.dir, p.all, p.cands = l.dir, nil, nil
+			p.anyHidden, p.nHidden = false, 0
+			clear(p.arrived)
+			clear(p.statAsked)
+			clear(p.labelsAsked)
+			p.rowGen++
+			p.cur, p.topRow, p.visual = 0, 0, false
+			p.scanning, p.scanErr = true, nil
+			p.truncated, p.loaded = false, 0
+			p.stateMu.Unlock()
+			src, keep = l.src, l.keep
+			limit, held = p.opts.Limit, nil
+			p.requestRepaint()
+		case res, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			p.stateMu.Lock()
+			p.update(res)
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		case <-p.thumbCtx.Done():
+			return
+		}
+	}
+}
+
+// relayMessages shows what opts.Messages sends in the status line.
+func (p *picker) relayMessages() {
+	defer p.guard()
+	for {
+		select {
+		case msg, ok := <-p.opts.Messages:
+			if !ok {
+				return
+			}
+			p.stateMu.Lock()
+			p.flash(msg)
+			p.stateMu.Unlock()
+			p.requestRepaint()
+		case <-p.thumbCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package picker
+
+import (
+	"sort"
+
+	"github.com/ck-zhang/thumbgrid/internal/fuzzy"
+)
+
+func matchCandidates(all []Candidate, query string) []Candidate {
+	type scored struct {
+		c     Candidate
+		score int
+	}
+	matches := make([]scored, 0, len(all))
+	for _, c := range all {
+		if sc, ok := fuzzy.Match(query, c.Name); ok {
+			matches = append(matches, scored{c: c, score: sc})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	out := make([]Candidate, len(matches))
+	for i, m := range matches {
+		out[i] = m.c
+	}
+	return out
+}
+
+// nextMatch returns the first candidate after from, stepping forward or
+// back and wrapping around, whose name matches query, or -1.
+func nextMatch(cands []Candidate, query string, from, step int) int {
+	n := len(cands)
+	for k := 1; k <= n; k++ {
+		i := ((from+step*k)%n + n) % n
+		if nameMatches(cands[i], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+func nameMatches(c Candidate, query string) bool {
+	_, ok := fuzzy.Match(query, c.Name)
+	return ok
+}
+
+func indexOfPath(cands []Candidate, path string) int {
+	for i, c := range cands {
+		if c.Path == path {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,62 @@
+package picker
+
+import (
+	"slices"
+	"testing"
+)
+
+func named(names ...string) []Candidate {
+	var out []Candidate
+	for _, n := range names {
+		out = append(out, Candidate{Path: "/" + n, Name: n})
+	}
+	return out
+}
+
+func TestMatchCandidates(t *testing.T) {
+	all := named("c_a_t.png", "dog.png", "cat.png", "cart.png")
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"cat", []string{"cat.png", "c_a_t.png", "cart.png"}},
+		{"dog", []string{"dog.png"}},
+		{"png", []string{"c_a_t.png", "dog.png", "cat.png", "cart.png"}},
+		{"zebra", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, c := range matchCandidates(all, tt.query) {
+			got = append(got, c.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("matchCandidates(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestNextMatch(t *testing.T) {
+	cands := named("a1", "b", "a2", "c", "a3")
+	tests := []struct {
+		name       string
+		query      string
+		from, step int
+		want       int
+	}{
+		{"forward", "a", 0, 1, 2},
+		{"wraps forward", "a", 4, 1, 0},
+		{"back", "a", 2, -1, 0},
+		{"wraps back", "a", 0, -1, 4},
+		{"from before the first", "a", -1, 1, 0},
+		{"only itself", "b", 1, 1, 1},
+		{"none", "z", 0, 1, -1},
+	}
+	for _, tt := range tests {
+		if got := nextMatch(cands, tt.query, tt.from, tt.step); got != tt.want {
+			t.Errorf("%s: nextMatch(%q, %d, %d) = %d, want %d", tt.name, tt.query, tt.from, tt.step, got, tt.want)
+		}
+	}
+	if got := nextMatch(nil, "a", 0, 1); got != -1 {
+		t.Errorf("nextMatch on no candidates = %d, want -1", got)
+	}
+}
@@ -0,0 +1,35 @@
+package picker
+
+// mergeCandidates merges a sorted batch into a sorted slice and reports where
+// the element previously at index track ended up. A nil less appends.
+func mergeCandidates(a, b []Candidate, less func(a, b Candidate) bool, track int) ([]Candidate, int) {
+	out := make([]Candidate, 0, len(a)+len(b))
+	moved := track
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if j < len(b) && (i >= len(a) || (less != nil && less(b[j], a[i]))) {
+			out = append(out, b[j])
+			j++
+			continue
+		}
+		if i == track {
+			moved = len(out)
+		}
+		out = append(out, a[i])
+		i++
+	}
+	return out, moved
+}
+
+// ordered keeps folders ahead of files whichever way the grid sorts.
+func (p *picker) ordered(l func(a, b Candidate) bool) func(a, b Candidate) bool {
+	if p.opts.Browse == nil {
+		return l
+	}
+	return func(a, b Candidate) bool {
+		if ad, bd := a.Kind == "dir", b.Kind == "dir"; ad != bd {
+			return ad
+		}
+		return l != nil && l(a, b)
+	}
+}
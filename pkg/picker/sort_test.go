@@ -0,0 +1,65 @@
+package picker
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMergeCandidates(t *testing.T) {
+	byName := func(a, b Candidate) bool { return a.Name < b.Name }
+	cands := func(names ...string) []Candidate {
+		var out []Candidate
+		for _, n := range names {
+			out = append(out, Candidate{Path: "/" + n, Name: n})
+		}
+		return out
+	}
+	tests := []struct {
+		name      string
+		a, b      []Candidate
+		less      func(a, b Candidate) bool
+		track     int
+		want      []string
+		wantTrack int
+	}{
+		{"interleaved", cands("b", "d"), cands("a", "c", "e"), byName, 1, []string{"a", "b", "c", "d", "e"}, 3},
+		{"into empty", nil, cands("a", "b"), byName, 0, []string{"a", "b"}, 0},
+		{"ties keep the old first", cands("a"), cands("a"), byName, 0, []string{"a", "a"}, 0},
+		{"unsorted appends", cands("b"), cands("a"), nil, 0, []string{"b", "a"}, 0},
+		{"untracked", cands("b"), cands("a"), byName, -1, []string{"a", "b"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, track := mergeCandidates(tt.a, tt.b, tt.less, tt.track)
+			var got []string
+			for _, c := range out {
+				got = append(got, c.Name)
+			}
+			if !slices.Equal(got, tt.want) || track != tt.wantTrack {
+				t.Errorf("got %q with the tracked one at %d, want %q at %d", got, track, tt.want, tt.wantTrack)
+			}
+		})
+	}
+}
+
+// In browse mode folders stay ahead of files either way round.
+func TestOrderedFoldersFirst(t *testing.T) {
+	byName := func(a, b Candidate) bool { return a.Name < b.Name }
+	byNameDesc := func(a, b Candidate) bool { return a.Name > b.Name }
+	dir := Candidate{Name: "z", Kind: "dir"}
+	file := Candidate{Name: "a", Kind: "image"}
+	for _, browse := range []bool{false, true} {
+		opts := Options{}
+		if browse {
+			opts.Browse = func(ctx context.Context, dir string) <-chan Batch { return nil }
+		}
+		p := &picker{opts: opts}
+		for _, l := range []func(a, b Candidate) bool{byName, byNameDesc} {
+			less := p.ordered(l)
+			if want := browse || l(dir, file); less(dir, file) != want {
+				t.Errorf("browse %v: folder first %v, want %v", browse, less(dir, file), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package picker
+
+import (
+	"strings"
+
+	runewidth "github.com/mattn/go-runewidth"
+)
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func sanitizePrintable(s string) string {
+	rs := []rune(s)
+	b := make([]rune, 0, len(rs))
+	for _, r := range rs {
+		if r == '\x1b' || r == '\n' || r == '\r' || r == '\t' || (r < 0x20) || (r == 0x7f) {
+			b = append(b, ' ')
+		} else {
+			b = append(b, r)
+		}
+	}
+	return string(b)
+}
+
+func dispWidth(s string) int { return runewidth.StringWidth(s) }
+
+func truncateMiddleDisp(s string, width int) string {
+	s = sanitizePrintable(s)
+	if width <= 0 {
+		return ""
+	}
+	if dispWidth(s) <= width {
+		return s
+	}
+	if width <= 3 {
+		return runewidth.Truncate(s, width, "")
+	}
+	avail := width - 3
+	left := avail / 2
+	right := avail - left
+	rs := []rune(s)
+
+	lPart := make([]rune, 0, len(rs))
+	w := 0
+	for _, r := range rs {
+		rw := runewidth.RuneWidth(r)
+		if w+rw > left {
+			break
+		}
+		lPart = append(lPart, r)
+		w += rw
+	}
+
+	rPart := make([]rune, 0, len(rs))
+	w = 0
+	for i := len(rs) - 1; i >= 0; i-- {
+		r := rs[i]
+		rw := runewidth.RuneWidth(r)
+		if w+rw > right {
+			break
+		}
+		rPart = append(rPart, r)
+		w += rw
+	}
+
+	for i, j := 0, len(rPart)-1; i < j; i, j = i+1, j-1 {
+		rPart[i], rPart[j] = rPart[j], rPart[i]
+	}
+	out := string(lPart) + "..." + string(rPart)
+
+	if dispWidth(out) > width {
+		out = runewidth.Truncate(out, width, "")
+	}
+	return out
+}
+
+func padRightToWidth(s string, w int) string {
+	sw := dispWidth(s)
+	if sw >= w {
+		if sw == w {
+			return s
+		}
+		return runewidth.Truncate(s, w, "")
+	}
+	return s + strings.Repeat(" ", w-sw)
+}
+
+func ternary[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// listRow lays out a line of the list view in w cells. The name gets what
+// the other columns leave; on narrow terminals columns drop off the right.
+func listRow(name, size, mtime, kind, res string, w int) string {
+	cols := []struct {
+		s string
+		n int
+	}{{size, 8}, {mtime, 16}, {kind, 5}, {res, 11}}
+	nameW := w
+	for _, c := range cols {
+		nameW -= c.n + 2
+	}
+	for len(cols) > 0 && nameW < 12 {
+		nameW += cols[len(cols)-1].n + 2
+		cols = cols[:len(cols)-1]
+	}
+	var b strings.Builder
+	b.WriteString(padRightToWidth(truncateMiddleDisp(name, nameW), nameW))
+	for i, c := range cols {
+		b.WriteString("  ")
+		if i == 0 {
+			// Sizes line up on the right.
+			b.WriteString(strings.Repeat(" ", max(0, c.n-dispWidth(c.s))) + c.s)
+		} else {
+			b.WriteString(padRightToWidth(c.s, c.n))
+		}
+	}
+	return b.String()
+}
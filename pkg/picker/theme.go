@@ -0,0 +1,152 @@
+package picker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type style string
+
+func (s style) wrap(text string) string {
+	if s == "" {
+		return text
+	}
+	return string(s) + text + "\x1b[0m"
+}
+
+type theme struct {
+	border   style
+	cursor   style
+	selected style
+	header   style
+	status   style
+	match    style
+	// set holds the colors the config named, "default" included, which
+	// withDefaults leaves as they are.
+	set map[string]bool
+}
+
+// withDefaults colors what the config left alone, in shades that read on
+// the terminal's background, or in the basic eight colors when it doesn't
+// say which it has.
+func (t theme) withDefaults(light, known bool) theme {
+	def := theme{border: "\x1b[90m", cursor: "\x1b[36m", selected: "\x1b[32m", header: "\x1b[1m"}
+	switch {
+	case known && light:
+		def = theme{border: "\x1b[38;5;250m", cursor: "\x1b[38;5;25m", selected: "\x1b[38;5;28m", header: "\x1b[1m", status: "\x1b[38;5;241m"}
+	case known:
+		def = theme{border: "\x1b[38;5;240m", cursor: "\x1b[38;5;39m", selected: "\x1b[38;5;78m", header: "\x1b[1m", status: "\x1b[38;5;246m"}
+	}
+	for _, f := range []struct {
+		name string
+		s, d *style
+	}{
+		{"border", &t.border, &def.border}, {"cursor", &t.cursor, &def.cursor}, {"selected", &t.selected, &def.selected},
+		{"header", &t.header, &def.header}, {"status", &t.status, &def.status},
+	} {
+		if !t.set[f.name] {
+			*f.s = *f.d
+		}
+	}
+	return t
+}
+
+// A box is the set of line-drawing characters a tile is outlined with. The
+// cursor's is heavy and a selected tile's double, so they tell apart
+// without color too.
+type box struct{ h, v, tl, tr, bl, br string }
+
+var (
+	boxPlain    = box{"─", "│", "╭", "╮", "╰", "╯"}
+	boxCursor   = box{"━", "┃", "┏", "┓", "┗", "┛"}
+	boxSelected = box{"═", "║", "╔", "╗", "╚", "╝"}
+
+	asciiPlain    = box{"-", "|", "+", "+", "+", "+"}
+	asciiCursor   = box{"=", "#", "#", "#", "#", "#"}
+	asciiSelected = box{"-", "|", "*", "*", "*", "*"}
+)
+
+// tileBoxes returns the outlines of plain, cursor and selected tiles: line
+// drawing, or ASCII where that would come out garbled.
+func tileBoxes() (plain, cursor, selected box) {
+	if asciiTerminal() {
+		return asciiPlain, asciiCursor, asciiSelected
+	}
+	return boxPlain, boxCursor, boxSelected
+}
+
+// asciiTerminal reports whether the terminal probably can't show line
+// drawing: the Linux console's font lacks most of it, and a locale other
+// than UTF-8 can't encode it.
+func asciiTerminal() bool {
+	if os.Getenv("TERM") == "linux" {
+		return true
+	}
+	for _, v := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if l := strings.ToLower(os.Getenv(v)); l != "" {
+			return !strings.Contains(l, "utf-8") && !strings.Contains(l, "utf8")
+		}
+	}
+	return false
+}
+
+var colorNames = map[string]int{
+	"black": 0, "red": 1, "green": 2, "yellow": 3,
+	"blue": 4, "magenta": 5, "cyan": 6, "white": 7,
+}
+
+func parseTheme(m map[string]string) (theme, error) {
+	t := theme{set: make(map[string]bool)}
+	for k, v := range m {
+		s, err := parseColor(v)
+		if err != nil {
+			return t, fmt.Errorf("colors.%s: %w", k, err)
+		}
+		switch k {
+		case "border":
+			t.border = s
+		case "cursor":
+			t.cursor = s
+		case "selected":
+			t.selected = s
+		case "header":
+			t.header = s
+		case "status":
+			t.status = s
+		case "match":
+			t.match = s
+		default:
+			return t, fmt.Errorf("unknown color %q", k)
+		}
+		t.set[k] = true
+	}
+	return t, nil
+}
+
+func parseColor(v string) (style, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" || v == "default" {
+		return "", nil
+	}
+	if strings.HasPrefix(v, "#") && len(v) == 7 {
+		n, err := strconv.ParseUint(v[1:], 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad color %q", v)
+		}
+		return style(fmt.Sprintf("\x1b[38;2;%d;%d;%dm", n>>16&0xff, n>>8&0xff, n&0xff)), nil
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 255 {
+		return style(fmt.Sprintf("\x1b[38;5;%dm", n)), nil
+	}
+	if name, ok := strings.CutPrefix(v, "bright-"); ok {
+		if n, ok := colorNames[name]; ok {
+			return style(fmt.Sprintf("\x1b[%dm", 90+n)), nil
+		}
+	}
+	if n, ok := colorNames[v]; ok {
+		return style(fmt.Sprintf("\x1b[%dm", 30+n)), nil
+	}
+	return "", fmt.Errorf("bad color %q", v)
+}
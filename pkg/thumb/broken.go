@@ -0,0 +1,104 @@
+package thumb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// brokenAfter is how many failed attempts at a file it takes to stop trying.
+const brokenAfter = 2
+
+// ErrBroken is returned, wrapped with the last failure, for a file whose
+// thumbnail failed brokenAfter times. It is tried again once the file
+// changes, another tool is installed or `thumbgrid cache clean -failed` runs.
+var ErrBroken = errors.New("failed before, not retried")
+
+// failKey identifies a file's failures by the same path, mtime and size as
+// its thumbnails, and by the tools that were tried on it.
+func (g *Generator) failKey(abs string, info os.FileInfo) string {
+	var tried []string
+	for _, tool := range g.tools(abs) {
+		if available(tool) {
+			tried = append(tried, tool)
+		}
+	}
+	return subKey(cacheKey(abs, 0, info.ModTime(), info.Size()), "fail", strings.Join(tried, ","))
+}
+
+// broken returns an ErrBroken error if the file has failed often enough.
+func (g *Generator) broken(abs string, info os.FileInfo) error {
+	fl, ok := g.Cache.(FailLog)
+	if !ok {
+		return nil
+	}
+	if n, msg := fl.Failures(g.failKey(abs, info)); n >= brokenAfter {
+		debugf("broken, skipped: %s", abs)
+		return fmt.Errorf("%w: %s", ErrBroken, msg)
+	}
+	return nil
+}
+
+// fileError is a tool's failure at a file that says the file is at fault.
+type fileError struct{ err error }
+
+func (e *fileError) Error() string { return e.err.Error() }
+func (e *fileError) Unwrap() error { return e.err }
+
+// fileFault reports whether err, from making a thumbnail, blames the file:
+// the tool ran and gave up on it, or it didn't decode. A vanished file, a
+// cut-short run or a cache that can't be written says nothing about it.
+func fileFault(err error) bool {
+	var exit *exec.ExitError
+	var perr *fs.PathError
+	switch {
+	case errors.As(err, &exit):
+		return true
+	case errors.As(err, &perr), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
+}
+
+// failed counts a failure of every tool at the file.
+func (g *Generator) failed(abs string, info os.FileInfo, err error) {
+	fl, ok := g.Cache.(FailLog)
+	if !ok {
+		return
+	}
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	fl.AddFailure(g.failKey(abs, info), msg)
+	debugf("failure recorded: %s: %s", abs, msg)
+}
+
+// Failures returns how often key failed and the last reason.
+func (d DirCache) Failures(key string) (int, string) {
+	p := d.failPath(key)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return 0, ""
+	}
+	// Prune goes by use, as for thumbnails.
+	touch(p)
+	ns, msg, _ := strings.Cut(string(b), "\n")
+	n, _ := strconv.Atoi(ns)
+	return n, msg
+}
+
+func (d DirCache) AddFailure(key, msg string) {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return
+	}
+	n, _ := d.Failures(key)
+	_ = writeAtomic(d.failPath(key), []byte(strconv.Itoa(n+1)+"\n"+msg), 0o644)
+}
+
+func (d DirCache) failPath(key string) string {
+	return filepath.Join(string(d), key+".fail")
+}
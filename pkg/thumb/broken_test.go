@@ -0,0 +1,97 @@
+package thumb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileFault(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"tool exited", fmt.Errorf("magick: %w", &exec.ExitError{}), true},
+		{"undecodable", image.ErrFormat, true},
+		{"no output", errors.New("no output"), true},
+		{"file gone", &fs.PathError{Op: "open", Path: "a.png", Err: fs.ErrNotExist}, false},
+		{"canceled", fmt.Errorf("ffmpeg: %w", context.Canceled), false},
+		{"timed out", context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileFault(tt.err); got != tt.want {
+				t.Errorf("fileFault(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirCacheFailures(t *testing.T) {
+	d := DirCache(filepath.Join(t.TempDir(), "cache"))
+	if n, msg := d.Failures("k"); n != 0 || msg != "" {
+		t.Fatalf("Failures before any = %d, %q", n, msg)
+	}
+	for i, msg := range []string{"first", "second", "third"} {
+		d.AddFailure("k", msg)
+		if n, got := d.Failures("k"); n != i+1 || got != msg {
+			t.Errorf("after %d failures: Failures = %d, %q, want %d, %q", i+1, n, got, i+1, msg)
+		}
+	}
+	if n, _ := d.Failures("other"); n != 0 {
+		t.Errorf("Failures of another key = %d, want 0", n)
+	}
+}
+
+// Generate counts failures only when the file is at fault, and gives up on
+// the file after brokenAfter of them.
+func TestGenerateCountsFailures(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		calls int
+		// want is how many failures are recorded after calls.
+		want       int
+		wantBroken bool
+	}{
+		{"undecodable", context.Background(), brokenAfter, brokenAfter, true},
+		{"once", context.Background(), 1, 1, false},
+		{"canceled", canceled, brokenAfter + 1, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "a.png")
+			if err := os.WriteFile(path, []byte("not a png"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			g := NewGenerator(filepath.Join(dir, "cache"))
+			g.ImageTools = []string{"go"}
+			for i := 0; i < tt.calls; i++ {
+				if _, err := g.Generate(tt.ctx, path, 64); err == nil {
+					t.Fatal("Generate made a thumbnail of a broken file")
+				}
+			}
+			abs, info, err := statAbs(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := g.Cache.(FailLog).Failures(g.failKey(abs, info)); n != tt.want {
+				t.Errorf("recorded %d failures, want %d", n, tt.want)
+			}
+			_, err = g.Generate(context.Background(), path, 64)
+			if got := errors.Is(err, ErrBroken); got != tt.wantBroken {
+				t.Errorf("next Generate: %v, want ErrBroken %v", err, tt.wantBroken)
+			}
+		})
+	}
+}
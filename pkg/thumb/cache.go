@@ -0,0 +1,270 @@
+package thumb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+const staleTempAge = time.Hour
+
+// Cache stores rendered thumbnails by key. Put receives the encoded image,
+// PNG or one of the other Formats, and returns the path it can be read from.
+type Cache interface {
+	Get(key string) (string, bool)
+	Put(key string, data []byte) (string, error)
+}
+
+// Locker is implemented by caches that can serialise rendering of a key
+// across generators. Lock blocks until the key is free or ctx is done.
+type Locker interface {
+	Lock(ctx context.Context, key string) (unlock func(), err error)
+}
+
+// FailLog is implemented by caches that remember files no tool could
+// render, so that a corrupt file isn't tried again on every run.
+type FailLog interface {
+	// Failures returns how often key failed and the last reason.
+	Failures(key string) (int, string)
+	AddFailure(key, msg string)
+}
+
+// DirCache keeps thumbnails as <key>.png (or .webp, .avif) in a directory;
+// Prune and Clean manage it.
+type DirCache string
+
+// cacheExts are the extensions DirCache files can have, most likely first.
+var cacheExts = []string{".png", ".webp", ".avif"}
+
+// Get checks that the file is whole before handing it out; a broken one is
+// removed, so the thumbnail is rendered again rather than drawn black.
+func (d DirCache) Get(key string) (string, bool) {
+	for _, ext := range cacheExts {
+		p := filepath.Join(string(d), key+ext)
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if !imgfile.Intact(p) {
+			debugf("cache entry broken, removed: %s", p)
+			_ = os.Remove(p)
+			return "", false
+		}
+		touch(p)
+		return p, true
+	}
+	return "", false
+}
+
+func (d DirCache) Put(key string, data []byte) (string, error) {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(string(d), key+imgfile.Ext(data))
+	return p, writeAtomic(p, data, 0o644)
+}
+
+// writeAtomic writes data next to path and renames it into place, so readers
+// never see a partial file. The data is synced before the rename and the
+// directory after it, so a power cut leaves the old file or the new one
+// rather than an empty or truncated one.
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "thumbgrid.*.png")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// Not every system can sync a directory; the rename stands either way.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+type CachePolicy struct {
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+type CacheEntry struct {
+	Path string
+	Size int64
+	Used time.Time
+}
+
+func CacheEntries(cacheDir string) ([]CacheEntry, error) {
+	des, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []CacheEntry
+	for _, de := range des {
+		if de.IsDir() || !isCacheFile(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, CacheEntry{
+			Path: filepath.Join(cacheDir, de.Name()),
+			Size: info.Size(),
+			Used: info.ModTime(),
+		})
+	}
+	return out, nil
+}
+
+func Prune(cacheDir string, p CachePolicy) (int, int64, error) {
+	removeStaleTemps(cacheDir)
+	entries, err := CacheEntries(cacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Used.Before(entries[j].Used) })
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	removed := 0
+	var freed int64
+	now := time.Now()
+	for _, e := range entries {
+		expired := p.MaxAge > 0 && now.Sub(e.Used) > p.MaxAge
+		over := p.MaxBytes > 0 && total > p.MaxBytes
+		if !expired && !over {
+			continue
+		}
+		if err := os.Remove(e.Path); err != nil {
+			continue
+		}
+		debugf("cache prune: %s", e.Path)
+		total -= e.Size
+		freed += e.Size
+		removed++
+	}
+	return removed, freed, nil
+}
+
+// ForgetFailures removes what the FailLog recorded, so that every file is
+// tried again.
+func ForgetFailures(cacheDir string) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(cacheDir, "*.fail"))
+	removed := 0
+	for _, m := range matches {
+		if isCacheFile(filepath.Base(m)) && os.Remove(m) == nil {
+			removed++
+		}
+	}
+	return removed, err
+}
+
+func Clean(cacheDir string) (int, int64, error) {
+	entries, err := CacheEntries(cacheDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Another thumbgrid may be writing into the cache meanwhile: its temp
+	// files and the locks it holds stay.
+	removeStaleTemps(cacheDir)
+	locks, _ := filepath.Glob(filepath.Join(cacheDir, "*.lock"))
+	for _, m := range locks {
+		removeLock(m)
+	}
+	removed := 0
+	var freed int64
+	for _, e := range entries {
+		if err := os.Remove(e.Path); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += e.Size
+	}
+	return removed, freed, nil
+}
+
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.ParseFloat(n, 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// isCacheFile matches thumbnails and the FailLog's records.
+func isCacheFile(name string) bool {
+	ext := filepath.Ext(name)
+	if !slices.Contains(cacheExts, ext) && ext != ".fail" {
+		return false
+	}
+	base := strings.TrimSuffix(name, ext)
+	if len(base) != 40 {
+		return false
+	}
+	for _, r := range base {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func removeStaleTemps(cacheDir string) {
+	matches, _ := filepath.Glob(filepath.Join(cacheDir, "thumbgrid.*.png"))
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			_ = os.Remove(m)
+		}
+	}
+	locks, _ := filepath.Glob(filepath.Join(cacheDir, "*.lock"))
+	for _, m := range locks {
+		if info, err := os.Stat(m); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			removeLock(m)
+		}
+	}
+}
+
+func touch(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
@@ -0,0 +1,76 @@
+package thumb
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxColorDecode caps the files AverageColor decodes in full.
+const maxColorDecode = 4 << 20
+
+var errNoColor = errors.New("no cheap source for an average color")
+
+// AverageColor returns the mean color of path from the cheapest source at
+// hand: an embedded preview, or the image itself when it is small enough to
+// decode quickly. It is meant for placeholders shown until the real
+// thumbnail is ready, so it gives up rather than run external tools.
+func (g *Generator) AverageColor(ctx context.Context, path string) (color.RGBA, error) {
+	ctx = g.toolContext(ctx)
+	abs, info, err := statAbs(path)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	if !IsRaw(abs) {
+		if img, ok := embeddedImage(ctx, abs, 1, 1); ok {
+			return average(img), nil
+		}
+	}
+	switch strings.ToLower(filepath.Ext(abs)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+	default:
+		return color.RGBA{}, errNoColor
+	}
+	if info.Size() > maxColorDecode {
+		return color.RGBA{}, errNoColor
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return average(img), nil
+}
+
+// average samples a 16×16 grid, which is plenty for one color.
+func average(img image.Image) color.RGBA {
+	b := img.Bounds()
+	if b.Empty() {
+		return color.RGBA{}
+	}
+	const n = 16
+	var r, g, bl, cnt uint64
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			px := b.Min.X + (2*x+1)*b.Dx()/(2*n)
+			py := b.Min.Y + (2*y+1)*b.Dy()/(2*n)
+			cr, cg, cb, ca := img.At(px, py).RGBA()
+			if ca == 0 {
+				continue
+			}
+			r, g, bl, cnt = r+uint64(cr), g+uint64(cg), bl+uint64(cb), cnt+1
+		}
+	}
+	if cnt == 0 {
+		return color.RGBA{}
+	}
+	return color.RGBA{uint8(r / cnt >> 8), uint8(g / cnt >> 8), uint8(bl / cnt >> 8), 0xff}
+}
@@ -0,0 +1,108 @@
+package thumb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// Request asks a thumbnail daemon for a Width×Height thumbnail of Path, as
+// one line of JSON per connection, answered by one line holding a Reply. The
+// client's video settings travel with it, so the daemon renders what the
+// client would have. A client that hangs up abandons its request.
+type Request struct {
+	Path      string `json:"path"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	XDG       bool   `json:"xdg,omitempty"`
+	StripCols int    `json:"strip_cols,omitempty"`
+	StripRows int    `json:"strip_rows,omitempty"`
+	Seek      Seek   `json:"seek"`
+}
+
+type Reply struct {
+	Thumb string `json:"thumb,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Serve answers requests on l with g's cache and tools until ctx is done,
+// rendering at most jobs thumbnails at a time for all clients together.
+func Serve(ctx context.Context, l net.Listener, g *Generator, jobs int) error {
+	sem := make(chan struct{}, max(1, jobs))
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveConn(ctx, conn, g, sem)
+	}
+}
+
+func serveConn(ctx context.Context, conn net.Conn, g *Generator, sem chan struct{}) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_, _ = r.ReadByte()
+		cancel()
+	}()
+	var req Request
+	var rep Reply
+	switch err := json.Unmarshal(line, &req); {
+	case err != nil:
+		rep.Error = "bad request: " + err.Error()
+	case req.Path == "" || req.Width <= 0 || req.Height <= 0:
+		rep.Error = "bad request: need path, width and height"
+	default:
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		rg := *g
+		rg.XDG, rg.StripCols, rg.StripRows, rg.Seek = req.XDG, req.StripCols, req.StripRows, req.Seek
+		rep.Thumb, err = rg.GenerateRect(ctx, req.Path, req.Width, req.Height)
+		<-sem
+		if err != nil {
+			rep.Error = err.Error()
+		}
+	}
+	_ = json.NewEncoder(conn).Encode(rep)
+}
+
+// remote renders through the daemon at g.Daemon. ok is false when there is
+// no daemon to ask, or it went away, and the caller should render itself.
+func (g *Generator) remote(ctx context.Context, abs string, w, h int) (out string, ok bool, err error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", g.Daemon)
+	if err != nil {
+		return "", false, nil
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	req := Request{Path: abs, Width: w, Height: h, XDG: g.XDG, StripCols: g.StripCols, StripRows: g.StripRows, Seek: g.Seek}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", ctx.Err() != nil, ctx.Err()
+	}
+	var rep Reply
+	if err := json.NewDecoder(conn).Decode(&rep); err != nil {
+		return "", ctx.Err() != nil, ctx.Err()
+	}
+	if rep.Error != "" {
+		return "", true, errors.New(rep.Error)
+	}
+	return rep.Thumb, true, nil
+}
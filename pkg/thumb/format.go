@@ -0,0 +1,63 @@
+package thumb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+// Formats a Generator can cache thumbnails in. PNG is lossless and what
+// terminals take as is; lossy WebP and AVIF are several times smaller and
+// are converted back to PNG as they are drawn.
+const (
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// ParseFormat checks a cache format name; "" means PNG.
+func ParseFormat(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "":
+		return FormatPNG, nil
+	case FormatPNG, FormatWebP, FormatAVIF:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid cache format %q (expected png, webp or avif)", s)
+}
+
+// encode converts a rendered PNG to g.Format. Without a tool that can, or
+// when it fails, the thumbnail is kept as PNG.
+func (g *Generator) encode(ctx context.Context, data []byte) []byte {
+	var tools [][]string
+	switch g.Format {
+	case FormatWebP:
+		tools = [][]string{
+			{"magick", "png:-", "-quality", "80", "webp:-"},
+			{"ffmpeg", "-v", "error", "-f", "png_pipe", "-i", "-", "-c:v", "libwebp", "-quality", "80", "-f", "webp", "-"},
+		}
+	case FormatAVIF:
+		tools = [][]string{
+			{"magick", "png:-", "-quality", "60", "avif:-"},
+		}
+	}
+	for _, t := range tools {
+		if !hasExec(t[0]) {
+			continue
+		}
+		cmd := command(ctx, t[0], t[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		out, err := cmd.Output()
+		if err == nil && imgfile.Ext(out) == "."+g.Format {
+			return out
+		}
+		debugf("%s to %s failed: %v", t[0], g.Format, err)
+	}
+	if tools != nil {
+		debugf("kept as png: no tool could make %s", g.Format)
+	}
+	return data
+}
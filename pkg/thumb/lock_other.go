@@ -0,0 +1,14 @@
+//go:build !unix
+
+package thumb
+
+import (
+	"context"
+	"os"
+)
+
+func (d DirCache) Lock(ctx context.Context, key string) (func(), error) {
+	return func() {}, nil
+}
+
+func removeLock(p string) { _ = os.Remove(p) }
@@ -0,0 +1,67 @@
+//go:build unix
+
+package thumb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lock takes an flock on <key>.lock so that concurrent generators, in this
+// process or another, render each thumbnail once. It polls so that ctx can
+// abandon the wait.
+func (d DirCache) Lock(ctx context.Context, key string) (func(), error) {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return nil, err
+	}
+	p := filepath.Join(string(d), key+".lock")
+	for {
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+			if err != unix.EWOULDBLOCK && err != unix.EINTR {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				f.Close()
+				return nil, ctx.Err()
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		// The previous holder removes the file before unlocking; if that
+		// happened while we waited, our lock is on an orphan and we retry.
+		var held, cur unix.Stat_t
+		if unix.Fstat(int(f.Fd()), &held) == nil && unix.Stat(p, &cur) == nil && held.Ino == cur.Ino && held.Dev == cur.Dev {
+			return func() {
+				_ = os.Remove(p)
+				f.Close()
+			}, nil
+		}
+		f.Close()
+	}
+}
+
+// removeLock removes the lock file p unless it is held. One that a Lock is
+// about to take is then gone by the time it checks, so it starts over.
+func removeLock(p string) {
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB) == nil {
+		_ = os.Remove(p)
+	}
+}
@@ -0,0 +1,71 @@
+package thumb
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+)
+
+// ErrEmptyDir is returned for a directory with no images or videos to show.
+var ErrEmptyDir = errors.New("no images in directory")
+
+// mosaic tiles thumbnails of the first four images or videos in dir, by
+// name, 2x2 (or one filling the box when there is just one). The key goes
+// by the directory's mtime, which changes when entries come and go.
+func (g *Generator) mosaic(ctx context.Context, abs string, info os.FileInfo, w, h int) (string, error) {
+	key := subKey(cacheKeyRect(abs, w, h, info.ModTime(), 0), "mosaic")
+	if out, ok := g.Cache.Get(key); ok {
+		return out, nil
+	}
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return "", err
+	}
+	var paths []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") && (imageExts[ext] || videoExts[ext] || IsRaw(e.Name())) {
+			paths = append(paths, filepath.Join(abs, e.Name()))
+			if len(paths) == 4 {
+				break
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return "", ErrEmptyDir
+	}
+	return g.render(ctx, key, func() ([]byte, error) {
+		cw, ch := max(1, (w-2)/2), max(1, (h-2)/2)
+		if len(paths) == 1 {
+			cw, ch = w, h
+		}
+		dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+		drawn := 0
+		for i, p := range paths {
+			tp, err := g.GenerateRect(ctx, p, cw, ch)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				continue
+			}
+			img, err := imgfile.Decode(tp)
+			if err != nil {
+				continue
+			}
+			at := image.Pt((i%2)*(w-cw), (i/2)*(h-ch))
+			draw.Draw(dst, image.Rectangle{at, at.Add(img.Bounds().Size())}, img, img.Bounds().Min, draw.Over)
+			drawn++
+		}
+		if drawn == 0 {
+			return nil, errors.New("no thumbnails for directory")
+		}
+		return encodePNG(dst)
+	})
+}
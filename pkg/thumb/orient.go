@@ -0,0 +1,100 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+
+	"github.com/ck-zhang/thumbgrid/internal/exif"
+)
+
+// orientFilter is the ffmpeg filter that displays an image with the given
+// EXIF orientation upright.
+var orientFilter = map[int]string{
+	2: "hflip",
+	3: "hflip,vflip",
+	4: "vflip",
+	5: "transpose=0",
+	6: "transpose=1",
+	7: "transpose=3",
+	8: "transpose=2",
+}
+
+func orient(src image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// goThumb decodes abs with the standard library, so gif, jpeg and png still
+// get thumbnails when no external tool is installed. Without padding the
+// image is scaled to fit w×h instead of letterboxed.
+func goThumb(abs string, w, h int, pad bool) ([]byte, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	img = orient(img, exif.Orientation(abs))
+	if !pad {
+		w, h = fitBox(img.Bounds(), w, h)
+	}
+	return encodePNG(fitImage(img, w, h))
+}
+
+// fitBox scales b down to fit inside w×h, keeping its aspect ratio.
+func fitBox(b image.Rectangle, w, h int) (int, int) {
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return w, h
+	}
+	fw, fh := w, b.Dy()*w/b.Dx()
+	if fh > h {
+		fw, fh = b.Dx()*h/b.Dy(), h
+	}
+	return max(1, fw), max(1, fh)
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
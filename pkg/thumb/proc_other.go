@@ -0,0 +1,15 @@
+//go:build !unix
+
+package thumb
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = 2 * time.Second
+	return cmd
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package thumb
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// command runs name in its own process group and kills the whole group when
+// ctx is done, so delegates spawned by magick or dcraw die with it. Under a
+// Generator with Nice set it runs through nice and ionice, which exec the
+// tool in place.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if ctx.Value(niceKey{}) != nil {
+		if hasExec("ionice") {
+			name, args = "ionice", append([]string{"-c", "3", name}, args...)
+		}
+		if hasExec("nice") {
+			name, args = "nice", append([]string{"-n", "19", name}, args...)
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 2 * time.Second
+	return cmd
+}
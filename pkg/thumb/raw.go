@@ -0,0 +1,102 @@
+package thumb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// rawArgs makes dcraw/dcraw_emu write a half-size, camera white balanced PPM
+// to stdout. Both already rotate by the camera's orientation flag.
+var rawArgs = map[string][]string{
+	"dcraw_emu": {"-h", "-w", "-Z", "-"},
+	"dcraw":     {"-c", "-h", "-w"},
+}
+
+func rawThumb(ctx context.Context, tool, abs string, w, h int, pad bool) ([]byte, error) {
+	args, ok := rawArgs[tool]
+	if !ok {
+		return nil, fmt.Errorf("unknown raw tool %q", tool)
+	}
+	data, err := command(ctx, tool, append(args, abs)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	img, err := decodePPM(data)
+	if err != nil {
+		return nil, err
+	}
+	if !pad {
+		w, h = fitBox(img.Bounds(), w, h)
+	}
+	return encodePNG(fitImage(img, w, h))
+}
+
+func decodePPM(data []byte) (image.Image, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var hdr [4]int
+	magic, err := ppmToken(r)
+	if err != nil || magic != "P6" {
+		return nil, errors.New("not a binary ppm")
+	}
+	for i := 1; i < 4; i++ {
+		tok, err := ppmToken(r)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(tok, "%d", &hdr[i]); err != nil || hdr[i] <= 0 {
+			return nil, fmt.Errorf("bad ppm header %q", tok)
+		}
+	}
+	w, h, maxval := hdr[1], hdr[2], hdr[3]
+	bpc := 1
+	if maxval > 255 {
+		bpc = 2
+	}
+	px := make([]byte, w*h*3*bpc)
+	if _, err := io.ReadFull(r, px); err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		var c [3]uint8
+		for k := 0; k < 3; k++ {
+			v := int(px[(i*3+k)*bpc])
+			if bpc == 2 {
+				v = v<<8 | int(px[(i*3+k)*bpc+1])
+			}
+			c[k] = uint8(v * 255 / maxval)
+		}
+		img.SetRGBA(i%w, i/w, color.RGBA{c[0], c[1], c[2], 0xff})
+	}
+	return img, nil
+}
+
+// ppmToken reads one whitespace-separated header token, skipping comments,
+// and consumes the single whitespace byte that ends it.
+func ppmToken(r *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#':
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, c)
+		}
+	}
+}
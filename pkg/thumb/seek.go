@@ -0,0 +1,83 @@
+package thumb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Seek picks the video frame a thumbnail is grabbed from: either a fraction
+// of the duration or a fixed offset in seconds. The zero value means 10%.
+type Seek struct {
+	Percent float64
+	Seconds float64
+	// Offset is set when Seconds is meant, so that 00:00:00 is expressible.
+	Offset bool
+}
+
+// ParseSeek accepts a percentage ("25%"), a timestamp ("00:00:05",
+// "1:30.5") or plain seconds ("12").
+func ParseSeek(s string) (Seek, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Seek{}, nil
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v < 0 || v > 100 {
+			return Seek{}, fmt.Errorf("invalid seek %q (expected 0%%-100%%)", s)
+		}
+		if v == 0 {
+			return Seek{Offset: true}, nil
+		}
+		return Seek{Percent: v}, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return Seek{}, fmt.Errorf("invalid seek %q (expected 25%% or hh:mm:ss)", s)
+	}
+	secs := 0.0
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || (i > 0 && v >= 60) {
+			return Seek{}, fmt.Errorf("invalid seek %q (expected 25%% or hh:mm:ss)", s)
+		}
+		secs = secs*60 + v
+	}
+	return Seek{Seconds: secs, Offset: true}, nil
+}
+
+func (s Seek) String() string {
+	if s.Offset {
+		return strconv.FormatFloat(s.Seconds, 'f', -1, 64) + "s"
+	}
+	return strconv.FormatFloat(s.percent(), 'f', -1, 64) + "%"
+}
+
+func (s Seek) percent() float64 {
+	if s.Percent == 0 && !s.Offset {
+		return 10
+	}
+	return s.Percent
+}
+
+func (s Seek) isDefault() bool { return !s.Offset && s.percent() == 10 }
+
+// at resolves the seek point against a clip of dur seconds, staying clear of
+// the final frame.
+func (s Seek) at(dur float64) float64 {
+	t := s.Seconds
+	if !s.Offset {
+		t = dur * s.percent() / 100
+		if s.isDefault() && t < 0.5 {
+			t = 0.5
+		}
+	}
+	if t > dur-0.1 {
+		t = dur - 0.1
+	}
+	if t < 0 {
+		t = 0
+	}
+	return t
+}
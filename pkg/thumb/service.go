@@ -0,0 +1,546 @@
+// Package thumb renders image and video thumbnails to PNG (or WebP or AVIF)
+// files with ffmpeg, vipsthumbnail or ImageMagick and caches them by path,
+// size and mtime.
+package thumb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ck-zhang/thumbgrid/internal/exif"
+)
+
+const cacheVersion = "ffmpeg-v1"
+
+var errNoTool = errors.New("no image tool available (install ffmpeg, vipsthumbnail, or magick)")
+
+func debugf(format string, a ...any) {
+	if os.Getenv("THUMBGRID_DEBUG") == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "thumbgrid: "+format+"\n", a...)
+}
+
+// Generator produces thumbnails. The zero value is not usable; set Cache or
+// use NewGenerator.
+type Generator struct {
+	Cache Cache
+	// VideoTools and ImageTools list the external tools to try, in order.
+	// Nil means ffmpeg then magick for videos, dcraw_emu, dcraw then magick
+	// for RAW photos, and vipsthumbnail, magick and the built-in decoder
+	// ("go") for other images.
+	VideoTools []string
+	ImageTools []string
+	// XDG reuses and fills the freedesktop.org thumbnail cache.
+	XDG bool
+	// StripCols and StripRows, when both set, turn video thumbnails into a
+	// contact sheet of frames sampled across the clip, e.g. 2x2 or 3x1.
+	StripCols, StripRows int
+	// Seek chooses the frame of single-frame video thumbnails.
+	Seek Seek
+	// Daemon is the socket of a thumbnail daemon (see Serve) that renders
+	// GenerateRect misses; without one answering, they are rendered here.
+	Daemon string
+	// Nice runs the external tools at the lowest CPU and I/O priority, with
+	// nice and ionice where they are installed, so that a big first scan
+	// doesn't starve the rest of the machine.
+	Nice bool
+	// Format is the cache format, one of the Format constants; "" is PNG.
+	Format string
+	// Durations, when set, remembers how long videos are, so that seeking
+	// into one already measured needs no ffprobe run.
+	Durations Durations
+}
+
+// Durations looks up and records video lengths in seconds.
+type Durations interface {
+	Duration(path string) (float64, bool)
+	SetDuration(path string, d float64)
+}
+
+func NewGenerator(cacheDir string) *Generator {
+	return &Generator{Cache: DirCache(cacheDir)}
+}
+
+// niceKey marks a context whose tools command starts at low priority.
+type niceKey struct{}
+
+func (g *Generator) toolContext(ctx context.Context) context.Context {
+	if !g.Nice {
+		return ctx
+	}
+	return context.WithValue(ctx, niceKey{}, true)
+}
+
+func (g *Generator) tools(abs string) []string {
+	if isVideo(abs) {
+		if g.VideoTools != nil {
+			return g.VideoTools
+		}
+		return []string{"ffmpeg", "magick"}
+	}
+	if g.ImageTools != nil {
+		return g.ImageTools
+	}
+	if IsRaw(abs) {
+		return []string{"dcraw_emu", "dcraw", "magick"}
+	}
+	return []string{"vipsthumbnail", "magick", "go"}
+}
+
+func available(tool string) bool { return tool == "go" || hasExec(tool) }
+
+// Generate returns a size×size thumbnail of path, letterboxed on a
+// transparent background.
+func (g *Generator) Generate(ctx context.Context, path string, size int) (string, error) {
+	ctx = g.toolContext(ctx)
+	abs, info, err := statAbs(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return g.mosaic(ctx, abs, info, size, size)
+	}
+	key := g.videoKey(abs, cacheKey(abs, size, info.ModTime(), info.Size()))
+	if out, ok := g.Cache.Get(key); ok {
+		debugf("cache hit (square): %s", out)
+		return out, nil
+	}
+	if err := g.broken(abs, info); err != nil {
+		return "", err
+	}
+	if out, ok := g.fromEmbedded(ctx, key, abs, size, size); ok {
+		return out, nil
+	}
+	var lastErr error
+	// Only a file every tool gave up on is counted as failed.
+	fault := true
+	for _, tool := range g.tools(abs) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if !available(tool) {
+			continue
+		}
+		out, err := g.render(ctx, key, func() ([]byte, error) {
+			switch tool {
+			case "ffmpeg":
+				return g.ffmpegThumb(ctx, abs, size, size)
+			case "vipsthumbnail":
+				return vipsThumb(ctx, abs, size)
+			case "magick":
+				return magickExtent(ctx, abs, size, size)
+			case "go":
+				return goThumb(abs, size, size, true)
+			case "dcraw_emu", "dcraw":
+				return rawThumb(ctx, tool, abs, size, size, true)
+			}
+			return nil, fmt.Errorf("unknown tool %q", tool)
+		})
+		if err == nil {
+			debugf("square via %s size=%d: %s", tool, size, abs)
+			return out, nil
+		}
+		debugf("%s (square) failed: %v", tool, err)
+		lastErr = fmt.Errorf("%s: %w", tool, err)
+		var fe *fileError
+		if !errors.As(err, &fe) {
+			fault = false
+		}
+	}
+	if lastErr == nil {
+		return "", errNoTool
+	}
+	// A run cut short says nothing about the file.
+	if ctx.Err() == nil && fault {
+		g.failed(abs, info, lastErr)
+	}
+	return "", lastErr
+}
+
+// GenerateRect returns a w×h thumbnail of path, letterboxed on a transparent
+// background. It falls back to a square thumbnail when no tool can pad. A
+// directory gets a mosaic of its first few images.
+func (g *Generator) GenerateRect(ctx context.Context, path string, w, h int) (string, error) {
+	ctx = g.toolContext(ctx)
+	if w <= 0 || h <= 0 {
+		return g.Generate(ctx, path, max(w, h))
+	}
+	abs, info, err := statAbs(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return g.mosaic(ctx, abs, info, w, h)
+	}
+	key := g.videoKey(abs, cacheKeyRect(abs, w, h, info.ModTime(), info.Size()))
+	if out, ok := g.Cache.Get(key); ok {
+		debugf("cache hit (rect): %s", out)
+		return out, nil
+	}
+	if err := g.broken(abs, info); err != nil {
+		return "", err
+	}
+	if g.Daemon != "" {
+		if out, ok, err := g.remote(ctx, abs, w, h); ok {
+			debugf("rect via daemon %dx%d: %s", w, h, abs)
+			return out, err
+		}
+	}
+	if out, ok := g.fromEmbedded(ctx, key, abs, w, h); ok {
+		return out, nil
+	}
+	if g.XDG {
+		out, err := g.render(ctx, key, func() ([]byte, error) { return g.xdgRect(ctx, abs, info, w, h) })
+		if err == nil {
+			debugf("rect via xdg thumbnail %dx%d: %s", w, h, abs)
+			return out, nil
+		}
+		debugf("xdg thumbnail failed: %v", err)
+	}
+	for _, tool := range g.tools(abs) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if tool == "vipsthumbnail" || !available(tool) {
+			continue
+		}
+		out, err := g.render(ctx, key, func() ([]byte, error) {
+			switch tool {
+			case "ffmpeg":
+				return g.ffmpegThumb(ctx, abs, w, h)
+			case "magick":
+				return magickExtent(ctx, abs, w, h)
+			case "go":
+				return goThumb(abs, w, h, true)
+			case "dcraw_emu", "dcraw":
+				return rawThumb(ctx, tool, abs, w, h, true)
+			}
+			return nil, fmt.Errorf("unknown tool %q", tool)
+		})
+		if err == nil {
+			debugf("rect via %s %dx%d: %s", tool, w, h, abs)
+			return out, nil
+		}
+		debugf("%s (rect) failed: %v", tool, err)
+	}
+	return g.Generate(ctx, path, max(w, h))
+}
+
+// GenerateFrames returns n w×h frames sampled evenly across a video, e.g. for
+// animated previews. Each frame is cached like a thumbnail.
+func (g *Generator) GenerateFrames(ctx context.Context, path string, w, h, n int) ([]string, error) {
+	ctx = g.toolContext(ctx)
+	abs, info, err := statAbs(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isVideo(abs) {
+		return nil, fmt.Errorf("not a video: %s", path)
+	}
+	frames := make([]string, n)
+	dur := -1.0
+	for i := range frames {
+		key := cacheKeyFrame(abs, w, h, i, n, info.ModTime(), info.Size())
+		if out, ok := g.Cache.Get(key); ok {
+			frames[i] = out
+			continue
+		}
+		if dur < 0 {
+			if !hasExec("ffmpeg") || !hasExec("ffprobe") {
+				return nil, errors.New("video frames need ffmpeg and ffprobe")
+			}
+			if dur, err = g.probeDuration(ctx, abs); err != nil {
+				return nil, err
+			}
+		}
+		seek := dur * (float64(i) + 0.5) / float64(n)
+		out, err := g.render(ctx, key, func() ([]byte, error) { return ffmpegAt(ctx, abs, seek, padFilter(w, h)) })
+		if err != nil {
+			return nil, err
+		}
+		frames[i] = out
+	}
+	debugf("%d frames %dx%d: %s", n, w, h, abs)
+	return frames, nil
+}
+
+// render runs fn, which returns an encoded PNG, and stores the result under
+// key. Tools write to stdout, so a failed run leaves nothing on disk. When
+// the cache is a Locker, only one generator renders a key at a time and the
+// others pick up its result.
+func (g *Generator) render(ctx context.Context, key string, fn func() ([]byte, error)) (string, error) {
+	if l, ok := g.Cache.(Locker); ok {
+		unlock, err := l.Lock(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		defer unlock()
+		if out, ok := g.Cache.Get(key); ok {
+			debugf("rendered concurrently: %s", out)
+			return out, nil
+		}
+	}
+	data, err := fn()
+	if err != nil {
+		if fileFault(err) {
+			err = &fileError{err}
+		}
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", &fileError{errors.New("tool produced no output")}
+	}
+	return g.Cache.Put(key, g.encode(ctx, data))
+}
+
+func statAbs(path string) (string, os.FileInfo, error) {
+	abs := path
+	if !filepath.IsAbs(abs) {
+		a, _ := filepath.Abs(path)
+		abs = a
+	}
+	info, err := os.Stat(abs)
+	return abs, info, err
+}
+
+func magickExtent(ctx context.Context, abs string, w, h int) ([]byte, error) {
+	return command(ctx,
+		"magick",
+		abs+srcFrameSuffix(abs),
+		"-auto-orient",
+		"-thumbnail", fmt.Sprintf("%dx%d", w, h),
+		"-background", "none",
+		"-gravity", "center",
+		"-extent", fmt.Sprintf("%dx%d", w, h),
+		"png:-",
+	).Output()
+}
+
+// vipsThumb goes through a private temp file: vipsthumbnail resolves -o
+// relative to the source directory and older releases can't write to stdout.
+func vipsThumb(ctx context.Context, abs string, size int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "thumbgrid")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "t.png")
+	if err := command(ctx, "vipsthumbnail", abs, "-s", strconv.Itoa(size), "-o", out).Run(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+func hasExec(name string) bool { _, err := exec.LookPath(name); return err == nil }
+
+func cacheKey(path string, size int, mt time.Time, fsz int64) string {
+	h := sha1.New()
+	io.WriteString(h, path)
+	io.WriteString(h, "|")
+	io.WriteString(h, strconv.Itoa(size))
+	io.WriteString(h, "|")
+	io.WriteString(h, strconv.FormatInt(mt.Unix(), 10))
+	io.WriteString(h, "|")
+	io.WriteString(h, strconv.FormatInt(fsz, 10))
+	io.WriteString(h, "|")
+	io.WriteString(h, cacheVersion)
+	sum := h.Sum(nil)
+	return hex.EncodeToString(sum)
+}
+
+func cacheKeyRect(path string, w, h int, mt time.Time, fsz int64) string {
+	hsh := sha1.New()
+	io.WriteString(hsh, path)
+	io.WriteString(hsh, "|")
+	io.WriteString(hsh, strconv.Itoa(w))
+	io.WriteString(hsh, "x")
+	io.WriteString(hsh, strconv.Itoa(h))
+	io.WriteString(hsh, "|")
+	io.WriteString(hsh, strconv.FormatInt(mt.Unix(), 10))
+	io.WriteString(hsh, "|")
+	io.WriteString(hsh, strconv.FormatInt(fsz, 10))
+	io.WriteString(hsh, "|")
+	io.WriteString(hsh, cacheVersion)
+	return hex.EncodeToString(hsh.Sum(nil))
+}
+
+func cacheKeyFrame(path string, w, h, i, n int, mt time.Time, fsz int64) string {
+	return subKey(cacheKeyRect(path, w, h, mt, fsz), "frame", strconv.Itoa(i)+"/"+strconv.Itoa(n))
+}
+
+// subKey derives the key of a variant of the thumbnail stored under key.
+func subKey(key string, parts ...string) string {
+	hsh := sha1.New()
+	io.WriteString(hsh, key)
+	for _, p := range parts {
+		io.WriteString(hsh, "|")
+		io.WriteString(hsh, p)
+	}
+	return hex.EncodeToString(hsh.Sum(nil))
+}
+
+func (g *Generator) filmstrip() bool { return g.StripCols > 0 && g.StripRows > 0 }
+
+func (g *Generator) videoKey(abs, key string) string {
+	switch {
+	case !isVideo(abs):
+		return key
+	case g.filmstrip():
+		return subKey(key, "strip", fmt.Sprintf("%dx%d", g.StripCols, g.StripRows))
+	case !g.Seek.isDefault():
+		return subKey(key, "seek", g.Seek.String())
+	}
+	return key
+}
+
+func (g *Generator) ffmpegThumb(ctx context.Context, abs string, w, h int) ([]byte, error) {
+	if !isVideo(abs) || !g.filmstrip() || !hasExec("ffprobe") {
+		return g.ffmpegGrab(ctx, abs, w, h)
+	}
+	dur, err := g.probeDuration(ctx, abs)
+	if err != nil {
+		return g.ffmpegGrab(ctx, abs, w, h)
+	}
+	// One pass: sample n frames evenly (offset by half a step so neither the
+	// first black frame nor the credits dominate), then tile them.
+	n := g.StripCols * g.StripRows
+	cw, ch := max(1, w/g.StripCols), max(1, h/g.StripRows)
+	vf := fmt.Sprintf("fps=%d/%.3f,%s,tile=%dx%d,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+		n, dur, padFilter(cw, ch), g.StripCols, g.StripRows, w, h)
+	return ffmpegAt(ctx, abs, dur/float64(2*n), vf)
+}
+
+func srcFrameSuffix(path string) string {
+	if isVideo(path) {
+		return "[0]"
+	}
+	return ""
+}
+
+var (
+	videoExts = map[string]bool{
+		".mp4": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true, ".m4v": true,
+	}
+	imageExts = map[string]bool{
+		".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+		".bmp": true, ".tif": true, ".tiff": true, ".avif": true, ".heic": true,
+	}
+)
+
+// isVideo goes by extension, looking inside only files with an unknown one.
+func isVideo(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case videoExts[ext]:
+		return true
+	case imageExts[ext], IsRaw(path):
+		return false
+	}
+	return Sniff(path) == "video"
+}
+
+func (g *Generator) ffmpegGrab(ctx context.Context, abs string, w, h int) ([]byte, error) {
+	if w <= 0 || h <= 0 {
+
+		size := max(w, h)
+		if size <= 0 {
+			size = 256
+		}
+		w, h = size, size
+	}
+
+	return g.ffmpegRun(ctx, abs, padFilter(w, h))
+}
+
+func padFilter(w, h int) string {
+	return fmt.Sprintf(
+		"scale=%d:%d:force_original_aspect_ratio=decrease,"+
+			"pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=black@0,format=rgba",
+		w, h, w, h,
+	)
+}
+
+func (g *Generator) ffmpegRun(ctx context.Context, abs, vf string) ([]byte, error) {
+	if !isVideo(abs) {
+		// ffmpeg ignores EXIF orientation on stills, so rotate explicitly.
+		if f, ok := orientFilter[exif.Orientation(abs)]; ok {
+			vf = f + "," + vf
+		}
+		return command(ctx,
+			"ffmpeg",
+			"-v", "error",
+			"-noautorotate",
+			"-i", abs,
+			"-frames:v", "1",
+			"-vf", vf,
+			"-f", "image2pipe", "-c:v", "png", "-",
+		).Output()
+	}
+	seek := 2.0
+	if g.Seek.Offset {
+		seek = g.Seek.Seconds
+	}
+	if hasExec("ffprobe") {
+		if dur, err := g.probeDuration(ctx, abs); err == nil && dur > 0.0 {
+			seek = g.Seek.at(dur)
+		}
+	}
+	return ffmpegAt(ctx, abs, seek, vf)
+}
+
+func ffmpegAt(ctx context.Context, abs string, seek float64, vf string) ([]byte, error) {
+	return command(ctx,
+		"ffmpeg",
+		"-v", "error",
+		"-ss", fmt.Sprintf("%.3f", seek),
+		"-i", abs,
+		"-frames:v", "1",
+		"-vf", vf,
+		"-f", "image2pipe", "-c:v", "png", "-",
+	).Output()
+}
+
+// probeDuration asks ffprobe how long a video is, unless g.Durations
+// knows.
+func (g *Generator) probeDuration(ctx context.Context, abs string) (float64, error) {
+	if g.Durations != nil {
+		if d, ok := g.Durations.Duration(abs); ok {
+			return d, nil
+		}
+	}
+	cmd := command(ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "format=duration",
+		"-of", "default=nokey=1:noprint_wrappers=1",
+		abs,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(out))
+	if s == "" || s == "N/A" {
+		return 0, fmt.Errorf("no duration")
+	}
+	d, perr := strconv.ParseFloat(s, 64)
+	if perr != nil || !(d > 0) {
+		return 0, fmt.Errorf("bad duration: %q", s)
+	}
+	if g.Durations != nil {
+		g.Durations.SetDuration(abs, d)
+	}
+	return d, nil
+}
@@ -0,0 +1,43 @@
+package thumb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheKeys(t *testing.T) {
+	mt := time.Unix(1700000000, 0)
+	base := cacheKey("/a.png", 256, mt, 100)
+	tests := []struct {
+		name string
+		key  string
+		same bool
+	}{
+		{"same file", cacheKey("/a.png", 256, mt, 100), true},
+		{"within the second", cacheKey("/a.png", 256, mt.Add(500*time.Millisecond), 100), true},
+		{"other path", cacheKey("/b.png", 256, mt, 100), false},
+		{"other size", cacheKey("/a.png", 128, mt, 100), false},
+		{"modified", cacheKey("/a.png", 256, mt.Add(time.Second), 100), false},
+		{"resized", cacheKey("/a.png", 256, mt, 101), false},
+		{"rect", cacheKeyRect("/a.png", 256, 256, mt, 100), false},
+		{"variant", subKey(base, "fail", "go"), false},
+		{"other variant", subKey(base, "fail", "go", ""), false},
+		{"frame", cacheKeyFrame("/a.png", 256, 256, 0, 1, mt, 100), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key == base; got != tt.same {
+				t.Errorf("key %s, same as base %v, want %v", tt.key, got, tt.same)
+			}
+		})
+	}
+	if a, b := cacheKeyRect("/a.png", 2, 12, mt, 100), cacheKeyRect("/a.png", 21, 2, mt, 100); a == b {
+		t.Error("cacheKeyRect runs width and height together")
+	}
+	if a, b := subKey(base, "fail", "go"), subKey(base, "fail", "go"); a != b {
+		t.Error("subKey is not stable")
+	}
+	if a, b := subKey(base, "ab", "c"), subKey(base, "a", "bc"); a == b {
+		t.Error("subKey runs its parts together")
+	}
+}
@@ -0,0 +1,73 @@
+package thumb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/ck-zhang/thumbgrid/internal/imgfile"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// SheetTile is one cell of a contact sheet: a thumbnail from a Generator,
+// or "" for a blank cell, and the caption under it.
+type SheetTile struct {
+	Thumb, Label string
+}
+
+// Contact sheet geometry, in pixels.
+const (
+	sheetPad    = 8
+	sheetLabelH = 16
+	sheetTitleH = 24
+)
+
+var (
+	sheetBG   = color.NRGBA{0x1e, 0x1e, 0x1e, 0xff}
+	sheetCell = color.NRGBA{0x2a, 0x2a, 0x2a, 0xff}
+	sheetText = color.NRGBA{0xdd, 0xdd, 0xdd, 0xff}
+)
+
+// ContactSheet lays tiles out cols to a row, each thumbnail w×h with its
+// label underneath (labels false leaves them off), below title unless it
+// is empty.
+func ContactSheet(tiles []SheetTile, cols, w, h int, labels bool, title string) *image.NRGBA {
+	cols = max(1, min(cols, len(tiles)))
+	rows := (len(tiles) + cols - 1) / cols
+	cellH, top := h, sheetPad
+	if labels {
+		cellH += sheetLabelH
+	}
+	if title != "" {
+		top += sheetTitleH
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, sheetPad+cols*(w+sheetPad), top+rows*(cellH+sheetPad)))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(sheetBG), image.Point{}, draw.Src)
+	text := func(s string, x, y, width int) {
+		d := font.Drawer{Dst: dst, Src: image.NewUniform(sheetText), Face: basicfont.Face7x13, Dot: fixed.P(x, y)}
+		if r := []rune(s); d.MeasureString(s).Ceil() > width {
+			n := max(0, width/basicfont.Face7x13.Advance-3)
+			s = string(r[:min(n, len(r))]) + "..."
+		}
+		d.DrawString(s)
+	}
+	if title != "" {
+		text(title, sheetPad, sheetPad+13, dst.Bounds().Dx()-2*sheetPad)
+	}
+	for i, t := range tiles {
+		at := image.Pt(sheetPad+(i%cols)*(w+sheetPad), top+(i/cols)*(cellH+sheetPad))
+		cell := image.Rectangle{at, at.Add(image.Pt(w, h))}
+		draw.Draw(dst, cell, image.NewUniform(sheetCell), image.Point{}, draw.Src)
+		if t.Thumb != "" {
+			if img, err := imgfile.Decode(t.Thumb); err == nil {
+				draw.Draw(dst, cell, img, img.Bounds().Min, draw.Over)
+			}
+		}
+		if labels {
+			text(t.Label, at.X, at.Y+h+13, w)
+		}
+	}
+	return dst
+}
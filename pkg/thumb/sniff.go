@@ -0,0 +1,50 @@
+package thumb
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// heifBrands are ISO-BMFF brands of still images; any other ftyp box is
+// taken for a video (mp4, mov, 3gp, ...).
+var heifBrands = map[string]bool{
+	"avif": true, "avis": true, "heic": true, "heix": true, "heim": true,
+	"heis": true, "hevc": true, "mif1": true, "msf1": true,
+}
+
+// Sniff classifies a file by its first bytes as "image" or "video", or ""
+// when it is neither, for files whose extension is missing or wrong.
+func Sniff(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf [16]byte
+	n, _ := io.ReadFull(f, buf[:])
+	b := buf[:n]
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")),
+		bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")),
+		bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")),
+		bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")),
+		bytes.HasPrefix(b, []byte("BM")) && n >= 14:
+		return "image"
+	case bytes.HasPrefix(b, []byte("\x1a\x45\xdf\xa3")):
+		return "video"
+	case n >= 12 && string(b[:4]) == "RIFF":
+		switch string(b[8:12]) {
+		case "WEBP":
+			return "image"
+		case "AVI ":
+			return "video"
+		}
+	case n >= 12 && string(b[4:8]) == "ftyp":
+		if heifBrands[string(b[8:12])] {
+			return "image"
+		}
+		return "video"
+	}
+	return ""
+}
@@ -0,0 +1,254 @@
+package thumb
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var xdgBuckets = []struct {
+	name string
+	size int
+}{
+	{"normal", 128},
+	{"large", 256},
+	{"x-large", 512},
+	{"xx-large", 1024},
+}
+
+func xdgDir() string {
+	if x := os.Getenv("XDG_CACHE_HOME"); x != "" {
+		return filepath.Join(x, "thumbnails")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache", "thumbnails")
+}
+
+func xdgURI(abs string) string {
+	return (&url.URL{Scheme: "file", Path: abs}).String()
+}
+
+func xdgName(uri string) string {
+	sum := md5.Sum([]byte(uri))
+	return hex.EncodeToString(sum[:]) + ".png"
+}
+
+func (g *Generator) xdgRect(ctx context.Context, abs string, info os.FileInfo, w, h int) ([]byte, error) {
+	need := max(w, h)
+	src, ok := xdgLookup(abs, info, need)
+	if !ok {
+		var err error
+		if src, err = g.xdgCreate(ctx, abs, info, need); err != nil {
+			return nil, err
+		}
+	}
+	return fitPNG(src, w, h)
+}
+
+func xdgLookup(abs string, info os.FileInfo, need int) (string, bool) {
+	uri := xdgURI(abs)
+	name := xdgName(uri)
+	mtime := strconv.FormatInt(info.ModTime().Unix(), 10)
+	for _, b := range xdgBuckets {
+		if b.size < need && b.name != "xx-large" {
+			continue
+		}
+		p := filepath.Join(xdgDir(), b.name, name)
+		text, err := pngText(p)
+		if err != nil {
+			continue
+		}
+		if text["Thumb::URI"] == uri && text["Thumb::MTime"] == mtime {
+			debugf("xdg hit (%s): %s", b.name, p)
+			return p, true
+		}
+	}
+	return "", false
+}
+
+func (g *Generator) xdgCreate(ctx context.Context, abs string, info os.FileInfo, need int) (string, error) {
+	bucket := xdgBuckets[len(xdgBuckets)-1]
+	for _, b := range xdgBuckets {
+		if b.size >= need {
+			bucket = b
+			break
+		}
+	}
+	dir := filepath.Join(xdgDir(), bucket.name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	data, err := g.generateFit(ctx, abs, bucket.size)
+	if err != nil {
+		return "", err
+	}
+	uri := xdgURI(abs)
+	data, err = insertPNGText(data, [][2]string{
+		{"Thumb::URI", uri},
+		{"Thumb::MTime", strconv.FormatInt(info.ModTime().Unix(), 10)},
+		{"Thumb::Size", strconv.FormatInt(info.Size(), 10)},
+		{"Software", "thumbgrid"},
+	})
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, xdgName(uri))
+	if err := writeAtomic(dest, data, 0o600); err != nil {
+		return "", err
+	}
+	debugf("xdg write (%s): %s", bucket.name, dest)
+	return dest, nil
+}
+
+func (g *Generator) generateFit(ctx context.Context, abs string, size int) ([]byte, error) {
+	for _, tool := range g.tools(abs) {
+		if !available(tool) {
+			continue
+		}
+		var data []byte
+		var err error
+		switch tool {
+		case "ffmpeg":
+			vf := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,format=rgba", size, size)
+			data, err = g.ffmpegRun(ctx, abs, vf)
+		case "vipsthumbnail":
+			data, err = vipsThumb(ctx, abs, size)
+		case "magick":
+			data, err = command(ctx, "magick", abs+srcFrameSuffix(abs), "-auto-orient", "-thumbnail", fmt.Sprintf("%dx%d>", size, size), "png:-").Output()
+		case "go":
+			data, err = goThumb(abs, size, size, false)
+		case "dcraw_emu", "dcraw":
+			data, err = rawThumb(ctx, tool, abs, size, size, false)
+		default:
+			continue
+		}
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errNoTool
+}
+
+func fitPNG(src string, w, h int) ([]byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return encodePNG(fitImage(img, w, h))
+}
+
+func fitImage(src image.Image, w, h int) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	b := src.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return dst
+	}
+	fw, fh := w, b.Dy()*w/b.Dx()
+	if fh > h {
+		fw, fh = b.Dx()*h/b.Dy(), h
+	}
+	fw, fh = max(1, fw), max(1, fh)
+	ox, oy := (w-fw)/2, (h-fh)/2
+	for y := 0; y < fh; y++ {
+		y0 := b.Min.Y + y*b.Dy()/fh
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/fh)
+		for x := 0; x < fw; x++ {
+			x0 := b.Min.X + x*b.Dx()/fw
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/fw)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(ox+x, oy+y)
+			a /= n
+			if a == 0 {
+				continue
+			}
+			// At returns premultiplied values; NRGBA wants them straight.
+			dst.Pix[i+0] = uint8((r / n) * 0xffff / a >> 8)
+			dst.Pix[i+1] = uint8((g / n) * 0xffff / a >> 8)
+			dst.Pix[i+2] = uint8((bl / n) * 0xffff / a >> 8)
+			dst.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return dst
+}
+
+var pngSig = []byte("\x89PNG\r\n\x1a\n")
+
+func pngText(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, pngSig) {
+		return nil, errors.New("not a png")
+	}
+	out := make(map[string]string)
+	for p := len(pngSig); p+8 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		typ := string(data[p+4 : p+8])
+		if p+12+n > len(data) {
+			break
+		}
+		if typ == "tEXt" {
+			if k, v, ok := bytes.Cut(data[p+8:p+8+n], []byte{0}); ok {
+				out[string(k)] = string(v)
+			}
+		}
+		if typ == "IDAT" || typ == "IEND" {
+			break
+		}
+		p += 12 + n
+	}
+	return out, nil
+}
+
+func insertPNGText(data []byte, kv [][2]string) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSig) || len(data) < len(pngSig)+8 {
+		return nil, errors.New("not a png")
+	}
+	ihdrEnd := len(pngSig) + 12 + int(binary.BigEndian.Uint32(data[len(pngSig):]))
+	if ihdrEnd > len(data) {
+		return nil, errors.New("truncated png")
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, e := range kv {
+		body := append(append([]byte("tEXt"+e[0]), 0), e[1]...)
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(body)-4))
+		buf.Write(hdr[:])
+		buf.Write(body)
+		binary.BigEndian.PutUint32(hdr[:], crc32.ChecksumIEEE(body))
+		buf.Write(hdr[:])
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes(), nil
+}